@@ -0,0 +1,111 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// cacheResult is the JSON result of the cache command
+type cacheResult struct {
+	OK      bool       `json:"ok"`
+	Command string     `json:"command"`
+	Action  string     `json:"action"`
+	Dir     string     `json:"dir"`
+	Entries int        `json:"entries"`
+	Bytes   int64      `json:"bytes"`
+	Quota   int64      `json:"quota"`
+	Oldest  *time.Time `json:"oldest,omitempty"`
+	Newest  *time.Time `json:"newest,omitempty"`
+	Corrupt int        `json:"corrupt,omitempty"`
+	Expired int        `json:"expired,omitempty"`
+	Evicted int        `json:"evicted,omitempty"`
+	Freed   int64      `json:"freed,omitempty"`
+}
+
+func cacheCommand() *command {
+	return &command{
+		name:    "cache",
+		args:    "<stats|clear|prune>",
+		choices: []string{"stats", "clear", "prune"},
+		summary: "Inspect and trim the local chunk cache",
+		examples: []string{
+			"cache stats                        # Size and age of the cache",
+			"cache prune --older-than 720h      # Drop pieces unused for 30 days",
+			"cache clear                        # Remove everything",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			olderThan := fs.Duration("older-than", 0, "With prune, also remove entries unused for this long (e.g. 168h)")
+
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s cache <stats|clear|prune>", a.execName)
+				}
+				action := args[0]
+				if *olderThan < 0 {
+					usage("--older-than must not be negative")
+				}
+				if *olderThan != 0 && action != "prune" {
+					usage("--older-than can only be used with prune")
+				}
+
+				config := a.config()
+				if config.CacheSizeMB <= 0 {
+					fail(exitFailure, "The chunk cache is disabled (cache_size_mb is %d)", config.CacheSizeMB)
+				}
+				cache := a.chunkCache()
+				if cache == nil {
+					fail(exitFailure, "The chunk cache is unavailable")
+				}
+
+				result := cacheResult{OK: true, Command: currentCommand, Action: action, Dir: cache.Dir()}
+				switch action {
+				case "stats":
+				case "clear":
+					freed, err := cache.Clear()
+					if err != nil {
+						fail(exitFailure, "%v", err)
+					}
+					result.Freed = freed
+					fmt.Fprintf(out, "Cleared %s from %s\n", formatSize(freed), cache.Dir())
+				case "prune":
+					pruned, err := cache.Prune(*olderThan)
+					if err != nil {
+						fail(exitFailure, "%v", err)
+					}
+					result.Corrupt, result.Expired, result.Evicted, result.Freed = pruned.Corrupt, pruned.Expired, pruned.Evicted, pruned.Freed
+					fmt.Fprintf(out, "Pruned %d corrupt, %d expired and %d over quota (%s freed)\n", pruned.Corrupt, pruned.Expired, pruned.Evicted, formatSize(pruned.Freed))
+				default:
+					usage("unknown cache action '%s'. Use '%s help cache' for usage.", action, a.execName)
+				}
+
+				stats, err := cache.Stats()
+				if err != nil {
+					fail(exitFailure, "%v", err)
+				}
+				result.Entries, result.Bytes, result.Quota = stats.Entries, stats.Bytes, stats.Quota
+				if stats.Entries > 0 {
+					oldest, newest := stats.Oldest.UTC(), stats.Newest.UTC()
+					result.Oldest, result.Newest = &oldest, &newest
+				}
+				if jsonMode {
+					printResult(result)
+					return
+				}
+				printCacheStats(stats)
+			}
+		},
+	}
+}
+
+func printCacheStats(stats finalride.CacheStats) {
+	fmt.Fprintf(out, "Cache:   %s\n", stats.Dir)
+	fmt.Fprintf(out, "Entries: %d\n", stats.Entries)
+	fmt.Fprintf(out, "Size:    %s of %s\n", formatSize(stats.Bytes), formatSize(stats.Quota))
+	if stats.Entries > 0 {
+		fmt.Fprintf(out, "Oldest:  %s\n", stats.Oldest.Local().Format("2006-01-02 15:04:05"))
+		fmt.Fprintf(out, "Newest:  %s\n", stats.Newest.Local().Format("2006-01-02 15:04:05"))
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+func completionCommand() *command {
+	return &command{
+		name:     "completion",
+		args:     "<bash|zsh|fish>",
+		choices:  []string{"bash", "zsh", "fish"},
+		summary:  "Generate a shell completion script",
+		noBanner: true,
+		examples: []string{
+			"completion bash > /etc/bash_completion.d/final-ride",
+			"completion zsh > \"${fpath[1]}/_final-ride\"",
+			"completion fish > ~/.config/fish/completions/final-ride.fish",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s completion <bash|zsh|fish>", a.execName)
+				}
+
+				var err error
+				switch args[0] {
+				case "bash":
+					err = writeBashCompletion(os.Stdout, a)
+				case "zsh":
+					err = writeZshCompletion(os.Stdout, a)
+				case "fish":
+					err = writeFishCompletion(os.Stdout, a)
+				default:
+					usage("unsupported shell '%s': use bash, zsh or fish", args[0])
+				}
+				if err != nil {
+					fail(exitFailure, "Failed to write completion script: %v", err)
+				}
+			}
+		},
+	}
+}
+
+// completionFlag is a flag as offered by completion scripts
+type completionFlag struct {
+	name   string
+	usage  string
+	hasArg bool
+}
+
+// fileFlags take a path, so completion offers file names for their value
+var fileFlags = map[string]bool{"config": true, "output": true, "o": true}
+
+// option returns the flag as typed on the command line
+func (f completionFlag) option() string {
+	if len(f.name) == 1 {
+		return "-" + f.name
+	}
+	return "--" + f.name
+}
+
+// commandFlags lists the flags of a command, optionally without the global ones
+func commandFlags(a *app, cmd *command, withGlobals bool) []completionFlag {
+	fs, _ := newFlagSet(a, cmd)
+
+	globals := map[string]bool{}
+	if !withGlobals {
+		for _, f := range globalFlags() {
+			globals[f.name] = true
+		}
+	}
+
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		if !globals[f.Name] {
+			flags = append(flags, completionFlag{name: f.Name, usage: f.Usage, hasArg: !isBoolFlag(f)})
+		}
+	})
+	return flags
+}
+
+func globalFlags() []completionFlag {
+	fs := flag.NewFlagSet("global", flag.ContinueOnError)
+	var opts globalOptions
+	opts.register(fs)
+
+	var flags []completionFlag
+	fs.VisitAll(func(f *flag.Flag) {
+		flags = append(flags, completionFlag{name: f.Name, usage: f.Usage, hasArg: !isBoolFlag(f)})
+	})
+	return flags
+}
+
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// completionFunc returns a shell function name derived from the executable name
+func completionFunc(execName string) string {
+	return "_" + nonIdentifier.ReplaceAllString(execName, "_")
+}
+
+func commandNames() []string {
+	var names []string
+	for _, cmd := range commands() {
+		names = append(names, cmd.name)
+	}
+	return names
+}
+
+// argChoices returns the fixed values of a command's first argument, if any
+func argChoices(cmd *command) []string {
+	if cmd.name == "help" {
+		return commandNames()
+	}
+	return cmd.choices
+}
+
+func writeBashCompletion(w io.Writer, a *app) error {
+	var b strings.Builder
+	fn := completionFunc(a.execName)
+
+	var valueFlags, pathFlags []string
+	seen := map[string]bool{}
+	for _, cmd := range commands() {
+		for _, f := range commandFlags(a, cmd, true) {
+			if !f.hasArg || seen[f.name] {
+				continue
+			}
+			seen[f.name] = true
+			valueFlags = append(valueFlags, f.option())
+			if fileFlags[f.name] {
+				pathFlags = append(pathFlags, f.option())
+			}
+		}
+	}
+	sort.Strings(valueFlags)
+	sort.Strings(pathFlags)
+
+	fmt.Fprintf(&b, "# bash completion for %s\n", a.execName)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    local cur prev cmd i\n")
+	b.WriteString("    cur=\"${COMP_WORDS[COMP_CWORD]}\"\n")
+	b.WriteString("    prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n\n")
+
+	b.WriteString("    case \"$prev\" in\n")
+	fmt.Fprintf(&b, "        %s)\n", strings.Join(pathFlags, "|"))
+	b.WriteString("            COMPREPLY=( $(compgen -f -- \"$cur\") )\n")
+	b.WriteString("            return ;;\n")
+	fmt.Fprintf(&b, "        %s)\n", strings.Join(valueFlags, "|"))
+	b.WriteString("            return ;;\n")
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    for ((i = 1; i < COMP_CWORD; i++)); do\n")
+	b.WriteString("        case \"${COMP_WORDS[i]}\" in\n")
+	fmt.Fprintf(&b, "            %s) ((i++)) ;;\n", strings.Join(valueFlags, "|"))
+	b.WriteString("            -*) ;;\n")
+	b.WriteString("            *) cmd=\"${COMP_WORDS[i]}\"; break ;;\n")
+	b.WriteString("        esac\n")
+	b.WriteString("    done\n\n")
+
+	b.WriteString("    local opts\n")
+	b.WriteString("    case \"$cmd\" in\n")
+	b.WriteString("        \"\")\n")
+	fmt.Fprintf(&b, "            COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(commandNames(), " "))
+	b.WriteString("            return ;;\n")
+	for _, cmd := range commands() {
+		var opts []string
+		for _, f := range commandFlags(a, cmd, true) {
+			opts = append(opts, f.option())
+		}
+		fmt.Fprintf(&b, "        %s) opts=\"%s\" ;;\n", cmd.name, strings.Join(opts, " "))
+	}
+	b.WriteString("    esac\n\n")
+
+	b.WriteString("    if [[ \"$cur\" == -* ]]; then\n")
+	b.WriteString("        COMPREPLY=( $(compgen -W \"$opts\" -- \"$cur\") )\n")
+	for _, cmd := range commands() {
+		if choices := argChoices(cmd); len(choices) > 0 {
+			fmt.Fprintf(&b, "    elif [[ \"$cmd\" == %s ]]; then\n", cmd.name)
+			fmt.Fprintf(&b, "        COMPREPLY=( $(compgen -W \"%s\" -- \"$cur\") )\n", strings.Join(choices, " "))
+		}
+	}
+	b.WriteString("    else\n")
+	b.WriteString("        COMPREPLY=( $(compgen -f -- \"$cur\") )\n")
+	b.WriteString("    fi\n")
+	b.WriteString("}\n")
+	fmt.Fprintf(&b, "complete -o filenames -F %s %s\n", fn, a.execName)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// zshQuote escapes text for a single-quoted zsh _arguments spec
+func zshQuote(s string) string {
+	s = strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(s)
+	return s
+}
+
+func zshFlagSpec(f completionFlag) string {
+	spec := fmt.Sprintf("'%s[%s]", f.option(), zshQuote(f.usage))
+	if f.hasArg && fileFlags[f.name] {
+		spec += ":" + f.name + ":_files"
+	} else if f.hasArg {
+		spec += ":" + f.name + ": "
+	}
+	return spec + "'"
+}
+
+func writeZshCompletion(w io.Writer, a *app) error {
+	var b strings.Builder
+	fn := completionFunc(a.execName)
+
+	fmt.Fprintf(&b, "#compdef %s\n\n", a.execName)
+	fmt.Fprintf(&b, "%s() {\n", fn)
+	b.WriteString("    local -a commands\n")
+	b.WriteString("    commands=(\n")
+	for _, cmd := range commands() {
+		fmt.Fprintf(&b, "        '%s:%s'\n", cmd.name, zshQuote(cmd.summary))
+	}
+	b.WriteString("    )\n\n")
+
+	b.WriteString("    local state\n")
+	b.WriteString("    _arguments -C \\\n")
+	for _, f := range globalFlags() {
+		fmt.Fprintf(&b, "        %s \\\n", zshFlagSpec(f))
+	}
+	b.WriteString("        '1:command:->command' \\\n")
+	b.WriteString("        '*::argument:->argument'\n\n")
+
+	b.WriteString("    case $state in\n")
+	b.WriteString("        command)\n")
+	b.WriteString("            _describe 'command' commands ;;\n")
+	b.WriteString("        argument)\n")
+	b.WriteString("            case $words[1] in\n")
+	for _, cmd := range commands() {
+		fmt.Fprintf(&b, "                %s)\n", cmd.name)
+		b.WriteString("                    _arguments \\\n")
+		for _, f := range commandFlags(a, cmd, false) {
+			fmt.Fprintf(&b, "                        %s \\\n", zshFlagSpec(f))
+		}
+		if choices := argChoices(cmd); len(choices) > 0 {
+			b.WriteString("                        '1:argument:(" + strings.Join(choices, " ") + ")' ;;\n")
+		} else {
+			b.WriteString("                        '*:file:_files' ;;\n")
+		}
+	}
+	b.WriteString("            esac ;;\n")
+	b.WriteString("    esac\n")
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "%s \"$@\"\n", fn)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// fishQuote escapes text for a single-quoted fish string
+func fishQuote(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s)
+}
+
+func fishFlag(f completionFlag) string {
+	line := ""
+	if len(f.name) == 1 {
+		line += " -s " + f.name
+	} else {
+		line += " -l " + f.name
+	}
+	if f.hasArg && fileFlags[f.name] {
+		line += " -r -F"
+	} else if f.hasArg {
+		line += " -x"
+	}
+	return line + fmt.Sprintf(" -d '%s'", fishQuote(f.usage))
+}
+
+func writeFishCompletion(w io.Writer, a *app) error {
+	var b strings.Builder
+	name := a.execName
+	names := strings.Join(commandNames(), " ")
+
+	fmt.Fprintf(&b, "# fish completion for %s\n", name)
+	fmt.Fprintf(&b, "complete -c %s -f\n", name)
+	for _, f := range globalFlags() {
+		fmt.Fprintf(&b, "complete -c %s%s\n", name, fishFlag(f))
+	}
+	for _, cmd := range commands() {
+		fmt.Fprintf(&b, "complete -c %s -n 'not __fish_seen_subcommand_from %s' -a %s -d '%s'\n", name, names, cmd.name, fishQuote(cmd.summary))
+	}
+	for _, cmd := range commands() {
+		condition := fmt.Sprintf("-n '__fish_seen_subcommand_from %s'", cmd.name)
+		for _, f := range commandFlags(a, cmd, false) {
+			fmt.Fprintf(&b, "complete -c %s %s%s\n", name, condition, fishFlag(f))
+		}
+		if choices := argChoices(cmd); len(choices) > 0 {
+			fmt.Fprintf(&b, "complete -c %s %s -a '%s'\n", name, condition, strings.Join(choices, " "))
+		} else {
+			fmt.Fprintf(&b, "complete -c %s %s -F\n", name, condition)
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
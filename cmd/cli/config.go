@@ -0,0 +1,238 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"final-ride/internal/finalride"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configResult is the JSON result of the config command
+type configResult struct {
+	OK       bool                   `json:"ok"`
+	Command  string                 `json:"command"`
+	Path     string                 `json:"path,omitempty"` // Config file, empty when running on defaults
+	Profile  string                 `json:"profile,omitempty"`
+	Settings map[string]interface{} `json:"settings,omitempty"`
+	Profiles []string               `json:"profiles,omitempty"`
+	Key      string                 `json:"key,omitempty"`
+	Value    string                 `json:"value,omitempty"`
+}
+
+func configCommand() *command {
+	return &command{
+		name:     "config",
+		args:     "<show|get|set|validate|init> [key] [value]",
+		choices:  []string{"show", "get", "set", "validate", "init"},
+		summary:  "Show, change and validate the configuration",
+		noBanner: true,
+		examples: []string{
+			"config show                        # Effective settings and where they come from",
+			"config get swarm_api               # A single setting",
+			"config set chunk_size_mb 50        # Change a setting in the config file",
+			"config validate                    # Check settings and that every endpoint answers",
+			"config init                        # Write a default file to the user config dir",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			force := fs.Bool("force", false, "Overwrite an existing config file (init)")
+			offline := fs.Bool("offline", false, "Skip the endpoint reachability check (validate)")
+
+			return func(a *app, args []string) {
+				if len(args) == 0 {
+					usage("Usage: %s config <show|get|set|validate|init> [key] [value]", a.execName)
+				}
+
+				switch action, args := args[0], args[1:]; action {
+				case "show":
+					expectArgs(a, args, 0, "config show")
+					configShow(a)
+				case "get":
+					expectArgs(a, args, 1, "config get <key>")
+					configGet(a, args[0])
+				case "set":
+					expectArgs(a, args, 2, "config set <key> <value>")
+					configSet(a, args[0], args[1])
+				case "validate":
+					expectArgs(a, args, 0, "config validate")
+					configValidate(a, *offline)
+				case "init":
+					expectArgs(a, args, 0, "config init")
+					configInit(a, *force)
+				default:
+					usage("unknown config action '%s'. Use '%s help config' for usage.", action, a.execName)
+				}
+			}
+		},
+	}
+}
+
+func expectArgs(a *app, args []string, n int, synopsis string) {
+	if len(args) != n {
+		usage("Usage: %s %s", a.execName, synopsis)
+	}
+}
+
+// configFilePath returns the file that config set and init write to
+func configFilePath(a *app, search bool) string {
+	path := a.opts.configPath
+	if path == "" {
+		path = os.Getenv(finalride.ConfigPathEnv)
+	}
+	if path == "" && search {
+		found, err := finalride.FindConfig("")
+		if err != nil {
+			fail(exitFailure, "Failed to locate configuration: %v", err)
+		}
+		path = found
+	}
+	if path == "" {
+		var err error
+		if path, err = finalride.DefaultConfigPath(); err != nil {
+			fail(exitFailure, "Failed to locate configuration: %v", err)
+		}
+	}
+	return path
+}
+
+// settingsYAML encodes the settings without the profile definitions
+func settingsYAML(config *finalride.Config) []byte {
+	settings := *config
+	settings.Profiles = nil
+
+	data, err := yaml.Marshal(&settings)
+	if err != nil {
+		fail(exitFailure, "Failed to encode configuration: %v", err)
+	}
+	return data
+}
+
+// settingsMap returns the settings keyed by their YAML names
+func settingsMap(config *finalride.Config) map[string]interface{} {
+	var m map[string]interface{}
+	if err := yaml.Unmarshal(settingsYAML(config), &m); err != nil {
+		fail(exitFailure, "Failed to encode configuration: %v", err)
+	}
+	return m
+}
+
+func configShow(a *app) {
+	config := a.config()
+
+	if jsonMode {
+		printResult(configResult{
+			OK:       true,
+			Command:  currentCommand,
+			Path:     a.cfgPath,
+			Profile:  config.Profile,
+			Settings: settingsMap(config),
+			Profiles: config.ProfileNames(),
+		})
+		return
+	}
+
+	if a.cfgPath != "" {
+		fmt.Printf("# Config file: %s\n", a.cfgPath)
+	} else {
+		fmt.Println("# Config file: none (built-in defaults)")
+	}
+	if config.Profile != "" {
+		fmt.Printf("# Profile: %s\n", config.Profile)
+	}
+	for _, key := range finalride.ConfigKeys() {
+		if _, ok := os.LookupEnv(finalride.EnvName(key)); ok {
+			fmt.Printf("# %s is set from %s\n", key, finalride.EnvName(key))
+		}
+	}
+
+	fmt.Print(string(settingsYAML(config)))
+	if names := config.ProfileNames(); len(names) > 0 {
+		fmt.Println("# Profiles:")
+		for _, name := range names {
+			fmt.Printf("#   %s\n", name)
+		}
+	}
+}
+
+func configGet(a *app, key string) {
+	value, err := a.config().Get(key)
+	if err != nil {
+		usage("%v", err)
+	}
+
+	if jsonMode {
+		printResult(configResult{OK: true, Command: currentCommand, Path: a.cfgPath, Key: key, Value: value})
+		return
+	}
+	fmt.Println(value)
+}
+
+func configSet(a *app, key, value string) {
+	if a.opts.profile != "" {
+		usage("config set changes the base settings; edit profiles in the config file directly")
+	}
+
+	path := configFilePath(a, true)
+	err := finalride.UpdateConfigFile(path, func(config *finalride.Config) error {
+		return config.Set(key, value)
+	})
+	if err != nil {
+		fail(exitFailure, "Failed to set %s: %v", key, err)
+	}
+
+	if _, ok := os.LookupEnv(finalride.EnvName(key)); ok {
+		fmt.Fprintf(os.Stderr, "Warning: %s is set and overrides this value\n", finalride.EnvName(key))
+	}
+	if jsonMode {
+		printResult(configResult{OK: true, Command: currentCommand, Path: path, Key: key, Value: value})
+		return
+	}
+	fmt.Printf("Set %s = %s in %s\n", key, value, path)
+}
+
+func configValidate(a *app, offline bool) {
+	config, path, err := a.resolveConfig()
+	if err != nil {
+		fail(exitFailure, "Invalid configuration: %v", err)
+	}
+	if err := config.ValidateProfiles(); err != nil {
+		fail(exitFailure, "Invalid configuration: %v", err)
+	}
+
+	if !offline {
+		pool := finalride.NewConfigPool(config)
+		for _, status := range pool.Ping(10 * time.Second) {
+			if !status.Healthy {
+				fail(exitNetwork, "Swarm endpoint %s is not reachable: %s", status.URL, status.LastError)
+			}
+		}
+	}
+
+	if jsonMode {
+		printResult(configResult{OK: true, Command: currentCommand, Path: path, Profile: config.Profile})
+		return
+	}
+	if path == "" {
+		path = "built-in defaults"
+	}
+	fmt.Printf("Configuration OK (%s)\n", path)
+}
+
+func configInit(a *app, force bool) {
+	path := configFilePath(a, false)
+	if _, err := os.Stat(path); err == nil && !force {
+		fail(exitFailure, "%s already exists (use --force to overwrite)", path)
+	}
+	if err := finalride.SaveConfig(path, finalride.DefaultConfig()); err != nil {
+		fail(exitFailure, "Failed to write configuration: %v", err)
+	}
+
+	if jsonMode {
+		printResult(configResult{OK: true, Command: currentCommand, Path: path})
+		return
+	}
+	fmt.Printf("Wrote default configuration to %s\n", path)
+}
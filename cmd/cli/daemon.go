@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"final-ride/internal/finalride"
+)
+
+func daemonCommand() *command {
+	return &command{
+		name:    "daemon",
+		summary: "Run the REST API for uploads and downloads",
+		examples: []string{
+			"daemon                             # http://127.0.0.1:8081/v1, token from daemon_token",
+			"FINAL_RIDE_DAEMON_TOKEN=s3cret daemon --addr :8081",
+			"daemon --max-upload-mb 2048 --spool-dir /var/tmp",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			addr := fs.String("addr", "127.0.0.1:8081", "Address to listen on")
+			maxUpload := fs.Int64("max-upload-mb", 0, "Largest upload accepted, in MB (0 for no limit)")
+			spoolDir := fs.String("spool-dir", "", "Where uploads wait for the transfer (default: the system temp dir)")
+
+			return func(a *app, args []string) {
+				expectArgs(a, args, 0, "daemon [--addr host:port]")
+				if *maxUpload < 0 {
+					usage("--max-upload-mb must not be negative")
+				}
+				config := a.config()
+				if config.DaemonToken == "" {
+					usage("daemon_token is not set; set it with '%s config set daemon_token <token>' or %s", a.execName, finalride.EnvName("daemon_token"))
+				}
+				historyPath, _ := finalride.DefaultHistoryPath()
+				handler := finalride.NewDaemon(config, finalride.DaemonOptions{
+					Token:       config.DaemonToken,
+					MaxUpload:   *maxUpload * 1024 * 1024,
+					SpoolDir:    *spoolDir,
+					HistoryPath: historyPath,
+				})
+				listenAndServe(*addr, handler, func(url string) {
+					stage("daemon", "Serving the API on %sv1 (Ctrl+C to stop)", url)
+					fmt.Fprintf(out, "OpenAPI:  %sv1/openapi.json\n", url)
+					fmt.Fprintf(out, "Gateway:  %s\n", config.SwarmAPI)
+				})
+			}
+		},
+	}
+}
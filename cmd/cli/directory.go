@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// lsResult is the JSON result of the ls command
+type lsResult struct {
+	OK        bool      `json:"ok"`
+	Command   string    `json:"command"`
+	CID       string    `json:"cid"`
+	Name      string    `json:"name"`
+	Directory bool      `json:"directory"`                // False for a single file share
+	Bee       bool      `json:"bee_collection,omitempty"` // A Bee /bzz collection rather than a sync manifest
+	Previous  string    `json:"previous,omitempty"`
+	Files     []lsEntry `json:"files"`
+	Total     int64     `json:"total"` // Size of the listed files
+}
+
+type lsEntry struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode,omitempty"`
+	ModTime time.Time `json:"mtime,omitempty"`
+	SHA256  string    `json:"sha256,omitempty"`
+	CID     string    `json:"cid"`
+}
+
+// restoreResult is the JSON result of downloading a directory share
+type restoreResult struct {
+	OK       bool            `json:"ok"`
+	Command  string          `json:"command"`
+	CID      string          `json:"cid"`
+	Dir      string          `json:"dir"`
+	Verified int             `json:"verified"`
+	Skipped  int             `json:"skipped"`
+	Failed   int             `json:"failed"`
+	Size     int64           `json:"size"` // Bytes downloaded and verified
+	Files    []restoredEntry `json:"files"`
+	Timings  timings         `json:"timings_ms"`
+}
+
+type restoredEntry struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+func lsCommand() *command {
+	return &command{
+		name:    "ls",
+		args:    "<cid|url>",
+		summary: "List the files of a sync manifest or Bee /bzz collection, without downloading them",
+		examples: []string{
+			"ls <manifest>                      # Paths, sizes and times of a sync manifest",
+			"ls <collection>                    # Paths and sizes of a tar or multi-file /bzz upload",
+			"ls <manifest> --include 'logs/**' --exclude '*.tmp'",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			var include, exclude stringList
+			fs.Var(&include, "include", "Only list paths matching this pattern (repeatable)")
+			fs.Var(&exclude, "exclude", "Skip paths matching this pattern (repeatable)")
+
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s ls [options] <cid|url>", a.execName)
+				}
+				filter := finalride.PathFilter{Include: include, Exclude: exclude}
+				if err := filter.Validate(); err != nil {
+					usage("%v", err)
+				}
+				runLs(a, finalride.ParseReference(args[0]), filter)
+			}
+		},
+	}
+}
+
+func runLs(a *app, cid string, filter finalride.PathFilter) {
+	store := finalride.NewCachedStore(a.store(), a.chunkCache())
+	d, data, err := finalride.FetchShare(cid, store)
+	if err != nil {
+		fail(transferExitCode(err), "Failed to fetch metadata: %v", err)
+	}
+
+	result := lsResult{OK: true, Command: currentCommand, CID: cid, Files: []lsEntry{}}
+	if d != nil {
+		result.Name, result.Previous, result.Directory = d.Name, d.Previous, true
+		result.Bee = d.Type == finalride.CollectionType
+		for _, f := range d.Files {
+			if !filter.Match(f.Path) {
+				continue
+			}
+			entry := lsEntry{Path: f.Path, Size: f.Size, ModTime: f.ModTime, SHA256: f.Hash, CID: f.Reference}
+			if f.Mode != 0 {
+				entry.Mode = f.Mode.String()
+			}
+			result.Files = append(result.Files, entry)
+		}
+	} else {
+		// A single file share lists as its one file
+		metadata, err := finalride.ParseMetadata(data)
+		if err != nil {
+			fail(metadataExitCode(err), "Failed to parse metadata: %v", err)
+		}
+		result.Name = metadata.Filename
+		if filter.Match(metadata.Filename) {
+			result.Files = append(result.Files, lsEntry{Path: metadata.Filename, Size: metadata.Size, SHA256: metadata.FileHash, CID: cid})
+		}
+	}
+	for _, f := range result.Files {
+		result.Total += f.Size
+	}
+
+	if jsonMode {
+		printResult(result)
+		return
+	}
+	for _, f := range result.Files {
+		modTime := ""
+		if !f.ModTime.IsZero() {
+			modTime = f.ModTime.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(out, "%-10s %10s  %-16s  %s\n", f.Mode, formatSize(f.Size), modTime, f.Path)
+	}
+	fmt.Fprintf(out, "%d files, %s\n", len(result.Files), formatSize(result.Total))
+}
+
+// runDirectoryDownload restores the files of a sync manifest or Bee collection
+// that pass the filter into output, by default a folder named after the
+// directory or, for a collection, its reference
+func runDirectoryDownload(a *app, cid string, d *finalride.Directory, output string, filter finalride.PathFilter, store finalride.Store, totalStart time.Time, steps timings) {
+	config := a.config()
+	if output == "-" {
+		usage("A directory share cannot be written to standard output")
+	}
+	if output == "" {
+		// The name comes from the manifest, so only its last element is used
+		output = filepath.Base(filepath.FromSlash(d.Name))
+		if output == "." || output == ".." || output == string(filepath.Separator) {
+			output = cid
+		}
+	}
+
+	var selected int
+	var selectedSize int64
+	for _, f := range d.Files {
+		if filter.Match(f.Path) {
+			selected++
+			selectedSize += f.Size
+		}
+	}
+	fmt.Fprintln(out, "----------------------------------------")
+	name := d.Name
+	if d.Type == finalride.CollectionType {
+		name = "Bee collection " + cid
+	}
+	fmt.Fprintf(out, "Directory:   %s\n", name)
+	fmt.Fprintf(out, "Files:       %d of %d selected (%s)\n", selected, len(d.Files), formatSize(selectedSize))
+	fmt.Fprintf(out, "Output:      %s\n", output)
+	fmt.Fprintln(out, "----------------------------------------")
+
+	stage("download", "[2/2] Downloading %d files...", selected)
+	downloadStart := time.Now()
+	bar := newByteProgress(selectedSize, "download", "Downloading     ")
+	result := restoreResult{OK: true, Command: currentCommand, CID: cid, Dir: output, Files: []restoredEntry{}}
+	failCode := exitOK
+	finalride.RestoreDirectory(d, output, finalride.RestoreOptions{
+		Filter: filter,
+		Store:  store,
+		OnFile: func(r finalride.RestoredFile) {
+			if !filter.Match(r.Entry.Path) {
+				result.Skipped++
+				return
+			}
+			bar.Add(int(r.Entry.Size))
+			switch r.Status {
+			case finalride.RestoreVerified:
+				result.Verified++
+				result.Size += r.Entry.Size
+			case finalride.RestoreSkipped:
+				result.Skipped++
+			case finalride.RestoreFailed:
+				result.Failed++
+				if failCode == exitOK {
+					failCode = transferExitCode(r.Err)
+				}
+			}
+			result.Files = append(result.Files, restoredEntry{Path: r.Entry.Path, Size: r.Entry.Size, Status: r.Status, Reason: r.Reason, Error: errorString(r.Err)})
+		},
+	})
+	downloadDuration := time.Since(downloadStart)
+	steps.record("download", downloadDuration)
+	steps.record("total", time.Since(totalStart))
+	result.Timings = steps
+	result.OK = result.Failed == 0
+
+	fmt.Fprintln(out, "\n========================================")
+	fmt.Fprintln(out, "RESTORE SUMMARY")
+	fmt.Fprintln(out, "========================================")
+	for _, f := range result.Files {
+		switch f.Status {
+		case finalride.RestoreVerified:
+			fmt.Fprintf(out, "verified  %s\n", f.Path)
+		case finalride.RestoreSkipped:
+			fmt.Fprintf(out, "skipped   %s (%s)\n", f.Path, f.Reason)
+		case finalride.RestoreFailed:
+			fmt.Fprintf(out, "FAILED    %s: %s\n", f.Path, f.Error)
+		}
+	}
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "Verified: %d (%s in %s)\n", result.Verified, formatSize(result.Size), formatDuration(downloadDuration))
+	fmt.Fprintf(out, "Skipped:  %d (%d filtered out)\n", result.Skipped, len(d.Files)-selected)
+	fmt.Fprintf(out, "Failed:   %d\n", result.Failed)
+	fmt.Fprintf(out, "Saved to: %s\n", output)
+
+	// Collections have no name of their own
+	historyName := d.Name
+	if historyName == "" {
+		historyName = filepath.Base(output)
+	}
+	recordHistory(finalride.HistoryEntry{
+		Action:   finalride.HistoryDownload,
+		Filename: historyName + "/",
+		Size:     result.Size,
+		CID:      cid,
+		Gateway:  config.SwarmAPI,
+		Link:     fmt.Sprintf(config.DownloadLink, cid),
+	})
+
+	if jsonMode {
+		printResult(result)
+	}
+	if result.Failed > 0 {
+		if !jsonMode {
+			log.Printf("%d of %d files could not be restored", result.Failed, selected)
+		}
+		os.Exit(failCode)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// downloadResult is the JSON result of the download command
+type downloadResult struct {
+	OK          bool              `json:"ok"`
+	Command     string            `json:"command"`
+	CID         string            `json:"cid"`
+	File        string            `json:"file"`
+	Size        int64             `json:"size"`
+	Encrypted   bool              `json:"encrypted"`
+	Chunked     bool              `json:"chunked"`
+	Chunks      int               `json:"chunks"`
+	FileHash    string            `json:"file_hash,omitempty"`
+	ChunkHashes map[string]string `json:"chunk_hashes,omitempty"`
+	CacheHits   int64             `json:"cache_hits"` // Pieces served from the chunk cache
+	Timings     timings           `json:"timings_ms"`
+}
+
+func downloadCommand() *command {
+	return &command{
+		name:    "download",
+		args:    "<cid|url>",
+		summary: "Download file from Swarm (auto-detects encryption)",
+		examples: []string{
+			"download QmXxxx...                 # Download (auto-detects encryption)",
+			"download QmXxxx... --output ~/in/  # Save into a directory",
+			"download QmXxxx... -o report.pdf   # Save under a different name",
+			"download QmXxxx... -o - | tar x    # Write to standard output",
+			"download --feed nightly --owner <addr> # Latest version published to a feed",
+			"download --feed nightly --index 2  # An earlier version of your own feed",
+			"download QmXxxx... --no-cache      # Ignore locally cached pieces",
+			"download <manifest> --include 'logs/**' --exclude '*.tmp' -o ./restore # Part of a sync manifest",
+			"download <collection> --include '*.csv' # Part of a tar or multi-file /bzz upload",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			var output string
+			setOutput := func(value string) error {
+				output = value
+				if value == "-" {
+					reserveStdout()
+				}
+				return nil
+			}
+			fs.Func("output", "Output file or directory, or \"-\" for standard output (default: the original filename in the current directory)", setOutput)
+			fs.Func("o", "Shorthand for --output", setOutput)
+			feed := fs.String("feed", "", "Download the version a feed points to instead of a CID")
+			owner := fs.String("owner", "", "With --feed, the feed owner address (default: your identity)")
+			identity := fs.String("identity", "", "With --feed, the key whose address owns the feed (default: identity.key in the user config dir)")
+			index := fs.Int64("index", -1, "With --feed, the version to download (default: the latest)")
+			noCache := fs.Bool("no-cache", false, "Fetch every piece from the network, bypassing the chunk cache")
+			var include, exclude stringList
+			fs.Var(&include, "include", "For sync manifests and Bee collections, only restore paths matching this pattern (repeatable)")
+			fs.Var(&exclude, "exclude", "For sync manifests and Bee collections, skip paths matching this pattern (repeatable)")
+
+			return func(a *app, args []string) {
+				filter := finalride.PathFilter{Include: include, Exclude: exclude}
+				if err := filter.Validate(); err != nil {
+					usage("%v", err)
+				}
+				if *feed == "" {
+					if len(args) != 1 {
+						usage("Usage: %s download [options] <cid|url>", a.execName)
+					}
+					if *owner != "" || *index != -1 {
+						usage("--owner and --index require --feed")
+					}
+					runDownload(a, args[0], output, filter, !*noCache)
+					return
+				}
+
+				if len(args) != 0 {
+					usage("Usage: %s download [options] --feed <name>", a.execName)
+				}
+				if *index < -1 {
+					usage("--index must not be negative")
+				}
+				update := resolveFeed(a, *feed, feedOwner(*owner, *identity), *index)
+				fmt.Fprintf(out, "Feed %q version %d, published %s\n", *feed, update.Index, update.Time.Local().Format("2006-01-02 15:04:05"))
+				runDownload(a, update.Reference, output, filter, !*noCache)
+			}
+		},
+	}
+}
+
+// outputPath resolves where a download is saved. An existing directory
+// receives the original filename; anything else is used as the file path.
+func outputPath(output string, filename string) string {
+	if output == "" {
+		return filename
+	}
+	if info, err := os.Stat(output); err == nil && info.IsDir() {
+		return filepath.Join(output, filename)
+	}
+	return output
+}
+
+func runDownload(a *app, input string, output string, filter finalride.PathFilter, useCache bool) {
+	config := a.config()
+	var cache *finalride.Cache
+	if useCache {
+		cache = a.chunkCache()
+	}
+	store := finalride.NewCachedStore(a.store(), cache)
+
+	metadataCID := finalride.ParseReference(input)
+	if metadataCID != input {
+		fmt.Fprintf(out, "Extracted CID from URL: %s\n", metadataCID)
+	}
+
+	totalStart := time.Now()
+	steps := timings{}
+
+	fmt.Fprintln(out, "========================================")
+	fmt.Fprintln(out, "DOWNLOAD STARTED")
+	fmt.Fprintln(out, "========================================")
+	fmt.Fprintf(out, "Metadata CID: %s\n", metadataCID)
+
+	stage("metadata", "[1/2] Downloading metadata...")
+	metadataStart := time.Now()
+	d, data, err := finalride.FetchShare(metadataCID, store)
+	if err != nil {
+		fail(transferExitCode(err), "Failed to fetch metadata: %v", err)
+	}
+	metadataDuration := time.Since(metadataStart)
+	steps.record("metadata", metadataDuration)
+	fmt.Fprintf(out, "      Metadata downloaded in %s\n", formatDuration(metadataDuration))
+
+	if d != nil {
+		runDirectoryDownload(a, metadataCID, d, output, filter, store, totalStart, steps)
+		return
+	}
+	if len(filter.Include) > 0 || len(filter.Exclude) > 0 {
+		usage("--include and --exclude only apply to sync manifests and Bee /bzz collections")
+	}
+	metadata, err := finalride.ParseMetadata(data)
+	if err != nil {
+		fail(metadataExitCode(err), "Failed to fetch metadata: %v", err)
+	}
+
+	fmt.Fprintln(out, "\n----------------------------------------")
+	fmt.Fprintln(out, "FILE INFORMATION")
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "Filename:    %s\n", metadata.Filename)
+	fmt.Fprintf(out, "Encrypted:   %v\n", metadata.Encrypted)
+	fmt.Fprintf(out, "Chunked:     %v\n", metadata.Chunked)
+	if metadata.Chunked {
+		fmt.Fprintf(out, "Chunks:      %d\n", len(metadata.ChunkIDs))
+	}
+	fmt.Fprintln(out, "----------------------------------------")
+
+	outputFile := "-"
+	var dest io.Writer = os.Stdout
+	var file *os.File
+	if output != "-" {
+		outputFile = outputPath(output, metadata.Filename)
+		file, err = os.Create(outputFile)
+		if err != nil {
+			fail(exitFailure, "Failed to create file: %v", err)
+		}
+		dest = file
+	}
+	writer := &trackedWriter{w: dest}
+
+	// abort removes a partially written file before exiting
+	abort := func(code int, format string, args ...interface{}) {
+		if file != nil {
+			file.Close()
+			os.Remove(outputFile)
+		}
+		fail(code, format, args...)
+	}
+
+	stage("download", "[2/2] Downloading %d pieces...", metadata.ChunkCount())
+	downloadStart := time.Now()
+	bar := newCountProgress(int64(metadata.ChunkCount()), "download", "Downloading     ")
+
+	written, err := finalride.DownloadStream(metadata, store, writer, func(n int64) { bar.Add(1) })
+	if writer.err != nil {
+		abort(exitFailure, "Failed to save file: %v", writer.err)
+	}
+	if err != nil {
+		abort(transferExitCode(err), "Download failed: %v", err)
+	}
+	if file != nil {
+		if err := file.Close(); err != nil {
+			abort(exitFailure, "Failed to save file: %v", err)
+		}
+	}
+
+	downloadDuration := time.Since(downloadStart)
+	steps.record("download", downloadDuration)
+	downloadSpeed := float64(written) / downloadDuration.Seconds()
+	fmt.Fprintf(out, "      Download complete: %s in %s (%s)\n", formatSize(written), formatDuration(downloadDuration), formatSpeed(downloadSpeed))
+	fmt.Fprintln(out, "      Integrity check: PASSED")
+
+	totalDuration := time.Since(totalStart)
+	steps.record("total", totalDuration)
+	avgSpeed := float64(written) / totalDuration.Seconds()
+
+	fmt.Fprintln(out, "\n========================================")
+	fmt.Fprintln(out, "DOWNLOAD SUCCESSFUL!")
+	fmt.Fprintln(out, "========================================")
+	if file != nil {
+		fmt.Fprintf(out, "File saved: %s\n", outputFile)
+	} else {
+		fmt.Fprintln(out, "File written to standard output")
+	}
+	fmt.Fprintf(out, "Size: %s\n", formatSize(written))
+	fmt.Fprintf(out, "Encrypted: %v\n", metadata.Encrypted)
+	var cacheHits int64
+	if cache != nil {
+		cacheHits, _ = cache.Counts()
+		fmt.Fprintf(out, "From cache: %d of %d pieces\n", cacheHits, metadata.ChunkCount())
+	}
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "Total time: %s\n", formatDuration(totalDuration))
+	fmt.Fprintf(out, "Average speed: %s\n", formatSpeed(avgSpeed))
+	fmt.Fprintln(out, "========================================")
+
+	recordHistory(finalride.HistoryEntry{
+		Action:      finalride.HistoryDownload,
+		Filename:    metadata.Filename,
+		Size:        written,
+		CID:         metadataCID,
+		Encrypted:   metadata.Encrypted,
+		KeyHandling: finalride.KeyHandlingFor(metadata),
+		Gateway:     config.SwarmAPI,
+		Link:        fmt.Sprintf(config.DownloadLink, metadataCID),
+	})
+
+	if jsonMode {
+		printResult(downloadResult{
+			OK:          true,
+			Command:     currentCommand,
+			CID:         metadataCID,
+			File:        outputFile,
+			Size:        written,
+			Encrypted:   metadata.Encrypted,
+			Chunked:     metadata.Chunked,
+			Chunks:      metadata.ChunkCount(),
+			FileHash:    metadata.FileHash,
+			ChunkHashes: metadata.ChunkHashes,
+			CacheHits:   cacheHits,
+			Timings:     steps,
+		})
+	}
+}
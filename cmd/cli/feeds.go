@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// feedResult is the JSON result of the feed command
+type feedResult struct {
+	OK       bool         `json:"ok"`
+	Command  string       `json:"command"`
+	Action   string       `json:"action"`
+	Feed     string       `json:"feed"`
+	Owner    string       `json:"owner"`
+	Manifest string       `json:"manifest,omitempty"` // Stable reference that resolves to the latest update
+	Link     string       `json:"link,omitempty"`
+	Updates  []feedUpdate `json:"updates"`
+}
+
+type feedUpdate struct {
+	Index uint64    `json:"index"`
+	Time  time.Time `json:"time"`
+	CID   string    `json:"cid"`
+}
+
+// feedInfo reports the feed an upload was published to
+type feedInfo struct {
+	Name     string `json:"name"`
+	Owner    string `json:"owner"`
+	Index    uint64 `json:"index"`
+	Manifest string `json:"manifest"`
+	Link     string `json:"link"`
+
+	published time.Time
+}
+
+func feedCommand() *command {
+	return &command{
+		name:    "feed",
+		args:    "<publish|show|history> <name> [cid]",
+		choices: []string{"publish", "show", "history"},
+		summary: "Publish shares to a feed with a stable link",
+		examples: []string{
+			"feed publish nightly <CID>         # Point the nightly feed at a share",
+			"feed show nightly                  # Latest version",
+			"feed show nightly --index 3        # A specific version",
+			"feed history nightly --owner <addr> # Every version of someone else's feed",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			owner := fs.String("owner", "", "Feed owner address (default: the address of --identity)")
+			identity := fs.String("identity", "", "Key that signs feed updates (default: identity.key in the user config dir)")
+			index := fs.Int64("index", -1, "Version to show instead of the latest (show)")
+
+			return func(a *app, args []string) {
+				if len(args) == 0 {
+					usage("Usage: %s feed <publish|show|history> <name> [cid]", a.execName)
+				}
+				if *index < -1 {
+					usage("--index must not be negative")
+				}
+
+				switch action, args := args[0], args[1:]; action {
+				case "publish":
+					expectArgs(a, args, 2, "feed publish <name> <cid|url>")
+					if *owner != "" {
+						usage("--owner cannot be used with publish; updates are signed by --identity")
+					}
+					info, err := publishFeed(a, *identity, args[0], finalride.ParseReference(args[1]))
+					if err != nil {
+						fail(exitNetwork, "%v", err)
+					}
+					if jsonMode {
+						printResult(feedResult{OK: true, Command: currentCommand, Action: action, Feed: info.Name, Owner: info.Owner, Manifest: info.Manifest, Link: info.Link,
+							Updates: []feedUpdate{{Index: info.Index, Time: info.published.UTC(), CID: finalride.ParseReference(args[1])}}})
+					}
+				case "show", "history":
+					expectArgs(a, args, 1, "feed "+action+" <name>")
+					showFeed(a, action, args[0], feedOwner(*owner, *identity), *index)
+				default:
+					usage("unknown feed action '%s'. Use '%s help feed' for usage.", action, a.execName)
+				}
+			}
+		},
+	}
+}
+
+// loadIdentity loads the feed signing key, creating it on first use
+func loadIdentity(path string) *finalride.Identity {
+	if path == "" {
+		var err error
+		path, err = finalride.DefaultIdentityPath()
+		if err != nil {
+			fail(exitFailure, "Failed to locate identity: %v", err)
+		}
+	}
+	created := false
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		created = true
+	}
+	id, err := finalride.LoadIdentity(path)
+	if err != nil {
+		fail(exitFailure, "%v", err)
+	}
+	if created {
+		fmt.Fprintf(os.Stderr, "Created feed identity %s (owner %s); keep it safe, it is the only way to update your feeds\n", path, id.Owner())
+	}
+	return id
+}
+
+// feedOwner resolves --owner, falling back to the local identity
+func feedOwner(owner string, identity string) string {
+	if owner == "" {
+		return loadIdentity(identity).Owner()
+	}
+	owner, err := finalride.ParseOwner(owner)
+	if err != nil {
+		usage("%v", err)
+	}
+	return owner
+}
+
+// publishFeed points a feed at a share on swarm_api and returns its stable link
+func publishFeed(a *app, identity string, name string, metadataCID string) (feedInfo, error) {
+	config := a.config()
+	node := config.SwarmAPI
+	id := loadIdentity(identity)
+	topic := finalride.FeedTopic(name)
+
+	// A feed update and its manifest take one stamp slot each
+	batch := ""
+	if stamps, err := a.store().PrepareStamps(config.PostageBatch, 2); err == nil {
+		batch = stamps[node].BatchID
+	}
+
+	stage("feed", "Publishing to feed %q...", name)
+	update, err := finalride.PublishFeed(id, topic, metadataCID, batch, node)
+	if err != nil {
+		return feedInfo{}, fmt.Errorf("failed to publish feed update: %v", err)
+	}
+	manifest, err := finalride.CreateFeedManifest(id.Owner(), topic, batch, node)
+	if err != nil {
+		return feedInfo{}, fmt.Errorf("failed to create feed manifest: %v", err)
+	}
+
+	info := feedInfo{Name: name, Owner: id.Owner(), Index: update.Index, Manifest: manifest, Link: fmt.Sprintf(config.DownloadLink, manifest), published: update.Time}
+	fmt.Fprintf(out, "Feed:        %s (version %d, owner %s)\n", name, update.Index, info.Owner)
+	fmt.Fprintf(out, "Feed link:   %s\n", info.Link)
+	return info, nil
+}
+
+// resolveFeed returns the update of a feed at index, or the latest if index is negative
+func resolveFeed(a *app, name string, owner string, index int64) *finalride.FeedUpdate {
+	topic := finalride.FeedTopic(name)
+	node := a.config().SwarmAPI
+
+	var update *finalride.FeedUpdate
+	var err error
+	if index < 0 {
+		update, err = finalride.LatestFeedUpdate(owner, topic, node)
+	} else {
+		update, err = finalride.GetFeedUpdate(owner, topic, uint64(index), node)
+	}
+	switch {
+	case errors.Is(err, finalride.ErrNoFeedUpdate) && index < 0:
+		fail(exitFailure, "Feed %q of %s has no updates", name, owner)
+	case errors.Is(err, finalride.ErrNoFeedUpdate):
+		fail(exitFailure, "Feed %q of %s has no version %d", name, owner, index)
+	case errors.Is(err, finalride.ErrIntegrity):
+		fail(exitIntegrity, "Invalid feed update: %v", err)
+	case err != nil:
+		fail(exitNetwork, "Failed to resolve feed: %v", err)
+	}
+	return update
+}
+
+func showFeed(a *app, action string, name string, owner string, index int64) {
+	result := feedResult{OK: true, Command: currentCommand, Action: action, Feed: name, Owner: owner, Updates: []feedUpdate{}}
+	fmt.Fprintf(out, "Feed:  %s\n", name)
+	fmt.Fprintf(out, "Owner: %s\n", owner)
+	fmt.Fprintln(out, "----------------------------------------")
+
+	var updates []finalride.FeedUpdate
+	if action == "history" {
+		var err error
+		updates, err = finalride.FeedHistory(owner, finalride.FeedTopic(name), a.config().SwarmAPI)
+		if err != nil {
+			fail(transferExitCode(err), "Failed to read feed history: %v", err)
+		}
+	} else {
+		updates = []finalride.FeedUpdate{*resolveFeed(a, name, owner, index)}
+	}
+
+	for _, u := range updates {
+		result.Updates = append(result.Updates, feedUpdate{Index: u.Index, Time: u.Time.UTC(), CID: u.Reference})
+		fmt.Fprintf(out, "#%-4d %s  %s\n", u.Index, u.Time.Local().Format("2006-01-02 15:04:05"), u.Reference)
+	}
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "%d versions\n", len(updates))
+	if jsonMode {
+		printResult(result)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+func historyCommand() *command {
+	return &command{
+		name:    "history",
+		args:    "[search]",
+		summary: "Show past uploads and downloads",
+		examples: []string{
+			"history report --uploads           # Find uploads matching \"report\"",
+			"history --since 24h --limit 5      # Last five transfers of the day",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			uploads := fs.Bool("uploads", false, "Only list uploads")
+			downloads := fs.Bool("downloads", false, "Only list downloads")
+			limit := fs.Int("limit", 0, "Show at most this many entries")
+			since := fs.String("since", "", "Only entries newer than a duration (e.g. 48h) or a date (YYYY-MM-DD)")
+
+			return func(a *app, args []string) {
+				filter := finalride.HistoryFilter{Limit: *limit, Query: strings.Join(args, " ")}
+				if *uploads {
+					filter.Action = finalride.HistoryUpload
+				}
+				if *downloads {
+					filter.Action = finalride.HistoryDownload
+				}
+				if *limit < 0 {
+					usage("--limit must not be negative")
+				}
+				if *since != "" {
+					t, err := parseSince(*since)
+					if err != nil {
+						usage("invalid --since %q: use a duration like 48h or a date like 2006-01-02", *since)
+					}
+					filter.Since = t
+				}
+				runHistory(filter)
+			}
+		},
+	}
+}
+
+// parseSince accepts a duration relative to now or a local calendar date
+func parseSince(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+func runHistory(filter finalride.HistoryFilter) {
+	historyPath, err := finalride.DefaultHistoryPath()
+	if err != nil {
+		fail(exitFailure, "Failed to locate history: %v", err)
+	}
+	entries, err := finalride.LoadHistory(historyPath)
+	if err != nil {
+		fail(exitFailure, "Failed to load history: %v", err)
+	}
+	entries = finalride.FilterHistory(entries, filter)
+
+	if jsonMode {
+		if entries == nil {
+			entries = []finalride.HistoryEntry{}
+		}
+		printResult(entries)
+		return
+	}
+	fmt.Fprintf(out, "History: %s\n", historyPath)
+	printHistory(entries)
+}
+
+// recordHistory appends an entry to the local history, warning on failure
+func recordHistory(entry finalride.HistoryEntry) {
+	historyPath, err := finalride.DefaultHistoryPath()
+	if err == nil {
+		err = finalride.AppendHistory(historyPath, entry)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record history: %v\n", err)
+	}
+}
+
+func printHistory(entries []finalride.HistoryEntry) {
+	if len(entries) == 0 {
+		fmt.Fprintln(out, "No history entries found.")
+		return
+	}
+
+	for _, entry := range entries {
+		fmt.Fprintln(out, "----------------------------------------")
+		fmt.Fprintf(out, "%s  %-8s  %s (%s)\n", entry.Time.Local().Format("2006-01-02 15:04:05"), strings.ToUpper(entry.Action), entry.Filename, formatSize(entry.Size))
+		fmt.Fprintf(out, "CID:        %s\n", entry.CID)
+		fmt.Fprintf(out, "Encrypted:  %v (key: %s)\n", entry.Encrypted, entry.KeyHandling)
+		fmt.Fprintf(out, "Gateway:    %s\n", entry.Gateway)
+		if entry.Link != "" {
+			fmt.Fprintf(out, "Link:       %s\n", entry.Link)
+		}
+		if entry.Feed != "" {
+			fmt.Fprintf(out, "Feed:       %s\n", entry.Feed)
+		}
+	}
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "%d entries\n", len(entries))
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// metadataInfo is the JSON view of a metadata document printed by the info command
+type metadataInfo struct {
+	CID           string      `json:"cid"`
+	SchemaVersion int         `json:"schema_version"`
+	Filename      string      `json:"filename"`
+	Size          int64       `json:"size,omitempty"`
+	Encrypted     bool        `json:"encrypted"`
+	Encryption    string      `json:"encryption"`
+	KeyHandling   string      `json:"key_handling"`
+	Chunked       bool        `json:"chunked"`
+	Chunking      string      `json:"chunking"`
+	ChunkSize     int         `json:"chunk_size,omitempty"`
+	ChunkCount    int         `json:"chunk_count"`
+	FileID        string      `json:"file_id,omitempty"`
+	FileHash      string      `json:"file_hash,omitempty"`
+	Chunks        []chunkInfo `json:"chunks,omitempty"`
+}
+
+type chunkInfo struct {
+	Index     string `json:"index"`
+	Reference string `json:"reference"`
+	Hash      string `json:"hash"`
+	Length    int    `json:"length,omitempty"` // Bytes before encryption, for content-defined chunks
+}
+
+// verifyResult is the JSON result of the verify command
+type verifyResult struct {
+	OK      bool          `json:"ok"`
+	Command string        `json:"command"`
+	CID     string        `json:"cid"`
+	Mode    string        `json:"mode"`
+	Checked int           `json:"checked"`
+	Failed  int           `json:"failed"`
+	Pieces  []pieceResult `json:"pieces"`
+	Timings timings       `json:"timings_ms"`
+}
+
+type pieceResult struct {
+	Index     string `json:"index"`
+	Reference string `json:"reference"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+func infoCommand() *command {
+	return &command{
+		name:    "info",
+		args:    "<cid|url>",
+		summary: "Show file information without downloading",
+		examples: []string{
+			"info QmXxxx... --json              # Inspect metadata as JSON",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s info [options] <cid|url>", a.execName)
+				}
+
+				metadataCID := finalride.ParseReference(args[0])
+				metadata, err := finalride.FetchMetadata(metadataCID, finalride.NewCachedStore(a.store(), a.chunkCache()))
+				if err != nil {
+					fail(metadataExitCode(err), "Failed to fetch metadata: %v", err)
+				}
+
+				if jsonMode {
+					printResult(newMetadataInfo(metadataCID, metadata))
+					return
+				}
+				printMetadataInfo(metadataCID, metadata)
+			}
+		},
+	}
+}
+
+func newMetadataInfo(cid string, metadata *finalride.Metadata) metadataInfo {
+	info := metadataInfo{
+		CID:           cid,
+		SchemaVersion: metadata.SchemaVersion(),
+		Filename:      metadata.Filename,
+		Size:          metadata.Size,
+		Encrypted:     metadata.Encrypted,
+		Encryption:    metadata.EncryptionScheme(),
+		KeyHandling:   finalride.KeyHandlingFor(metadata),
+		Chunked:       metadata.Chunked,
+		Chunking:      metadata.ChunkingScheme(),
+		ChunkSize:     metadata.ChunkSize,
+		ChunkCount:    metadata.ChunkCount(),
+		FileID:        metadata.FileID,
+		FileHash:      metadata.FileHash,
+	}
+	for _, k := range metadata.ChunkKeys() {
+		info.Chunks = append(info.Chunks, chunkInfo{Index: k, Reference: metadata.ChunkIDs[k], Hash: metadata.ChunkHashes[k], Length: metadata.ChunkLengths[k]})
+	}
+	return info
+}
+
+func printMetadataInfo(cid string, metadata *finalride.Metadata) {
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintln(out, "FILE INFORMATION")
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "Metadata CID:   %s\n", cid)
+	fmt.Fprintf(out, "Schema version: %d\n", metadata.SchemaVersion())
+	fmt.Fprintf(out, "Filename:       %s\n", metadata.Filename)
+	if metadata.Size > 0 {
+		fmt.Fprintf(out, "Size:           %s (%d bytes)\n", formatSize(metadata.Size), metadata.Size)
+	} else {
+		fmt.Fprintln(out, "Size:           unknown")
+	}
+	fmt.Fprintf(out, "Encryption:     %s (key: %s)\n", metadata.EncryptionScheme(), finalride.KeyHandlingFor(metadata))
+	fmt.Fprintf(out, "Chunking:       %s\n", metadata.ChunkingScheme())
+	if metadata.Chunked {
+		if metadata.ChunkSize > 0 && metadata.Chunking == finalride.ChunkingContentDefined {
+			fmt.Fprintf(out, "Chunk size:     %s average\n", formatSize(int64(metadata.ChunkSize)))
+		} else if metadata.ChunkSize > 0 {
+			fmt.Fprintf(out, "Chunk size:     %s\n", formatSize(int64(metadata.ChunkSize)))
+		}
+		fmt.Fprintf(out, "Chunks:         %d\n", metadata.ChunkCount())
+		fmt.Fprintln(out, "----------------------------------------")
+		for _, k := range metadata.ChunkKeys() {
+			fmt.Fprintf(out, "Chunk %-4s %s\n", k, metadata.ChunkIDs[k])
+			fmt.Fprintf(out, "    sha256 %s\n", metadata.ChunkHashes[k])
+			if n, ok := metadata.ChunkLengths[k]; ok {
+				fmt.Fprintf(out, "    length %s\n", formatSize(int64(n)))
+			}
+		}
+	} else {
+		fmt.Fprintf(out, "File ID:        %s\n", metadata.FileID)
+		fmt.Fprintf(out, "File hash:      %s\n", metadata.FileHash)
+	}
+	fmt.Fprintln(out, "----------------------------------------")
+}
+
+func verifyCommand() *command {
+	return &command{
+		name:    "verify",
+		args:    "<cid|url>",
+		summary: "Check that every chunk is retrievable and intact",
+		examples: []string{
+			"verify QmXxxx...                   # Audit availability and integrity",
+			"verify QmXxxx... --head            # Only check that chunks exist",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			existenceOnly := fs.Bool("head", false, "Only check that chunks exist, skip hash checks")
+
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s verify [options] <cid|url>", a.execName)
+				}
+				runVerify(a, args[0], *existenceOnly)
+			}
+		},
+	}
+}
+
+func runVerify(a *app, input string, existenceOnly bool) {
+	metadataCID := finalride.ParseReference(input)
+	totalStart := time.Now()
+
+	metadata, err := finalride.FetchMetadata(metadataCID, a.store())
+	if err != nil {
+		fail(metadataExitCode(err), "Failed to fetch metadata: %v", err)
+	}
+
+	mode := "download + hash check"
+	if existenceOnly {
+		mode = "existence only (HEAD)"
+	}
+	fmt.Fprintf(out, "Metadata CID: %s\n", metadataCID)
+	fmt.Fprintf(out, "Filename:     %s\n", metadata.Filename)
+	fmt.Fprintf(out, "Pieces:       %d\n", metadata.ChunkCount())
+	fmt.Fprintf(out, "Mode:         %s\n", mode)
+	fmt.Fprintln(out, "----------------------------------------")
+
+	result := verifyResult{Command: currentCommand, CID: metadataCID, Mode: mode, Timings: timings{}}
+	exitCode := exitOK
+	progress := &jsonProgress{stage: "verify", unit: "chunks", total: int64(metadata.ChunkCount())}
+	finalride.VerifyMetadata(metadata, a.store(), existenceOnly, func(r finalride.VerifyResult) {
+		piece := pieceResult{Index: r.Index, Reference: r.Reference, Status: r.Status}
+		if jsonMode {
+			progress.Add(1)
+		}
+		if r.Status == finalride.VerifyOK {
+			fmt.Fprintf(out, "[ OK ] %-6s %s\n", r.Index, r.Reference)
+		} else {
+			piece.Error = r.Err.Error()
+			result.Failed++
+			fmt.Fprintf(out, "[FAIL] %-6s %s (%s: %v)\n", r.Index, r.Reference, r.Status, r.Err)
+			if r.Status == finalride.VerifyCorrupt {
+				exitCode = exitIntegrity
+			} else if exitCode == exitOK {
+				exitCode = exitNetwork
+			}
+		}
+		result.Pieces = append(result.Pieces, piece)
+	})
+	result.Checked = len(result.Pieces)
+	result.OK = result.Failed == 0
+	result.Timings.record("total", time.Since(totalStart))
+
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "Checked %d pieces in %s: %d ok, %d failed\n", result.Checked, formatDuration(time.Since(totalStart)), result.Checked-result.Failed, result.Failed)
+	if jsonMode {
+		printResult(result)
+	} else if result.Failed > 0 {
+		fmt.Fprintln(out, "VERIFY FAILED")
+	} else {
+		fmt.Fprintln(out, "VERIFY PASSED")
+	}
+	os.Exit(exitCode)
+}
@@ -1,59 +1,19 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
 	"final-ride/internal/finalride"
-
-	"github.com/schollz/progressbar/v3"
 )
 
-// Helper for progress bars
-func createProgressBar(max int64, description string) *progressbar.ProgressBar {
-	return progressbar.NewOptions64(max,
-		progressbar.OptionSetDescription(description),
-		progressbar.OptionSetWidth(30),
-		progressbar.OptionShowBytes(true),
-		progressbar.OptionShowCount(),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "=",
-			SaucerHead:    ">",
-			SaucerPadding: " ",
-			BarStart:      "[",
-			BarEnd:        "]",
-		}),
-		progressbar.OptionOnCompletion(func() {
-			fmt.Println()
-		}),
-	)
-}
-
-func createCountProgressBar(max int64, description string) *progressbar.ProgressBar {
-	return progressbar.NewOptions64(max,
-		progressbar.OptionSetDescription(description),
-		progressbar.OptionSetWidth(30),
-		progressbar.OptionShowCount(),
-		progressbar.OptionSetTheme(progressbar.Theme{
-			Saucer:        "=",
-			SaucerHead:    ">",
-			SaucerPadding: " ",
-			BarStart:      "[",
-			BarEnd:        "]",
-		}),
-		progressbar.OptionOnCompletion(func() {
-			fmt.Println()
-		}),
-	)
-}
-
 // Formatting helpers
 func formatSpeed(bytesPerSec float64) string {
 	if bytesPerSec >= 1024*1024*1024 {
@@ -86,388 +46,305 @@ func formatDuration(d time.Duration) string {
 	return fmt.Sprintf("%.2fm", d.Minutes())
 }
 
-func hasFlag(args []string, flag string) bool {
-	for _, arg := range args {
-		if arg == flag {
-			return true
-		}
-	}
-	return false
+// globalOptions are accepted before or after any command
+type globalOptions struct {
+	configPath string
+	profile    string
+	api        string
+	json       bool
 }
 
-func removeFlags(args []string) []string {
-	var clean []string
-	for _, arg := range args {
-		if !strings.HasPrefix(arg, "--") {
-			clean = append(clean, arg)
-		}
-	}
-	return clean
+// register adds the global flags to fs. Values already parsed, such as flags
+// given before the command name, are kept as the defaults.
+func (g *globalOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&g.configPath, "config", g.configPath, "Path to the configuration file (default: $FINAL_RIDE_CONFIG, the user config dir, then ./config.yaml)")
+	fs.StringVar(&g.profile, "profile", g.profile, "Configuration profile to apply (default: $FINAL_RIDE_PROFILE)")
+	fs.StringVar(&g.api, "api", g.api, "Swarm API endpoint (replaces swarm_api and endpoints from the config)")
+	fs.BoolVar(&g.json, "json", g.json, "Machine-readable output: JSON result on stdout, progress events on stderr")
 }
 
-func printUsage(execName string) {
-	fmt.Printf(`Usage: %s <command> [options]
-
-Commands:
-  upload <file> [options]    Upload file to Swarm
-  download <cid>             Download file from Swarm (auto-detects encryption)
-  help                       Show this help message
-
-Options:
-  --encrypt       Force upload with encryption
-  --no-encrypt    Force upload without encryption (default: respects config.yaml)
-  --help          Show this help message
-
-Examples:
-  %s upload myfile.txt                  # Upload (uses config.yaml default)
-  %s upload myfile.txt --encrypt        # Force encryption
-  %s upload myfile.txt --no-encrypt     # Force no-encryption
-  %s download QmXxxx...                 # Download (auto-detects encryption)
-
-`, execName, execName, execName, execName)
+// app carries the state shared by all commands
+type app struct {
+	execName string
+	opts     globalOptions
+	cfg      *finalride.Config
+	cfgPath  string // Config file in use, empty when running on defaults
+	pool     *finalride.Pool
+	cache    *finalride.Cache
 }
 
-func main() {
-	execName := filepath.Base(os.Args[0])
+// config resolves the configuration on first use and applies global overrides
+func (a *app) config() *finalride.Config {
+	if a.cfg != nil {
+		return a.cfg
+	}
 
-	// Load configuration
-	config, err := finalride.LoadConfig("config.yaml")
+	cfg, path, err := a.resolveConfig()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fail(exitFailure, "Failed to load configuration: %v", err)
 	}
+	a.cfg = cfg
+	a.cfgPath = path
+	return cfg
+}
 
-	// Convert chunk size from MB to bytes
-	chunkSizeBytes := config.ChunkSizeMB * 1024 * 1024
-
-	if len(os.Args) < 2 {
-		printUsage(execName)
-		return
+// store returns the endpoint pool built from the configuration
+func (a *app) store() *finalride.Pool {
+	if a.pool == nil {
+		a.pool = finalride.NewConfigPool(a.config())
 	}
+	return a.pool
+}
 
-	action := os.Args[1]
-
-	fmt.Println("========================================")
-	fmt.Println("             FINAL RIDE CLI             ")
-	fmt.Println("========================================")
-
-	switch action {
-	case "upload":
-		cleanArgs := removeFlags(os.Args)
-		if len(cleanArgs) < 3 {
-			fmt.Printf("Usage: %s upload <file> [--no-encrypt]\n", execName)
-			return
-		}
-
-		noEncrypt := hasFlag(os.Args, "--no-encrypt")
-		forceEncrypt := hasFlag(os.Args, "--encrypt")
-		
-		shouldEncrypt := config.EncryptDefault
-		if forceEncrypt { shouldEncrypt = true }
-		if noEncrypt { shouldEncrypt = false }
-
-		file := cleanArgs[2]
-		totalStart := time.Now()
-
-		fileInfo, err := os.Stat(file)
-		if os.IsNotExist(err) {
-			log.Fatalf("File does not exist: %s", file)
-		}
-
-		fileSize := fileInfo.Size()
-		fmt.Println("========================================")
-		fmt.Printf("File: %s\n", filepath.Base(file))
-		fmt.Printf("Size: %s (%d bytes)\n", formatSize(fileSize), fileSize)
-		fmt.Printf("Encryption: %v\n", shouldEncrypt)
-		fmt.Println("========================================")
-
-		fmt.Println("\n[1/4] Reading file...")
-		readStart := time.Now()
-		plaintext, err := os.ReadFile(file)
+// chunkCache opens the chunk cache on first use. It returns nil if the cache
+// is disabled, and warns and carries on without one if it cannot be opened.
+func (a *app) chunkCache() *finalride.Cache {
+	if a.cache == nil {
+		cache, err := finalride.NewConfigCache(a.config())
 		if err != nil {
-			log.Fatalf("Failed to read file: %v", err)
+			fmt.Fprintf(os.Stderr, "Warning: chunk cache unavailable: %v\n", err)
 		}
-		readDuration := time.Since(readStart)
-		readSpeed := float64(len(plaintext)) / readDuration.Seconds()
-		fmt.Printf("      Read complete: %s in %s (%s)\n", formatSize(int64(len(plaintext))), formatDuration(readDuration), formatSpeed(readSpeed))
-
-		metadata := finalride.Metadata{
-			Filename:  filepath.Base(file),
-			Encrypted: shouldEncrypt,
-		}
-
-		var dataToUpload []byte
-
-		if shouldEncrypt {
-			encryptionKey, err := finalride.GenerateKey()
-			if err != nil {
-				log.Fatalf("Failed to generate encryption key: %v", err)
-			}
+		a.cache = cache
+	}
+	return a.cache
+}
 
-			fmt.Println("\n[2/4] Encrypting file...")
-			encryptStart := time.Now()
-			dataToUpload, err = finalride.EncryptData(plaintext, encryptionKey)
-			if err != nil {
-				log.Fatalf("Encryption failed: %v", err)
-			}
-			encryptDuration := time.Since(encryptStart)
-			encryptSpeed := float64(len(plaintext)) / encryptDuration.Seconds()
-			fmt.Printf("      Encryption complete: %s in %s (%s)\n", formatSize(int64(len(dataToUpload))), formatDuration(encryptDuration), formatSpeed(encryptSpeed))
-
-			metadata.Key = base64.StdEncoding.EncodeToString(encryptionKey)
-		} else {
-			fmt.Println("\n[2/4] Skipping encryption (--no-encrypt)")
-			dataToUpload = plaintext
+// resolveConfig resolves the configuration without exiting on errors
+func (a *app) resolveConfig() (*finalride.Config, string, error) {
+	cfg, path, err := finalride.ResolveConfig(finalride.ConfigOptions{Path: a.opts.configPath, Profile: a.opts.profile})
+	if err != nil {
+		return nil, path, err
+	}
+	if a.opts.api != "" {
+		cfg.SwarmAPI = strings.TrimRight(a.opts.api, "/")
+		cfg.Endpoints = nil
+		if err := cfg.Validate(); err != nil {
+			return nil, path, fmt.Errorf("--api: %v", err)
 		}
+	}
+	return cfg, path, nil
+}
 
-		var uploadStart time.Time
-		var uploadDuration time.Duration
-		var totalUploaded int64
-
-		if len(dataToUpload) > chunkSizeBytes {
-			fmt.Printf("\n[3/4] Chunking file (size > %d MB)...\n", config.ChunkSizeMB)
-			chunkStart := time.Now()
-			chunks, chunkHashes := finalride.SplitIntoChunks(dataToUpload, chunkSizeBytes)
-			chunkDuration := time.Since(chunkStart)
-			chunkSpeed := float64(len(dataToUpload)) / chunkDuration.Seconds()
-			fmt.Printf("      Chunking complete: %d chunks in %s (%s)\n", len(chunks), formatDuration(chunkDuration), formatSpeed(chunkSpeed))
-
-			fmt.Println("\n[4/4] Uploading chunks...")
-			uploadStart = time.Now()
-
-			bar := createProgressBar(int64(len(dataToUpload)), "Uploading       ")
-			chunkIDs := make(map[string]string)
-
-			for k, chunk := range chunks {
-				ref, err := finalride.UploadToSwarm(chunk, config.SwarmAPI)
-				if err != nil {
-					log.Fatalf("\nFailed to upload chunk %s: %v", k, err)
-				}
-				chunkIDs[k] = ref
-				totalUploaded += int64(len(chunk))
-				bar.Add(len(chunk))
-			}
-
-			uploadDuration = time.Since(uploadStart)
-			metadata.Chunked = true
-			metadata.ChunkIDs = chunkIDs
-			metadata.ChunkHashes = chunkHashes
-
-		} else {
-			fmt.Println("\n[3/4] Skipping chunking (file size <= threshold)")
-			fmt.Println("\n[4/4] Uploading file...")
+// runFunc executes a command with its positional arguments
+type runFunc func(a *app, args []string)
 
-			uploadStart = time.Now()
-			bar := createProgressBar(int64(len(dataToUpload)), "Uploading       ")
+// command describes a CLI subcommand
+type command struct {
+	name     string
+	args     string   // Positional argument synopsis
+	choices  []string // Fixed values of the first argument, for completion
+	summary  string
+	examples []string
+	noBanner bool
 
-			fileID, err := finalride.UploadToSwarm(dataToUpload, config.SwarmAPI)
-			if err != nil {
-				log.Fatalf("\nFailed to upload file: %v", err)
-			}
-			bar.Add(len(dataToUpload))
-			totalUploaded = int64(len(dataToUpload))
+	// setup registers the command's flags and returns the runner bound to them
+	setup func(fs *flag.FlagSet) runFunc
+}
 
-			uploadDuration = time.Since(uploadStart)
+// commands returns every subcommand, in the order shown by help
+func commands() []*command {
+	return []*command{
+		uploadCommand(),
+		downloadCommand(),
+		infoCommand(),
+		lsCommand(),
+		verifyCommand(),
+		replicateCommand(),
+		pinCommand(),
+		unpinCommand(),
+		pinsCommand(),
+		stampsCommand(),
+		feedCommand(),
+		syncCommand(),
+		watchCommand(),
+		cacheCommand(),
+		serveCommand(),
+		daemonCommand(),
+		reuploadCommand(),
+		auditCommand(),
+		historyCommand(),
+		configCommand(),
+		completionCommand(),
+		helpCommand(),
+	}
+}
 
-			hash := sha256.Sum256(dataToUpload)
-			metadata.Chunked = false
-			metadata.FileID = fileID
-			metadata.FileHash = fmt.Sprintf("%x", hash)
+func findCommand(name string) *command {
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd
 		}
+	}
+	return nil
+}
 
-		uploadSpeed := float64(totalUploaded) / uploadDuration.Seconds()
-		fmt.Printf("      Upload complete: %s in %s (%s)\n", formatSize(totalUploaded), formatDuration(uploadDuration), formatSpeed(uploadSpeed))
-
-		fmt.Println("\n      Uploading metadata...")
-		metadataJSON, err := json.MarshalIndent(metadata, "", "  ")
-		if err != nil {
-			log.Fatalf("Failed to create metadata JSON: %v", err)
-		}
+// newFlagSet creates the flag set for a command, including the global flags
+func newFlagSet(a *app, cmd *command) (*flag.FlagSet, runFunc) {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	a.opts.register(fs)
+	run := cmd.setup(fs)
+	return fs, run
+}
 
-		metadataCID, err := finalride.UploadToSwarm(metadataJSON, config.SwarmAPI)
-		if err != nil {
-			log.Fatalf("Failed to upload metadata: %v", err)
+// parseArgs parses flags anywhere among the arguments. Everything after a
+// literal "--" is treated as positional, so files starting with "-" work.
+func parseArgs(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	for i, arg := range args {
+		if arg == "--" {
+			rest = args[i+1:]
+			args = args[:i]
+			break
 		}
+	}
 
-		totalDuration := time.Since(totalStart)
-		avgSpeed := float64(fileSize) / totalDuration.Seconds()
-
-		fmt.Println("\n========================================")
-		fmt.Println("UPLOAD SUCCESSFUL!")
-		fmt.Println("========================================")
-		fmt.Printf("Metadata CID: %s\n", metadataCID)
-		fmt.Printf("Encrypted: %v\n", metadata.Encrypted)
-		fmt.Printf("Chunked: %v\n", metadata.Chunked)
-		if metadata.Chunked {
-			fmt.Printf("Chunks: %d\n", len(metadata.ChunkIDs))
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
 		}
-		fmt.Println("----------------------------------------")
-		fmt.Printf("Total time: %s\n", formatDuration(totalDuration))
-		fmt.Printf("Average speed: %s\n", formatSpeed(avgSpeed))
-		fmt.Println("----------------------------------------")
-		fmt.Printf("Shareable Download Link:\n%s\n", fmt.Sprintf(config.DownloadLink, metadataCID))
-
-	case "download":
-		if len(os.Args) < 3 {
-			fmt.Printf("Usage: %s download <metadata_cid>\n", execName)
-			return
+		args = fs.Args()
+		if len(args) == 0 {
+			break
 		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	return append(positional, rest...), nil
+}
 
-		metadataCID := os.Args[2]
+func printUsage(execName string) {
+	fmt.Printf("Usage: %s [global options] <command> [options] [arguments]\n\nCommands:\n", execName)
+	for _, cmd := range commands() {
+		fmt.Printf("  %-32s %s\n", strings.TrimSpace(cmd.name+" "+cmd.args), cmd.summary)
+	}
 
-		// URL Extraction Logic
-		if strings.Contains(metadataCID, "download=") {
-			parts := strings.Split(metadataCID, "download=")
-			if len(parts) > 1 {
-				extracted := strings.Split(parts[1], "&")[0]
-				fmt.Printf("Extracted CID from URL: %s\n", extracted)
-				metadataCID = extracted
-			}
-		}
+	fmt.Println("\nGlobal options:")
+	fs := flag.NewFlagSet("global", flag.ContinueOnError)
+	var opts globalOptions
+	opts.register(fs)
+	printFlags(fs)
 
-		totalStart := time.Now()
+	fmt.Printf(`
+Exit codes:
+  0 success, 1 other failure, 2 usage, 3 network, 4 integrity, 5 crypto
 
-		fmt.Println("========================================")
-		fmt.Println("DOWNLOAD STARTED")
-		fmt.Println("========================================")
-		fmt.Printf("Metadata CID: %s\n", metadataCID)
+Run '%s help <command>' for the options of a command.
+`, execName)
+}
 
-		fmt.Println("\n[1/4] Downloading metadata...")
-		metadataStart := time.Now()
-		metadataJSON, err := finalride.DownloadFromSwarm(metadataCID, config.SwarmAPI)
-		if err != nil {
-			log.Fatalf("Failed to download metadata: %v", err)
-		}
-		metadataDuration := time.Since(metadataStart)
-		fmt.Printf("      Metadata downloaded in %s\n", formatDuration(metadataDuration))
+func printCommandUsage(a *app, cmd *command) {
+	fs, _ := newFlagSet(a, cmd)
+	fmt.Printf("Usage: %s %s [options] %s\n\n%s\n", a.execName, cmd.name, cmd.args, cmd.summary)
 
-		var metadata finalride.Metadata
-		if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
-			log.Fatalf("Failed to parse metadata: %v", err)
-		}
+	fmt.Println("\nOptions:")
+	printFlags(fs)
 
-		fmt.Println("\n----------------------------------------")
-		fmt.Println("FILE INFORMATION")
-		fmt.Println("----------------------------------------")
-		fmt.Printf("Filename:    %s\n", metadata.Filename)
-		fmt.Printf("Encrypted:   %v\n", metadata.Encrypted)
-		fmt.Printf("Chunked:     %v\n", metadata.Chunked)
-		if metadata.Chunked {
-			fmt.Printf("Chunks:      %d\n", len(metadata.ChunkIDs))
+	if len(cmd.examples) > 0 {
+		fmt.Println("\nExamples:")
+		for _, example := range cmd.examples {
+			fmt.Printf("  %s %s\n", a.execName, example)
 		}
-		fmt.Println("----------------------------------------")
-
-		var downloadedData []byte
-		var downloadDuration time.Duration
-		var totalDownloaded int64
+	}
+}
 
-		if metadata.Chunked {
-			fmt.Printf("\n[2/4] Downloading %d chunks...\n", len(metadata.ChunkIDs))
-			downloadStart := time.Now()
+// printFlags lists flags as --name <value> with their usage and default
+func printFlags(fs *flag.FlagSet) {
+	var lines []string
+	fs.VisitAll(func(f *flag.Flag) {
+		name := "--" + f.Name
+		if len(f.Name) == 1 {
+			name = "-" + f.Name
+		}
+		if !isBoolFlag(f) {
+			name += " <value>"
+		}
+		line := fmt.Sprintf("  %-22s %s", name, f.Usage)
+		if f.DefValue != "" && !isBoolFlag(f) {
+			line += fmt.Sprintf(" (default %q)", f.DefValue)
+		}
+		lines = append(lines, line)
+	})
+	sort.Strings(lines)
+	fmt.Println(strings.Join(lines, "\n"))
+}
 
-			downloadedChunks := make(map[string][]byte)
-			bar := createCountProgressBar(int64(len(metadata.ChunkIDs)), "Downloading     ")
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
 
-			for k, reference := range metadata.ChunkIDs {
-				chunkData, err := finalride.DownloadFromSwarm(reference, config.SwarmAPI)
-				if err != nil {
-					log.Fatalf("\nFailed to download chunk %s: %v", k, err)
+func helpCommand() *command {
+	return &command{
+		name:     "help",
+		args:     "[command]",
+		summary:  "Show help for the CLI or a command",
+		noBanner: true,
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(a *app, args []string) {
+				if len(args) == 0 {
+					printUsage(a.execName)
+					return
 				}
-
-				hash := sha256.Sum256(chunkData)
-				expectedHash := metadata.ChunkHashes[k]
-				if expectedHash != fmt.Sprintf("%x", hash) {
-					log.Fatalf("\nChunk %s integrity check failed", k)
+				cmd := findCommand(args[0])
+				if cmd == nil {
+					usage("unknown command '%s'. Use '%s help' for usage.", args[0], a.execName)
 				}
-
-				downloadedChunks[k] = chunkData
-				totalDownloaded += int64(len(chunkData))
-				bar.Add(1)
-			}
-
-			downloadDuration = time.Since(downloadStart)
-			downloadSpeed := float64(totalDownloaded) / downloadDuration.Seconds()
-			fmt.Printf("      Download complete: %s in %s (%s)\n", formatSize(totalDownloaded), formatDuration(downloadDuration), formatSpeed(downloadSpeed))
-
-			fmt.Println("\n[3/4] Reassembling chunks...")
-			reassembleStart := time.Now()
-			downloadedData = finalride.ReassembleChunks(downloadedChunks)
-			reassembleDuration := time.Since(reassembleStart)
-			reassembleSpeed := float64(len(downloadedData)) / reassembleDuration.Seconds()
-			fmt.Printf("      Reassemble complete: %s in %s (%s)\n", formatSize(int64(len(downloadedData))), formatDuration(reassembleDuration), formatSpeed(reassembleSpeed))
-
-		} else {
-			fmt.Println("\n[2/4] Downloading file...")
-			downloadStart := time.Now()
-
-			downloadedData, err = finalride.DownloadFromSwarm(metadata.FileID, config.SwarmAPI)
-			if err != nil {
-				log.Fatalf("\nFailed to download file: %v", err)
+				printCommandUsage(a, cmd)
 			}
-			totalDownloaded = int64(len(downloadedData))
-
-			downloadDuration = time.Since(downloadStart)
-			downloadSpeed := float64(totalDownloaded) / downloadDuration.Seconds()
-			fmt.Printf("      Download complete: %s in %s (%s)\n", formatSize(totalDownloaded), formatDuration(downloadDuration), formatSpeed(downloadSpeed))
-
-			hash := sha256.Sum256(downloadedData)
-			if metadata.FileHash != fmt.Sprintf("%x", hash) {
-				log.Fatalf("File integrity check failed")
-			}
-			fmt.Println("      Integrity check: PASSED")
+		},
+	}
+}
 
-			fmt.Println("\n[3/4] Skipping reassembly (single file)")
+func main() {
+	a := &app{execName: filepath.Base(os.Args[0])}
+
+	// Global options may precede the command name
+	global := flag.NewFlagSet(a.execName, flag.ContinueOnError)
+	global.SetOutput(io.Discard)
+	a.opts.register(global)
+	if err := global.Parse(os.Args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printUsage(a.execName)
+			return
 		}
+		usage("%v. Use '%s help' for usage.", err, a.execName)
+	}
+	if a.opts.json {
+		enableJSON()
+	}
 
-		var finalData []byte
+	if global.NArg() == 0 {
+		printUsage(a.execName)
+		return
+	}
 
-		if metadata.Encrypted {
-			encryptionKey, err := base64.StdEncoding.DecodeString(metadata.Key)
-			if err != nil {
-				log.Fatalf("Failed to decode encryption key: %v", err)
-			}
+	name := global.Arg(0)
+	currentCommand = name
+	cmd := findCommand(name)
+	if cmd == nil {
+		usage("invalid command '%s'. Use '%s help' for usage.", name, a.execName)
+	}
 
-			fmt.Println("\n[4/4] Decrypting file...")
-			decryptStart := time.Now()
-			finalData, err = finalride.DecryptData(downloadedData, encryptionKey)
-			if err != nil {
-				log.Fatalf("Decryption failed: %v", err)
-			}
-			decryptDuration := time.Since(decryptStart)
-			decryptSpeed := float64(len(downloadedData)) / decryptDuration.Seconds()
-			fmt.Printf("      Decryption complete: %s in %s (%s)\n", formatSize(int64(len(finalData))), formatDuration(decryptDuration), formatSpeed(decryptSpeed))
-		} else {
-			fmt.Println("\n[4/4] Skipping decryption (not encrypted)")
-			finalData = downloadedData
+	fs, run := newFlagSet(a, cmd)
+	args, err := parseArgs(fs, global.Args()[1:])
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printCommandUsage(a, cmd)
+			return
 		}
+		usage("%s %s: %v", a.execName, cmd.name, err)
+	}
+	if a.opts.json {
+		enableJSON()
+	}
 
-		fmt.Println("\n      Saving file...")
-		writeStart := time.Now()
-		outputFile := metadata.Filename
-		if err := os.WriteFile(outputFile, finalData, 0644); err != nil {
-			log.Fatalf("Failed to save file: %v", err)
-		}
-		writeDuration := time.Since(writeStart)
-		writeSpeed := float64(len(finalData)) / writeDuration.Seconds()
-		fmt.Printf("      Save complete: %s in %s (%s)\n", formatSize(int64(len(finalData))), formatDuration(writeDuration), formatSpeed(writeSpeed))
-
-		totalDuration := time.Since(totalStart)
-		avgSpeed := float64(len(finalData)) / totalDuration.Seconds()
-
-		fmt.Println("\n========================================")
-		fmt.Println("DOWNLOAD SUCCESSFUL!")
-		fmt.Println("========================================")
-		fmt.Printf("File saved: %s\n", outputFile)
-		fmt.Printf("Size: %s\n", formatSize(int64(len(finalData))))
-		fmt.Printf("Encrypted: %v\n", metadata.Encrypted)
-		fmt.Println("----------------------------------------")
-		fmt.Printf("Total time: %s\n", formatDuration(totalDuration))
-		fmt.Printf("Average speed: %s\n", formatSpeed(avgSpeed))
-		fmt.Println("========================================")
-
-	case "help":
-		printUsage(execName)
-
-	default:
-		fmt.Printf("Invalid action '%s'. Use '%s help' for usage.\n", action, execName)
+	if !cmd.noBanner {
+		fmt.Fprintln(out, "========================================")
+		fmt.Fprintln(out, "             FINAL RIDE CLI             ")
+		fmt.Fprintln(out, "========================================")
 	}
+
+	run(a, args)
 }
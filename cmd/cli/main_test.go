@@ -0,0 +1,37 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"testing"
+)
+
+func TestGlobalOptions(t *testing.T) {
+	for _, argv := range [][]string{
+		{"--api", "http://127.0.0.1:1", "--profile", "node", "--json", "config", "show"},
+		{"config", "show", "--api", "http://127.0.0.1:1", "--profile", "node", "--json"},
+		{"--profile", "node", "config", "--api", "http://127.0.0.1:1", "show", "--json"},
+	} {
+		// Parsed as main does: the global flags, then the command's own
+		a := &app{execName: "final-ride-cli"}
+		global := flag.NewFlagSet(a.execName, flag.ContinueOnError)
+		global.SetOutput(io.Discard)
+		a.opts.register(global)
+		if err := global.Parse(argv); err != nil {
+			t.Fatalf("%v: %v", argv, err)
+		}
+		fs, _ := newFlagSet(a, findCommand(global.Arg(0)))
+		args, err := parseArgs(fs, global.Args()[1:])
+		if err != nil {
+			t.Fatalf("%v: %v", argv, err)
+		}
+
+		want := globalOptions{profile: "node", api: "http://127.0.0.1:1", json: true}
+		if a.opts != want {
+			t.Errorf("%v: got options %+v, want %+v", argv, a.opts, want)
+		}
+		if len(args) != 1 || args[0] != "show" {
+			t.Errorf("%v: got arguments %v", argv, args)
+		}
+	}
+}
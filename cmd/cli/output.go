@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"final-ride/internal/finalride"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// Exit codes
+const (
+	exitOK        = 0
+	exitFailure   = 1 // Any other failure (local I/O, config, ...)
+	exitUsage     = 2 // Invalid command line
+	exitNetwork   = 3 // Swarm API unreachable or returned an error
+	exitIntegrity = 4 // Hash mismatch or invalid metadata
+	exitCrypto    = 5 // Key handling, encryption or decryption failed
+)
+
+var (
+	// jsonMode switches the CLI to machine-readable output
+	jsonMode bool
+
+	// out receives human readable output; it is discarded in JSON mode
+	out io.Writer = os.Stdout
+
+	// resultOut receives the final JSON result
+	resultOut io.Writer = os.Stdout
+)
+
+// enableJSON suppresses decoration so only JSON reaches stdout and stderr
+func enableJSON() {
+	jsonMode = true
+	out = io.Discard
+}
+
+// reserveStdout moves all decoration and results to stderr so that stdout
+// carries nothing but file data
+func reserveStdout() {
+	if !jsonMode {
+		out = os.Stderr
+	}
+	resultOut = os.Stderr
+}
+
+// progressEvent is streamed to stderr as a JSON line in JSON mode
+type progressEvent struct {
+	Event   string `json:"event"` // "stage" or "progress"
+	Command string `json:"command"`
+	Stage   string `json:"stage"`
+	Message string `json:"message,omitempty"`
+	Done    int64  `json:"done,omitempty"`
+	Total   int64  `json:"total,omitempty"`
+	Unit    string `json:"unit,omitempty"` // "bytes" or "chunks"
+}
+
+// errorResult is printed on stdout in JSON mode when a command fails
+type errorResult struct {
+	OK       bool   `json:"ok"`
+	Command  string `json:"command"`
+	Error    string `json:"error"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// currentCommand names the running command in JSON events and errors
+var currentCommand string
+
+func emitEvent(event progressEvent) {
+	event.Command = currentCommand
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	os.Stderr.Write(append(line, '\n'))
+}
+
+// stage announces a pipeline step, as text or as a JSON event
+func stage(name string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if jsonMode {
+		emitEvent(progressEvent{Event: "stage", Stage: name, Message: message})
+		return
+	}
+	fmt.Fprintf(out, "\n%s\n", message)
+}
+
+// printResult writes the final structured result to stdout
+func printResult(v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fail(exitFailure, "Failed to create JSON: %v", err)
+	}
+	fmt.Fprintln(resultOut, string(data))
+}
+
+// fail reports an error and exits with the given code
+func fail(code int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if jsonMode {
+		printResult(errorResult{Command: currentCommand, Error: message, ExitCode: code})
+	} else {
+		log.Print(message)
+	}
+	os.Exit(code)
+}
+
+// usage reports a command line error
+func usage(format string, args ...interface{}) {
+	fail(exitUsage, format, args...)
+}
+
+// metadataExitCode classifies a metadata fetch error
+func metadataExitCode(err error) int {
+	if errors.Is(err, finalride.ErrInvalidMetadata) {
+		return exitIntegrity
+	}
+	return exitNetwork
+}
+
+// transferExitCode classifies an upload or download error
+func transferExitCode(err error) int {
+	switch {
+	case errors.Is(err, finalride.ErrIntegrity), errors.Is(err, finalride.ErrInvalidMetadata):
+		return exitIntegrity
+	case errors.Is(err, finalride.ErrEncryption), errors.Is(err, finalride.ErrDecryption):
+		return exitCrypto
+	}
+	return exitNetwork
+}
+
+// trackedReader remembers read errors so a failing local input is not
+// reported as a network error
+type trackedReader struct {
+	r   io.Reader
+	err error
+}
+
+func (t *trackedReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+	}
+	return n, err
+}
+
+// trackedWriter remembers write errors for the same reason
+type trackedWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (t *trackedWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err != nil {
+		t.err = err
+	}
+	return n, err
+}
+
+// progressReporter is satisfied by progress bars and JSON progress streams
+type progressReporter interface {
+	Add(int) error
+}
+
+// jsonProgress streams progress updates as JSON events
+type jsonProgress struct {
+	stage string
+	unit  string
+	done  int64
+	total int64
+}
+
+func (p *jsonProgress) Add(n int) error {
+	p.done += int64(n)
+	emitEvent(progressEvent{Event: "progress", Stage: p.stage, Done: p.done, Total: p.total, Unit: p.unit})
+	return nil
+}
+
+func newByteProgress(max int64, stageName, description string) progressReporter {
+	if jsonMode {
+		return &jsonProgress{stage: stageName, unit: "bytes", total: max}
+	}
+	return createProgressBar(max, description)
+}
+
+func newCountProgress(max int64, stageName, description string) progressReporter {
+	if jsonMode {
+		return &jsonProgress{stage: stageName, unit: "chunks", total: max}
+	}
+	return createCountProgressBar(max, description)
+}
+
+// Helper for progress bars
+func createProgressBar(max int64, description string) *progressbar.ProgressBar {
+	return progressbar.NewOptions64(max,
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionSetWriter(out),
+		progressbar.OptionSetWidth(30),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionShowCount(),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "=",
+			SaucerHead:    ">",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+		progressbar.OptionOnCompletion(func() {
+			fmt.Fprintln(out)
+		}),
+	)
+}
+
+func createCountProgressBar(max int64, description string) *progressbar.ProgressBar {
+	return progressbar.NewOptions64(max,
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionSetWriter(out),
+		progressbar.OptionSetWidth(30),
+		progressbar.OptionShowCount(),
+		progressbar.OptionSetTheme(progressbar.Theme{
+			Saucer:        "=",
+			SaucerHead:    ">",
+			SaucerPadding: " ",
+			BarStart:      "[",
+			BarEnd:        "]",
+		}),
+		progressbar.OptionOnCompletion(func() {
+			fmt.Fprintln(out)
+		}),
+	)
+}
+
+// timings collects step durations in milliseconds for JSON results
+type timings map[string]int64
+
+func (t timings) record(name string, d time.Duration) {
+	t[name] = d.Milliseconds()
+}
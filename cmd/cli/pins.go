@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"final-ride/internal/finalride"
+)
+
+// pinsResult is the JSON result of the pin, unpin and pins commands
+type pinsResult struct {
+	OK         bool      `json:"ok"`
+	Command    string    `json:"command"`
+	Node       string    `json:"node"`
+	CID        string    `json:"cid,omitempty"`
+	Failed     int       `json:"failed"`
+	Pieces     []pinInfo `json:"pieces,omitempty"`
+	References []string  `json:"references,omitempty"` // All pins on the node (pins list without a CID)
+}
+
+type pinInfo struct {
+	Index     string `json:"index"`
+	Reference string `json:"reference"`
+	Pinned    bool   `json:"pinned"`
+	Error     string `json:"error,omitempty"`
+}
+
+func pinCommand() *command {
+	return &command{
+		name:    "pin",
+		args:    "<cid|url>",
+		summary: "Pin every chunk and the metadata of a share on swarm_api",
+		examples: []string{
+			"pin <CID>                          # Keep a share on the configured node",
+			"pin <CID> --api http://bee:1633    # Pin on another node",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s pin <cid|url>", a.execName)
+				}
+				runPins(a, "pin", args[0])
+			}
+		},
+	}
+}
+
+func unpinCommand() *command {
+	return &command{
+		name:    "unpin",
+		args:    "<cid|url>",
+		summary: "Remove the pins of a share from swarm_api",
+		examples: []string{
+			"unpin <CID>                        # Let the node garbage-collect a share",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s unpin <cid|url>", a.execName)
+				}
+				runPins(a, "unpin", args[0])
+			}
+		},
+	}
+}
+
+func pinsCommand() *command {
+	return &command{
+		name:    "pins",
+		args:    "list [cid|url]",
+		choices: []string{"list"},
+		summary: "List pinned references on swarm_api",
+		examples: []string{
+			"pins list                          # Every reference pinned on the node",
+			"pins list <CID>                    # Which pieces of a share are pinned",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			return func(a *app, args []string) {
+				if len(args) == 0 || args[0] != "list" || len(args) > 2 {
+					usage("Usage: %s pins list [cid|url]", a.execName)
+				}
+				if len(args) == 2 {
+					runPins(a, "status", args[1])
+					return
+				}
+				listPins(a)
+			}
+		},
+	}
+}
+
+// runPins pins, unpins or reports the pin status of every reference of a share
+func runPins(a *app, action string, input string) {
+	node := a.config().SwarmAPI
+	metadataCID := finalride.ParseReference(input)
+
+	metadata, err := finalride.FetchMetadata(metadataCID, a.store())
+	if err != nil {
+		fail(metadataExitCode(err), "Failed to fetch metadata: %v", err)
+	}
+
+	walk := finalride.PinShare
+	switch action {
+	case "unpin":
+		walk = finalride.UnpinShare
+	case "status":
+		walk = finalride.SharePinStatus
+	}
+
+	fmt.Fprintf(out, "Node:         %s\n", node)
+	fmt.Fprintf(out, "Metadata CID: %s\n", metadataCID)
+	fmt.Fprintf(out, "Filename:     %s\n", metadata.Filename)
+	fmt.Fprintln(out, "----------------------------------------")
+
+	result := pinsResult{Command: currentCommand, Node: node, CID: metadataCID}
+	walk(metadataCID, metadata, node, func(r finalride.PinResult) {
+		piece := pinInfo{Index: r.Index, Reference: r.Reference, Pinned: r.Pinned}
+		switch {
+		case r.Err != nil:
+			piece.Error = r.Err.Error()
+			result.Failed++
+			fmt.Fprintf(out, "[FAIL]     %-8s %s (%v)\n", r.Index, r.Reference, r.Err)
+		case r.Pinned:
+			fmt.Fprintf(out, "[PINNED]   %-8s %s\n", r.Index, r.Reference)
+		default:
+			fmt.Fprintf(out, "[UNPINNED] %-8s %s\n", r.Index, r.Reference)
+		}
+		result.Pieces = append(result.Pieces, piece)
+	})
+	result.OK = result.Failed == 0
+
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "%d references, %d failed\n", len(result.Pieces), result.Failed)
+	if jsonMode {
+		printResult(result)
+	}
+	if !result.OK {
+		os.Exit(exitNetwork)
+	}
+}
+
+func listPins(a *app) {
+	node := a.config().SwarmAPI
+	references, err := finalride.ListPins(node)
+	if err != nil {
+		fail(exitNetwork, "Failed to list pins on %s: %v", node, err)
+	}
+
+	if jsonMode {
+		printResult(pinsResult{OK: true, Command: currentCommand, Node: node, References: references})
+		return
+	}
+	for _, ref := range references {
+		fmt.Println(ref)
+	}
+	fmt.Fprintf(os.Stderr, "%d references pinned on %s\n", len(references), node)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// replicateResult is the JSON result of the replicate command
+type replicateResult struct {
+	OK      bool            `json:"ok"`
+	Command string          `json:"command"`
+	CID     string          `json:"cid"`
+	Targets []replicaTarget `json:"targets"`
+	Timings timings         `json:"timings_ms"`
+}
+
+type replicaTarget struct {
+	Endpoint string        `json:"endpoint"`
+	OK       bool          `json:"ok"`
+	Error    string        `json:"error,omitempty"`
+	Pieces   []pieceResult `json:"pieces"`
+}
+
+// placementInfo reports where a piece was stored by a replicated upload
+type placementInfo struct {
+	Piece     string   `json:"piece"`
+	Reference string   `json:"reference"`
+	Endpoints []string `json:"endpoints"`
+}
+
+func replicateCommand() *command {
+	return &command{
+		name:    "replicate",
+		args:    "<cid|url>",
+		summary: "Copy an existing share to other Bee nodes",
+		examples: []string{
+			"replicate <CID> --to http://localhost:1633          # Copy to a local node",
+			"replicate <CID> --to http://a:1633 --to http://b:1633 # Copy to several nodes",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			var targets []string
+			fs.Func("to", "Bee API endpoint to copy to (repeatable)", func(value string) error {
+				u, err := url.Parse(value)
+				if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+					return fmt.Errorf("must be an http:// or https:// URL")
+				}
+				targets = append(targets, strings.TrimRight(value, "/"))
+				return nil
+			})
+
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s replicate <cid|url> --to <endpoint>", a.execName)
+				}
+				if len(targets) == 0 {
+					usage("--to is required")
+				}
+				runReplicate(a, finalride.ParseReference(args[0]), targets)
+			}
+		},
+	}
+}
+
+func runReplicate(a *app, metadataCID string, targets []string) {
+	totalStart := time.Now()
+	result := replicateResult{OK: true, Command: currentCommand, CID: metadataCID, Timings: timings{}}
+	exitCode := exitOK
+
+	fmt.Fprintf(out, "Metadata CID: %s\n", metadataCID)
+	for _, endpoint := range targets {
+		stage("replicate", "Replicating to %s...", endpoint)
+		target := replicaTarget{Endpoint: endpoint, OK: true}
+		progress := &jsonProgress{stage: "replicate", unit: "chunks"}
+		code := exitOK
+
+		_, err := finalride.Replicate(metadataCID, a.store(), finalride.Gateway(endpoint), func(r finalride.ReplicaResult) {
+			piece := pieceResult{Index: r.Index, Reference: r.Reference, Status: "copied"}
+			if jsonMode {
+				progress.Add(1)
+			}
+			if r.Err != nil {
+				piece.Status = "failed"
+				piece.Error = r.Err.Error()
+				if code == exitOK {
+					code = transferExitCode(r.Err)
+				}
+				fmt.Fprintf(out, "[FAIL] %-8s %s (%v)\n", r.Index, r.Reference, r.Err)
+			} else {
+				fmt.Fprintf(out, "[ OK ] %-8s %s\n", r.Index, r.Reference)
+			}
+			target.Pieces = append(target.Pieces, piece)
+		})
+		if err != nil {
+			target.OK = false
+			target.Error = err.Error()
+			result.OK = false
+			if target.Pieces == nil {
+				// The metadata itself could not be read from the source
+				code = metadataExitCode(err)
+			}
+			if exitCode == exitOK || code == exitIntegrity {
+				exitCode = code
+			}
+			fmt.Fprintf(out, "Replication to %s failed: %v\n", endpoint, err)
+		} else {
+			fmt.Fprintf(out, "Replicated %d pieces and the metadata to %s\n", len(target.Pieces)-1, endpoint)
+		}
+		result.Targets = append(result.Targets, target)
+	}
+	result.Timings.record("total", time.Since(totalStart))
+
+	if jsonMode {
+		printResult(result)
+	}
+	os.Exit(exitCode)
+}
+
+// printPlacement reports which endpoints hold each piece of a replicated upload
+func printPlacement(store *finalride.ReplicatedStore, metadata *finalride.Metadata, metadataCID string) []placementInfo {
+	var placement []placementInfo
+	for _, p := range metadata.Pieces() {
+		placement = append(placement, placementInfo{Piece: p.Name(), Reference: p.Reference, Endpoints: store.Placement(p.Reference)})
+	}
+	placement = append(placement, placementInfo{Piece: "metadata", Reference: metadataCID, Endpoints: store.Placement(metadataCID)})
+
+	fmt.Fprintln(out, "Replicas:")
+	for _, p := range placement {
+		fmt.Fprintf(out, "  %-10s %s\n", p.Piece, strings.Join(p.Endpoints, ", "))
+	}
+	return placement
+}
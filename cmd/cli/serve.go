@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"final-ride/cmd/web"
+	"final-ride/internal/finalride"
+)
+
+// serveResult is the JSON result of the serve and daemon commands, printed on shutdown
+type serveResult struct {
+	OK      bool   `json:"ok"`
+	Command string `json:"command"`
+	Addr    string `json:"addr"`
+	URL     string `json:"url"`
+}
+
+func serveCommand() *command {
+	return &command{
+		name:    "serve",
+		summary: "Host the web UI with its configuration",
+		examples: []string{
+			"serve                              # http://localhost:8080/",
+			"serve --addr 127.0.0.1:9000        # Only reachable from this machine",
+			"serve --max-upload-mb 50 --rate-limit 30",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			addr := fs.String("addr", ":8080", "Address to listen on")
+			maxUpload := fs.Int64("max-upload-mb", 100, "Largest upload the /api/bzz proxy accepts, in MB (0 for no limit)")
+			rateLimit := fs.Int("rate-limit", 120, "Proxy requests per minute allowed from one client (0 for no limit)")
+
+			return func(a *app, args []string) {
+				expectArgs(a, args, 0, "serve [--addr host:port]")
+				if *maxUpload < 0 || *rateLimit < 0 {
+					usage("--max-upload-mb and --rate-limit must not be negative")
+				}
+				runServe(a, *addr, finalride.ServerOptions{MaxUpload: *maxUpload * 1024 * 1024, RateLimit: *rateLimit})
+			}
+		},
+	}
+}
+
+func runServe(a *app, addr string, opts finalride.ServerOptions) {
+	config := a.config()
+	handler := finalride.NewServer(config, web.Files, opts)
+	listenAndServe(addr, handler, func(url string) {
+		stage("serve", "Serving the web UI on %s (Ctrl+C to stop)", url)
+		fmt.Fprintf(out, "Proxying:    /api/bzz -> %s\n", strings.Join(config.APIEndpoints(), ", "))
+		fmt.Fprintf(out, "Share links: %s\n", config.DownloadLink)
+		fmt.Fprintf(out, "Streaming:   %sf/<cid>\n", url)
+	})
+}
+
+// listenAndServe serves handler on addr until interrupted, calling started with
+// the local URL once it is listening, and prints the JSON result on shutdown
+func listenAndServe(addr string, handler http.Handler, started func(url string)) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		fail(exitFailure, "Failed to listen on %s: %v", addr, err)
+	}
+
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	url := localURL(listener.Addr())
+	started(url)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fail(exitFailure, "Server failed: %v", err)
+	}
+	if jsonMode {
+		printResult(serveResult{OK: true, Command: currentCommand, Addr: listener.Addr().String(), URL: url})
+	}
+}
+
+// localURL is the address a browser on this machine can open
+func localURL(addr net.Addr) string {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return "http://" + addr.String() + "/"
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(host, port) + "/"
+}
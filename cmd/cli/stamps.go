@@ -0,0 +1,284 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// minBatchDepth is the smallest depth Bee accepts for a new batch
+const minBatchDepth = 17
+
+// stampsResult is the JSON result of the stamps command
+type stampsResult struct {
+	OK      bool        `json:"ok"`
+	Command string      `json:"command"`
+	Action  string      `json:"action"`
+	Node    string      `json:"node"`
+	Batches []batchInfo `json:"batches,omitempty"`  // stamps list
+	BatchID string      `json:"batch_id,omitempty"` // buy, topup, dilute
+	Amount  string      `json:"amount,omitempty"`   // PLUR per chunk paid by buy or topup
+	Depth   uint        `json:"depth,omitempty"`
+	Cost    string      `json:"cost,omitempty"` // Total BZZ spent by buy or topup
+}
+
+type batchInfo struct {
+	BatchID     string `json:"batch_id"`
+	Label       string `json:"label,omitempty"`
+	Usable      bool   `json:"usable"`
+	Immutable   bool   `json:"immutable"`
+	Depth       uint   `json:"depth"`
+	Utilization int64  `json:"utilization"`
+	Capacity    int64  `json:"capacity"`  // Chunks in total
+	Remaining   int64  `json:"remaining"` // Estimated free chunk slots
+	Amount      string `json:"amount"`
+	TTLSeconds  int64  `json:"ttl_seconds"`
+}
+
+// stampInfo reports the batch an upload is stamped with on one endpoint
+type stampInfo struct {
+	Endpoint  string `json:"endpoint"`
+	BatchID   string `json:"batch_id"`
+	Remaining int64  `json:"remaining,omitempty"`
+	Cost      string `json:"cost,omitempty"` // BZZ value of the slots the upload uses
+}
+
+// estimateInfo is the expected footprint of an upload
+type estimateInfo struct {
+	Pieces int   `json:"pieces"`
+	Bytes  int64 `json:"bytes"`
+	Slots  int64 `json:"slots"`
+}
+
+// estimateResult is the JSON result of upload --estimate
+type estimateResult struct {
+	OK        bool          `json:"ok"`
+	Command   string        `json:"command"`
+	Filename  string        `json:"filename"`
+	Size      int64         `json:"size"`
+	Encrypted bool          `json:"encrypted"`
+	Estimate  *estimateInfo `json:"estimate"`
+	Stamps    []stampInfo   `json:"stamps"`
+}
+
+func stampsCommand() *command {
+	return &command{
+		name:    "stamps",
+		args:    "<list|buy|topup|dilute> [batch]",
+		choices: []string{"list", "buy", "topup", "dilute"},
+		summary: "Manage postage batches on swarm_api",
+		examples: []string{
+			"stamps list                        # Batches, capacity and time to live",
+			"stamps buy --depth 20 --ttl 720h   # ~4 GB of slots for 30 days",
+			"stamps topup <batch> --ttl 720h    # Extend a batch by 30 days",
+			"stamps dilute <batch> --depth 21   # Double the capacity, halve the time left",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			depth := fs.Uint("depth", 0, "Batch depth; capacity is 2^depth chunks of 4 KB (buy, dilute)")
+			amount := fs.String("amount", "", "PLUR per chunk to pay (buy, topup)")
+			ttl := fs.Duration("ttl", 0, "Pay for this lifetime at the current price instead of --amount (buy, topup)")
+			label := fs.String("label", "", "Label of the new batch (buy)")
+			immutable := fs.Bool("immutable", false, "Refuse uploads once the batch is full instead of overwriting (buy)")
+
+			return func(a *app, args []string) {
+				if len(args) == 0 {
+					usage("Usage: %s stamps <list|buy|topup|dilute> [batch]", a.execName)
+				}
+				node := a.config().SwarmAPI
+
+				switch action, args := args[0], args[1:]; action {
+				case "list":
+					expectArgs(a, args, 0, "stamps list")
+					listStamps(node)
+				case "buy":
+					expectArgs(a, args, 0, "stamps buy --depth <n> <--amount <plur>|--ttl <duration>>")
+					if *depth < minBatchDepth {
+						usage("--depth must be at least %d", minBatchDepth)
+					}
+					buyStamp(node, stampAmount(node, *amount, *ttl), *depth, *label, *immutable)
+				case "topup":
+					expectArgs(a, args, 1, "stamps topup <batch> <--amount <plur>|--ttl <duration>>")
+					topUpStamp(node, batchArg(args[0]), stampAmount(node, *amount, *ttl))
+				case "dilute":
+					expectArgs(a, args, 1, "stamps dilute <batch> --depth <n>")
+					diluteStamp(node, batchArg(args[0]), *depth)
+				default:
+					usage("unknown stamps action '%s'. Use '%s help stamps' for usage.", action, a.execName)
+				}
+			}
+		},
+	}
+}
+
+func batchArg(value string) string {
+	if !finalride.ValidBatchID(value) {
+		usage("invalid batch ID %q: must be 64 hex characters", value)
+	}
+	return value
+}
+
+// stampAmount resolves --amount or --ttl to an amount in PLUR per chunk
+func stampAmount(node string, amount string, ttl time.Duration) *big.Int {
+	switch {
+	case amount != "" && ttl != 0:
+		usage("use either --amount or --ttl")
+	case amount != "":
+		value, ok := new(big.Int).SetString(amount, 10)
+		if !ok || value.Sign() <= 0 {
+			usage("invalid --amount %q: must be a positive whole number of PLUR", amount)
+		}
+		return value
+	case ttl > 0:
+		price, err := finalride.CurrentPrice(node)
+		if err != nil {
+			fail(exitNetwork, "Failed to read the storage price: %v", err)
+		}
+		return finalride.AmountForTTL(price, ttl)
+	}
+	usage("--amount or a positive --ttl is required")
+	return nil
+}
+
+func listStamps(node string) {
+	batches, err := finalride.ListStamps(node)
+	if err != nil {
+		fail(exitNetwork, "Failed to list stamps on %s: %v", node, err)
+	}
+
+	result := stampsResult{OK: true, Command: currentCommand, Action: "list", Node: node, Batches: []batchInfo{}}
+	fmt.Fprintf(out, "Node: %s\n", node)
+	fmt.Fprintln(out, "----------------------------------------")
+	for _, b := range batches {
+		info := batchInfo{
+			BatchID:     b.BatchID,
+			Label:       b.Label,
+			Usable:      b.Usable,
+			Immutable:   b.Immutable,
+			Depth:       b.Depth,
+			Utilization: b.Utilization,
+			Capacity:    b.Capacity(),
+			Remaining:   b.Remaining(),
+			Amount:      b.Amount,
+			TTLSeconds:  b.TTL,
+		}
+		result.Batches = append(result.Batches, info)
+
+		status := "usable"
+		if !b.Usable {
+			status = "pending"
+		}
+		fmt.Fprintf(out, "%s  %s\n", b.BatchID, b.Label)
+		fmt.Fprintf(out, "  %-8s depth %d, %s of %s free, expires in %s\n", status, b.Depth,
+			formatSize(b.Remaining()*finalride.SwarmChunkSize), formatSize(b.Capacity()*finalride.SwarmChunkSize), formatTTL(b.TTL))
+	}
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "%d batches\n", len(batches))
+	if jsonMode {
+		printResult(result)
+	}
+}
+
+func buyStamp(node string, amount *big.Int, depth uint, label string, immutable bool) {
+	cost := new(big.Int).Lsh(amount, depth)
+	stage("buy", "Buying a batch of %s for %s...", formatSize(int64(finalride.SwarmChunkSize)<<depth), finalride.FormatBZZ(cost))
+	batchID, err := finalride.BuyStamp(amount, depth, label, immutable, node)
+	if err != nil {
+		fail(exitNetwork, "Failed to buy stamp: %v", err)
+	}
+	fmt.Fprintf(out, "Batch ID: %s\n", batchID)
+	fmt.Fprintln(out, "The batch is usable once the purchase is confirmed, usually within a few minutes.")
+	if jsonMode {
+		printResult(stampsResult{OK: true, Command: currentCommand, Action: "buy", Node: node, BatchID: batchID, Amount: amount.String(), Depth: depth, Cost: finalride.FormatBZZ(cost)})
+	}
+}
+
+func topUpStamp(node string, batchID string, amount *big.Int) {
+	batch := findBatch(node, batchID)
+	cost := new(big.Int).Lsh(amount, batch.Depth)
+	stage("topup", "Topping up %s for %s...", batchID, finalride.FormatBZZ(cost))
+	if err := finalride.TopUpStamp(batchID, amount, node); err != nil {
+		fail(exitNetwork, "Failed to top up stamp: %v", err)
+	}
+	fmt.Fprintln(out, "Top-up submitted; the new time to live shows in stamps list once it is confirmed.")
+	if jsonMode {
+		printResult(stampsResult{OK: true, Command: currentCommand, Action: "topup", Node: node, BatchID: batchID, Amount: amount.String(), Cost: finalride.FormatBZZ(cost)})
+	}
+}
+
+func diluteStamp(node string, batchID string, depth uint) {
+	batch := findBatch(node, batchID)
+	if depth <= batch.Depth {
+		usage("--depth must be greater than the current depth %d", batch.Depth)
+	}
+	stage("dilute", "Diluting %s from depth %d to %d...", batchID, batch.Depth, depth)
+	if err := finalride.DiluteStamp(batchID, depth, node); err != nil {
+		fail(exitNetwork, "Failed to dilute stamp: %v", err)
+	}
+	fmt.Fprintf(out, "Capacity: %s, time to live about %s\n", formatSize(int64(finalride.SwarmChunkSize)<<depth), formatTTL(batch.TTL>>(depth-batch.Depth)))
+	if jsonMode {
+		printResult(stampsResult{OK: true, Command: currentCommand, Action: "dilute", Node: node, BatchID: batchID, Depth: depth})
+	}
+}
+
+// findBatch looks up a batch owned by the node
+func findBatch(node string, batchID string) finalride.PostageBatch {
+	batches, err := finalride.ListStamps(node)
+	if err != nil {
+		fail(exitNetwork, "Failed to list stamps on %s: %v", node, err)
+	}
+	for _, b := range batches {
+		if strings.EqualFold(b.BatchID, batchID) {
+			return b
+		}
+	}
+	fail(exitFailure, "Batch %s not found on %s", batchID, node)
+	return finalride.PostageBatch{}
+}
+
+func formatTTL(seconds int64) string {
+	d := time.Duration(seconds) * time.Second
+	if d >= 48*time.Hour {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	return d.Round(time.Minute).String()
+}
+
+// prepareStamps picks the postage batches for an upload and reports them with
+// the BZZ value of the slots it will use
+func prepareStamps(a *app, setting string, estimate *finalride.UploadEstimate) []stampInfo {
+	var slots int64
+	if estimate != nil {
+		slots = estimate.Chunks
+	}
+	chosen, err := a.store().PrepareStamps(setting, slots)
+	if err != nil {
+		fail(exitFailure, "No postage batch for this upload: %v (see '%s stamps')", err, a.execName)
+	}
+
+	stamps := []stampInfo{}
+	for _, s := range a.store().Status() {
+		batch, ok := chosen[s.URL]
+		if !ok {
+			continue
+		}
+		info := stampInfo{Endpoint: s.URL, BatchID: batch.BatchID, Remaining: batch.Remaining()}
+		line := fmt.Sprintf("Postage batch: %s on %s", batch.BatchID, s.URL)
+		if estimate != nil && batch.Amount != "" {
+			info.Cost = finalride.FormatBZZ(estimate.Cost(batch.AmountPLUR()))
+			line += fmt.Sprintf(" (%d of %d slots, ~%s)", slots, batch.Remaining(), info.Cost)
+		}
+		fmt.Fprintln(out, line)
+		stamps = append(stamps, info)
+	}
+	return stamps
+}
+
+// printEstimate reports the expected footprint of an upload
+func printEstimate(e finalride.UploadEstimate) *estimateInfo {
+	fmt.Fprintf(out, "Estimate: %d pieces, %s stored, ~%d stamp slots\n", e.Pieces, formatSize(e.Bytes), e.Chunks)
+	return &estimateInfo{Pieces: e.Pieces, Bytes: e.Bytes, Slots: e.Chunks}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// reuploadResult is the JSON result of the reupload command
+type reuploadResult struct {
+	OK      bool          `json:"ok"`
+	Command string        `json:"command"`
+	CID     string        `json:"cid"`
+	Source  string        `json:"source"` // "node" or the local file
+	Failed  int           `json:"failed"`
+	Pieces  []pieceResult `json:"pieces"`
+}
+
+// auditResult is the JSON result of the audit command
+type auditResult struct {
+	OK      bool         `json:"ok"`
+	Command string       `json:"command"`
+	Node    string       `json:"node"`
+	Shares  []auditShare `json:"shares"`
+	Failed  int          `json:"failed"` // Shares with unretrievable references
+	Timings timings      `json:"timings_ms"`
+}
+
+type auditShare struct {
+	CID      string        `json:"cid"`
+	Filename string        `json:"filename,omitempty"`
+	OK       bool          `json:"ok"`
+	Error    string        `json:"error,omitempty"`
+	Pieces   []pieceResult `json:"pieces,omitempty"` // References that were not retrievable
+}
+
+func reuploadCommand() *command {
+	return &command{
+		name:    "reupload",
+		args:    "<cid|url>",
+		summary: "Push an existing share to the network again",
+		examples: []string{
+			"reupload <CID>                     # Re-push from swarm_api via stewardship",
+			"reupload <CID> --from report.pdf   # Rebuild an unencrypted share from a local copy",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			from := fs.String("from", "", "Re-upload from a local copy of the file instead of the node")
+
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s reupload <cid|url> [--from <file>]", a.execName)
+				}
+				metadataCID := finalride.ParseReference(args[0])
+				if *from != "" {
+					reuploadFromFile(a, metadataCID, *from)
+					return
+				}
+				reuploadFromNode(a, metadataCID)
+			}
+		},
+	}
+}
+
+func reuploadFromNode(a *app, metadataCID string) {
+	node := a.config().SwarmAPI
+	metadata, err := finalride.FetchMetadata(metadataCID, a.store())
+	if err != nil {
+		fail(metadataExitCode(err), "Failed to fetch metadata: %v", err)
+	}
+
+	fmt.Fprintf(out, "Node:         %s\n", node)
+	fmt.Fprintf(out, "Metadata CID: %s\n", metadataCID)
+	fmt.Fprintf(out, "Filename:     %s\n", metadata.Filename)
+	fmt.Fprintln(out, "----------------------------------------")
+
+	result := reuploadResult{Command: currentCommand, CID: metadataCID, Source: "node"}
+	finalride.ReuploadShare(metadataCID, metadata, node, result.add)
+	finishReupload(result, exitNetwork)
+}
+
+func reuploadFromFile(a *app, metadataCID string, path string) {
+	// The original document is re-uploaded as is, so it must still be readable
+	document, err := a.store().Download(metadataCID)
+	if err != nil {
+		fail(exitNetwork, "Failed to fetch metadata: %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		fail(exitFailure, "Failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	fmt.Fprintf(out, "Metadata CID: %s\n", metadataCID)
+	fmt.Fprintf(out, "Local copy:   %s\n", path)
+	fmt.Fprintln(out, "----------------------------------------")
+
+	result := reuploadResult{Command: currentCommand, CID: metadataCID, Source: path}
+	_, err = finalride.ReuploadFromFile(metadataCID, document, file, a.store(), result.add)
+	if err != nil {
+		code := transferExitCode(err)
+		if errors.Is(err, finalride.ErrNotReproducible) {
+			code = exitFailure
+			err = fmt.Errorf("%v; use reupload without --from on a node that holds the share", err)
+		}
+		if len(result.Pieces) == 0 {
+			fail(code, "Reupload failed: %v", err)
+		}
+		fmt.Fprintf(out, "Reupload stopped: %v\n", err)
+		finishReupload(result, code)
+	}
+	finishReupload(result, exitNetwork)
+}
+
+func (r *reuploadResult) add(s finalride.StewardResult) {
+	piece := pieceResult{Index: s.Index, Reference: s.Reference, Status: "reuploaded"}
+	if s.Err != nil {
+		piece.Status = "failed"
+		piece.Error = s.Err.Error()
+		r.Failed++
+		fmt.Fprintf(out, "[FAIL] %-8s %s (%v)\n", s.Index, s.Reference, s.Err)
+	} else {
+		fmt.Fprintf(out, "[ OK ] %-8s %s\n", s.Index, s.Reference)
+	}
+	r.Pieces = append(r.Pieces, piece)
+}
+
+// finishReupload prints the summary and exits with failCode if any piece failed
+func finishReupload(result reuploadResult, failCode int) {
+	result.OK = result.Failed == 0
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "%d references re-uploaded, %d failed\n", len(result.Pieces)-result.Failed, result.Failed)
+	if jsonMode {
+		printResult(result)
+	}
+	if !result.OK {
+		os.Exit(failCode)
+	}
+	os.Exit(exitOK)
+}
+
+func auditCommand() *command {
+	return &command{
+		name:    "audit",
+		args:    "[cid|url...]",
+		summary: "Check that past uploads are still retrievable from the network",
+		examples: []string{
+			"audit                              # Every upload in the history",
+			"audit --since 720h --reupload      # Last month's uploads, re-pushing lost pieces",
+			"audit <CID>                        # A single share",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			limit := fs.Int("limit", 0, "Audit at most this many uploads from the history")
+			since := fs.String("since", "", "Only uploads newer than a duration (e.g. 48h) or a date (YYYY-MM-DD)")
+			reupload := fs.Bool("reupload", false, "Re-push unretrievable references from swarm_api")
+
+			return func(a *app, args []string) {
+				if *limit < 0 {
+					usage("--limit must not be negative")
+				}
+				cids := make([]string, 0, len(args))
+				for _, arg := range args {
+					cids = append(cids, finalride.ParseReference(arg))
+				}
+				if len(cids) == 0 {
+					filter := finalride.HistoryFilter{Action: finalride.HistoryUpload, Limit: *limit}
+					if *since != "" {
+						t, err := parseSince(*since)
+						if err != nil {
+							usage("invalid --since %q: use a duration like 48h or a date like 2006-01-02", *since)
+						}
+						filter.Since = t
+					}
+					cids = historyCIDs(filter)
+				}
+				runAudit(a, cids, *reupload)
+			}
+		},
+	}
+}
+
+// historyCIDs returns the distinct shares of the matching history entries,
+// newest first
+func historyCIDs(filter finalride.HistoryFilter) []string {
+	historyPath, err := finalride.DefaultHistoryPath()
+	if err != nil {
+		fail(exitFailure, "Failed to locate history: %v", err)
+	}
+	entries, err := finalride.LoadHistory(historyPath)
+	if err != nil {
+		fail(exitFailure, "Failed to load history: %v", err)
+	}
+
+	// Apply the limit after removing repeated uploads of the same share
+	limit := filter.Limit
+	filter.Limit = 0
+	seen := make(map[string]bool)
+	var cids []string
+	for _, entry := range finalride.FilterHistory(entries, filter) {
+		if seen[entry.CID] || (limit > 0 && len(cids) == limit) {
+			continue
+		}
+		seen[entry.CID] = true
+		cids = append(cids, entry.CID)
+	}
+	return cids
+}
+
+// runAudit checks the retrievability of every reference of each share through
+// the stewardship endpoint of swarm_api
+func runAudit(a *app, cids []string, reupload bool) {
+	totalStart := time.Now()
+	node := a.config().SwarmAPI
+	result := auditResult{Command: currentCommand, Node: node, Shares: []auditShare{}, Timings: timings{}}
+
+	fmt.Fprintf(out, "Node:   %s\n", node)
+	fmt.Fprintf(out, "Shares: %d\n", len(cids))
+	progress := &jsonProgress{stage: "audit", unit: "shares", total: int64(len(cids))}
+	for _, cid := range cids {
+		share := checkShare(cid, node, a.store(), reupload)
+		if !share.OK {
+			result.Failed++
+		}
+		result.Shares = append(result.Shares, share)
+		if jsonMode {
+			progress.Add(1)
+		}
+	}
+	result.OK = result.Failed == 0
+	result.Timings.record("total", time.Since(totalStart))
+
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "Audited %d shares in %s: %d ok, %d failed\n", len(cids), formatDuration(time.Since(totalStart)), len(cids)-result.Failed, result.Failed)
+	if jsonMode {
+		printResult(result)
+	} else if result.Failed > 0 {
+		fmt.Fprintln(out, "AUDIT FAILED")
+	} else {
+		fmt.Fprintln(out, "AUDIT PASSED")
+	}
+	if !result.OK {
+		os.Exit(exitNetwork)
+	}
+}
+
+// checkShare audits one share, optionally re-pushing lost references
+func checkShare(cid string, node string, store finalride.Store, reupload bool) auditShare {
+	share := auditShare{CID: cid, OK: true}
+	fmt.Fprintln(out, "----------------------------------------")
+
+	metadata, err := finalride.FetchMetadata(cid, store)
+	if err != nil {
+		share.OK = false
+		share.Error = fmt.Sprintf("failed to fetch metadata: %v", err)
+		fmt.Fprintf(out, "[FAIL] %s (%s)\n", cid, share.Error)
+		return share
+	}
+	share.Filename = metadata.Filename
+	fmt.Fprintf(out, "%s  %s\n", cid, metadata.Filename)
+
+	finalride.CheckShare(cid, metadata, node, func(r finalride.StewardResult) {
+		piece := pieceResult{Index: r.Index, Reference: r.Reference, Status: "retrievable"}
+		switch {
+		case r.Err != nil:
+			piece.Status = "failed"
+			piece.Error = r.Err.Error()
+		case !r.Retrievable && reupload:
+			if err := finalride.Reupload(r.Reference, node); err != nil {
+				piece.Status = "unretrievable"
+				piece.Error = fmt.Sprintf("reupload failed: %v", err)
+			} else {
+				piece.Status = "reuploaded"
+			}
+		case !r.Retrievable:
+			piece.Status = "unretrievable"
+		}
+
+		switch piece.Status {
+		case "retrievable":
+			return
+		case "reuploaded":
+			fmt.Fprintf(out, "  [REUPLOADED] %-8s %s\n", r.Index, r.Reference)
+		default:
+			share.OK = false
+			detail := piece.Status
+			if piece.Error != "" {
+				detail = piece.Error
+			}
+			fmt.Fprintf(out, "  [FAIL]       %-8s %s (%s)\n", r.Index, r.Reference, detail)
+		}
+		share.Pieces = append(share.Pieces, piece)
+	})
+	if len(share.Pieces) == 0 {
+		fmt.Fprintln(out, "  all references retrievable")
+	}
+	return share
+}
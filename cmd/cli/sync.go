@@ -0,0 +1,291 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// syncResult is the JSON result of the sync command
+type syncResult struct {
+	OK        bool         `json:"ok"`
+	Command   string       `json:"command"`
+	Dir       string       `json:"dir"`
+	DryRun    bool         `json:"dry_run"`
+	Manifest  string       `json:"manifest,omitempty"` // Missing for dry runs
+	Previous  string       `json:"previous,omitempty"`
+	Added     int          `json:"added"`
+	Updated   int          `json:"updated"`
+	Deleted   int          `json:"deleted"`
+	Unchanged int          `json:"unchanged"`
+	Failed    int          `json:"failed"`
+	Bytes     int64        `json:"bytes"` // Size of the new and changed files
+	Changes   []syncChange `json:"changes"`
+	Feed      *feedInfo    `json:"feed,omitempty"`
+	FeedError string       `json:"feed_error,omitempty"`
+	Timings   timings      `json:"timings_ms"`
+}
+
+// syncChange is one new, changed or deleted file
+type syncChange struct {
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	CID    string `json:"cid,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// stringList is a flag that may be repeated, collecting every value
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+func syncCommand() *command {
+	return &command{
+		name:    "sync",
+		args:    "<dir>",
+		summary: "Upload new and changed files of a folder and store a directory manifest",
+		examples: []string{
+			"sync D:/Shared                     # Upload what changed since the last run",
+			"sync D:/Shared --dry-run           # List the planned changes",
+			"sync D:/Shared --exclude '*.tmp' --exclude node_modules",
+			"sync D:/Shared --include 'docs/*.pdf' --feed shared",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			var include, exclude stringList
+			fs.Var(&include, "include", "Only sync paths matching this pattern (repeatable)")
+			fs.Var(&exclude, "exclude", "Skip paths matching this pattern (repeatable)")
+			statePath := fs.String("state", "", "Sync state file (default: "+finalride.SyncStateName+" in the folder)")
+			dryRun := fs.Bool("dry-run", false, "List the planned changes without uploading")
+			forceEncrypt := fs.Bool("encrypt", false, "Encrypt the files")
+			noEncrypt := fs.Bool("no-encrypt", false, "Do not encrypt the files (default: respects config.yaml)")
+			name := fs.String("name", "", "Directory name stored in the manifest (default: the folder name)")
+			feed := fs.String("feed", "", "Publish the manifest to this feed on swarm_api")
+			identity := fs.String("identity", "", "Key that signs feed updates (default: identity.key in the user config dir)")
+
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s sync [options] <dir>", a.execName)
+				}
+				dir := args[0]
+				if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+					usage("%s is not a directory", dir)
+				}
+				filter := finalride.PathFilter{Include: include, Exclude: exclude}
+				if err := filter.Validate(); err != nil {
+					usage("%v", err)
+				}
+				if *statePath == "" {
+					*statePath = filepath.Join(dir, finalride.SyncStateName)
+				}
+				if *name == "" {
+					abs, _ := filepath.Abs(dir)
+					*name = filepath.Base(abs)
+				}
+				encrypt := a.config().EncryptDefault
+				if *forceEncrypt {
+					encrypt = true
+				}
+				if *noEncrypt {
+					encrypt = false
+				}
+				runSync(a, dir, *statePath, filter, syncOptions{name: *name, encrypt: encrypt, dryRun: *dryRun, feed: *feed, identity: *identity})
+			}
+		},
+	}
+}
+
+// syncOptions holds the sync flags resolved against the configuration
+type syncOptions struct {
+	name     string
+	encrypt  bool
+	dryRun   bool
+	feed     string
+	identity string
+}
+
+func runSync(a *app, dir string, statePath string, filter finalride.PathFilter, opts syncOptions) {
+	config := a.config()
+	totalStart := time.Now()
+	steps := timings{}
+
+	state, err := finalride.LoadSyncState(statePath)
+	if err != nil {
+		fail(exitFailure, "%v", err)
+	}
+
+	stage("scan", "Scanning %s...", dir)
+	scanStart := time.Now()
+	changes, err := finalride.PlanSync(dir, state, filter, statePath)
+	if err != nil {
+		fail(exitFailure, "%v", err)
+	}
+	steps.record("scan", time.Since(scanStart))
+
+	result := syncResult{OK: true, Command: currentCommand, Dir: dir, DryRun: opts.dryRun, Previous: state.Manifest, Changes: []syncChange{}}
+	var estimate finalride.UploadEstimate
+	chunkSize := config.ChunkSizeMB * 1024 * 1024
+	fmt.Fprintln(out, "========================================")
+	for _, c := range changes {
+		symbol := "+"
+		switch c.Action {
+		case finalride.SyncUnchanged:
+			result.Unchanged++
+			continue
+		case finalride.SyncAdd:
+			result.Added++
+		case finalride.SyncUpdate:
+			result.Updated++
+			symbol = "~"
+		case finalride.SyncDelete:
+			result.Deleted++
+			fmt.Fprintf(out, "- %s\n", c.Entry.Path)
+			continue
+		}
+		result.Bytes += c.Entry.Size
+		e := finalride.EstimateUpload(c.Entry.Size, chunkSize, opts.encrypt)
+		estimate.Pieces += e.Pieces
+		estimate.Bytes += e.Bytes
+		estimate.Chunks += e.Chunks
+		fmt.Fprintf(out, "%s %s (%s)\n", symbol, c.Entry.Path, formatSize(c.Entry.Size))
+	}
+	fmt.Fprintf(out, "%d new, %d changed, %d deleted, %d unchanged; %s to upload\n", result.Added, result.Updated, result.Deleted, result.Unchanged, formatSize(result.Bytes))
+
+	if opts.dryRun {
+		fmt.Fprintln(out, "========================================")
+		for _, c := range changes {
+			if c.Action != finalride.SyncUnchanged {
+				result.Changes = append(result.Changes, syncChange{Action: c.Action, Path: c.Entry.Path, Size: c.Entry.Size})
+			}
+		}
+		steps.record("total", time.Since(totalStart))
+		result.Timings = steps
+		if jsonMode {
+			printResult(result)
+		}
+		return
+	}
+
+	// The manifest takes about as many slots as a small metadata document per file
+	manifest := finalride.EstimateUpload(int64(len(changes)+1)*256, chunkSize, false)
+	estimate.Chunks += manifest.Chunks
+	prepareStamps(a, config.PostageBatch, &estimate)
+	fmt.Fprintln(out, "========================================")
+
+	dedupKey, err := finalride.DedupKeyFor(config.Chunking, opts.encrypt, config.ConvergentEncryption)
+	if err != nil {
+		fail(exitCrypto, "%v", err)
+	}
+	a.store().TrackSync()
+	stage("upload", "[1/2] Uploading %d files...", result.Added+result.Updated)
+	uploadStart := time.Now()
+	bar := newByteProgress(result.Bytes, "upload", "Uploading       ")
+	synced, err := finalride.Sync(dir, state, changes, finalride.SyncOptions{
+		Name: opts.name,
+		Upload: finalride.UploadOptions{
+			Encrypt:    opts.encrypt,
+			ChunkSize:  chunkSize,
+			Store:      a.store(),
+			Chunking:   config.Chunking,
+			DedupKey:   dedupKey,
+			OnProgress: func(n int64) { bar.Add(int(n)) },
+		},
+		OnChange: func(c finalride.SyncChange) {
+			if c.Err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to upload %s: %v\n", c.Entry.Path, c.Err)
+			}
+		},
+	})
+	for _, c := range changes {
+		if c.Action == finalride.SyncUnchanged {
+			continue
+		}
+		change := syncChange{Action: c.Action, Path: c.Entry.Path, Size: c.Entry.Size, Error: errorString(c.Err)}
+		if c.Action != finalride.SyncDelete && c.Err == nil {
+			change.CID = c.Entry.Reference
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	if err != nil {
+		fail(transferExitCode(err), "Sync failed: %v", err)
+	}
+	steps.record("upload", time.Since(uploadStart))
+	result.Manifest, result.Failed = synced.Manifest, synced.Failed
+
+	// The manifest is stored, so record the state even if some files failed
+	stage("state", "[2/2] Saving sync state...")
+	if err := finalride.SaveSyncState(statePath, state); err != nil {
+		fail(exitFailure, "%v", err)
+	}
+
+	var feedErr error
+	if opts.feed != "" {
+		feedStart := time.Now()
+		info, err := publishFeed(a, opts.identity, opts.feed, synced.Manifest)
+		if err == nil {
+			result.Feed = &info
+		}
+		feedErr = err
+		result.FeedError = errorString(err)
+		steps.record("feed", time.Since(feedStart))
+	}
+
+	steps.record("total", time.Since(totalStart))
+	result.Timings = steps
+	result.OK = synced.Failed == 0 && feedErr == nil
+
+	fmt.Fprintln(out, "\n========================================")
+	fmt.Fprintln(out, "SYNC COMPLETE")
+	fmt.Fprintln(out, "========================================")
+	fmt.Fprintf(out, "Manifest CID: %s\n", synced.Manifest)
+	fmt.Fprintf(out, "Files: %d (%s)\n", len(synced.Directory.Files), formatSize(synced.Directory.Size()))
+	fmt.Fprintf(out, "Uploaded: %d files, %s in %s\n", result.Added+result.Updated-synced.Failed, formatSize(synced.Uploaded), formatDuration(time.Since(uploadStart)))
+	if result.Previous != "" {
+		fmt.Fprintf(out, "Previous: %s\n", result.Previous)
+	}
+	fmt.Fprintf(out, "State: %s\n", statePath)
+
+	keyHandling := finalride.KeyNone
+	if opts.encrypt {
+		keyHandling = finalride.KeyEmbedded
+	}
+	recordHistory(finalride.HistoryEntry{
+		Action:      finalride.HistoryUpload,
+		Filename:    opts.name + "/",
+		Size:        synced.Directory.Size(),
+		CID:         synced.Manifest,
+		Encrypted:   opts.encrypt,
+		KeyHandling: keyHandling,
+		Gateway:     config.SwarmAPI,
+		Feed:        feedLabel(result.Feed),
+	})
+
+	if jsonMode {
+		printResult(result)
+	}
+	if synced.Failed > 0 {
+		if !jsonMode {
+			log.Printf("%d files could not be uploaded; their previous versions are kept in the manifest", synced.Failed)
+		}
+		os.Exit(exitNetwork)
+	}
+	if feedErr != nil {
+		if !jsonMode {
+			log.Printf("Synced %s, but %v", synced.Manifest, feedErr)
+		}
+		os.Exit(exitNetwork)
+	}
+}
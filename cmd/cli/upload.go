@@ -0,0 +1,500 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// uploadResult is the JSON result of the upload command
+type uploadResult struct {
+	OK          bool              `json:"ok"`
+	Command     string            `json:"command"`
+	CID         string            `json:"cid"`
+	Link        string            `json:"link"`
+	Filename    string            `json:"filename"`
+	Size        int64             `json:"size"`
+	Encrypted   bool              `json:"encrypted"`
+	Chunked     bool              `json:"chunked"`
+	Chunks      int               `json:"chunks"`
+	Chunking    string            `json:"chunking"`
+	Reused      int               `json:"reused"`       // Pieces already stored, e.g. by an earlier version
+	ReusedBytes int64             `json:"reused_bytes"` // Input bytes in those pieces
+	FileHash    string            `json:"file_hash,omitempty"`
+	ChunkHashes map[string]string `json:"chunk_hashes,omitempty"`
+	Placement   []placementInfo   `json:"placement,omitempty"` // Only for replicated uploads
+	Sync        *syncInfo         `json:"sync,omitempty"`      // Missing if the endpoint does not support tags
+	Estimate    *estimateInfo     `json:"estimate,omitempty"`  // Missing for standard input
+	Stamps      []stampInfo       `json:"stamps,omitempty"`    // Batches used on nodes that stamp with our own batch
+	Feed        *feedInfo         `json:"feed,omitempty"`
+	FeedError   string            `json:"feed_error,omitempty"` // Set if the upload could not be published to the feed
+	Timings     timings           `json:"timings_ms"`
+}
+
+// syncInfo reports how much of an upload has reached the network
+type syncInfo struct {
+	Done   bool      `json:"done"`
+	Synced int64     `json:"synced"`
+	Total  int64     `json:"total"`
+	Tags   []tagInfo `json:"tags"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type tagInfo struct {
+	Endpoint string `json:"endpoint"`
+	UID      uint32 `json:"uid"`
+}
+
+// uploadOptions holds the upload flags resolved against the configuration
+type uploadOptions struct {
+	name        string
+	encrypt     bool
+	replicas    *finalride.ReplicatedStore // Write every piece to all endpoints, if set
+	waitSync    bool
+	syncTimeout time.Duration
+	batch       string // Postage batch setting, see finalride.Pool.PrepareStamps
+	estimate    bool   // Only print the estimate
+	feed        string // Feed to publish the upload to, if set
+	identity    string // Key that signs the feed update
+	chunking    string // finalride.ChunkingFixed or finalride.ChunkingContentDefined
+	convergent  bool   // Derive content-defined chunk keys from the content alone
+	base        string // Earlier version whose pieces are reused, if set
+}
+
+func uploadCommand() *command {
+	return &command{
+		name:    "upload",
+		args:    "<file|->",
+		summary: "Upload file to Swarm (\"-\" reads standard input)",
+		examples: []string{
+			"upload myfile.txt                  # Upload (uses config.yaml default)",
+			"upload myfile.txt --encrypt        # Force encryption",
+			"upload myfile.txt --no-encrypt     # Force no-encryption",
+			"upload myfile.txt --json | jq .cid # Script-friendly upload",
+			"upload -- --odd-name.txt           # Filenames starting with dashes",
+			"upload - --name db.sql < dump.sql  # Upload standard input",
+			"upload release.tar --replicate     # Store on every configured endpoint",
+			"upload release.tar --wait-sync     # Return once the network has every chunk",
+			"upload video.mp4 --estimate        # Stamp slots and BZZ, without uploading",
+			"upload build.zip --feed nightly    # Update a stable link to the new version",
+			"upload disk.img --chunking content-defined --base <CID> # Only store what changed",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			forceEncrypt := fs.Bool("encrypt", false, "Force upload with encryption")
+			noEncrypt := fs.Bool("no-encrypt", false, "Force upload without encryption (default: respects config.yaml)")
+			chunkSizeMB := fs.Int("chunk-size", 0, "Chunk size in MB (default: chunk_size_mb from the config)")
+			name := fs.String("name", "", "Filename stored in the metadata (required when reading standard input)")
+			forcePin := fs.Bool("pin", false, "Ask the node to pin the uploaded data")
+			noPin := fs.Bool("no-pin", false, "Do not pin the uploaded data (default: respects pin_uploads)")
+			replicate := fs.Bool("replicate", false, "Store every piece on all configured endpoints and check they agree")
+			minReplicas := fs.Int("min-replicas", 0, "With --replicate, endpoints that must store each piece (default: all)")
+			waitSync := fs.Bool("wait-sync", false, "Wait until every chunk has synced to the network")
+			syncTimeout := fs.Duration("sync-timeout", 10*time.Minute, "Give up waiting for sync after this long")
+			batch := fs.String("batch", "", "Postage batch: auto, a batch ID or none (default: postage_batch)")
+			estimate := fs.Bool("estimate", false, "Print the stamp slots and cost of the upload without uploading")
+			feed := fs.String("feed", "", "Publish the upload to this feed on swarm_api")
+			identity := fs.String("identity", "", "Key that signs feed updates (default: identity.key in the user config dir)")
+			chunking := fs.String("chunking", "", "fixed or content-defined (default: chunking from the config)")
+			convergent := fs.Bool("convergent", false, "With content-defined chunking, derive chunk keys from the content alone so identical chunks dedupe across users")
+			base := fs.String("base", "", "Earlier version (CID or link) whose unchanged pieces are reused instead of uploaded")
+
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s upload [options] <file|->", a.execName)
+				}
+				if args[0] == "-" && *name == "" {
+					usage("--name is required when uploading standard input")
+				}
+				config := a.config()
+
+				opts := uploadOptions{name: *name, encrypt: config.EncryptDefault, waitSync: *waitSync, syncTimeout: *syncTimeout, batch: config.PostageBatch, estimate: *estimate, feed: *feed, identity: *identity,
+					chunking: config.Chunking, convergent: config.ConvergentEncryption || *convergent, base: *base}
+				switch *chunking {
+				case "":
+				case finalride.ChunkingFixed, finalride.ChunkingContentDefined:
+					opts.chunking = *chunking
+				default:
+					usage("invalid --chunking %q: use %s or %s", *chunking, finalride.ChunkingFixed, finalride.ChunkingContentDefined)
+				}
+				if opts.chunking == "" {
+					opts.chunking = finalride.ChunkingFixed
+				}
+				if *convergent && opts.chunking != finalride.ChunkingContentDefined {
+					usage("--convergent requires --chunking content-defined")
+				}
+				if *forceEncrypt {
+					opts.encrypt = true
+				}
+				if *noEncrypt {
+					opts.encrypt = false
+				}
+				if *chunkSizeMB < 0 {
+					usage("--chunk-size must be positive")
+				}
+				if *chunkSizeMB > 0 {
+					config.ChunkSizeMB = *chunkSizeMB
+				}
+				switch *batch {
+				case "":
+				case "none":
+					opts.batch = ""
+				case finalride.BatchAuto:
+					opts.batch = *batch
+				default:
+					opts.batch = batchArg(*batch)
+				}
+				if *estimate && args[0] == "-" {
+					usage("--estimate needs a file; the size of standard input is unknown")
+				}
+				if *minReplicas < 0 {
+					usage("--min-replicas must be positive")
+				}
+				if *minReplicas > 0 && !*replicate {
+					usage("--min-replicas requires --replicate")
+				}
+
+				if *forcePin {
+					a.store().SetPin(true)
+				}
+				if *noPin {
+					a.store().SetPin(false)
+				}
+
+				if *replicate {
+					opts.replicas = finalride.NewReplicatedStore(a.store(), *minReplicas)
+				}
+				runUpload(a, args[0], opts)
+			}
+		},
+	}
+}
+
+// runUpload uploads a file or standard input
+func runUpload(a *app, file string, opts uploadOptions) {
+	config := a.config()
+	name, replicas := opts.name, opts.replicas
+	var store finalride.Store = a.store()
+	if replicas != nil {
+		store = replicas
+	}
+	a.store().TrackSync()
+
+	// Convert chunk size from MB to bytes
+	chunkSizeBytes := config.ChunkSizeMB * 1024 * 1024
+
+	totalStart := time.Now()
+	steps := timings{}
+
+	// A negative size means the input length is unknown (standard input)
+	input := &trackedReader{r: os.Stdin}
+	fileSize := int64(-1)
+	if file != "-" {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			usage("File does not exist: %s", file)
+		} else if err != nil {
+			fail(exitFailure, "Failed to open file: %v", err)
+		}
+		defer f.Close()
+
+		fileInfo, err := f.Stat()
+		if err != nil {
+			fail(exitFailure, "Failed to stat file: %v", err)
+		}
+		if fileInfo.IsDir() {
+			usage("%s is a directory", file)
+		}
+		input.r = f
+		fileSize = fileInfo.Size()
+		if name == "" {
+			name = filepath.Base(file)
+		}
+	}
+
+	fmt.Fprintln(out, "========================================")
+	fmt.Fprintf(out, "File: %s\n", name)
+	if fileSize >= 0 {
+		fmt.Fprintf(out, "Size: %s (%d bytes)\n", formatSize(fileSize), fileSize)
+	} else {
+		fmt.Fprintln(out, "Size: unknown (standard input)")
+	}
+	fmt.Fprintf(out, "Encryption: %v\n", opts.encrypt)
+	if opts.chunking == finalride.ChunkingContentDefined {
+		fmt.Fprintf(out, "Chunk size: %d MB average (content-defined)\n", config.ChunkSizeMB)
+	} else {
+		fmt.Fprintf(out, "Chunk size: %d MB\n", config.ChunkSizeMB)
+	}
+	if replicas != nil {
+		fmt.Fprintf(out, "Replicas: %d endpoints\n", len(replicas.Status()))
+	}
+	var estimate *finalride.UploadEstimate
+	var estimated *estimateInfo
+	if fileSize >= 0 {
+		e := finalride.EstimateUpload(fileSize, chunkSizeBytes, opts.encrypt)
+		estimate, estimated = &e, printEstimate(e)
+	}
+	stamps := prepareStamps(a, opts.batch, estimate)
+	fmt.Fprintln(out, "========================================")
+
+	if opts.estimate {
+		if jsonMode {
+			printResult(estimateResult{OK: true, Command: currentCommand, Filename: name, Size: fileSize, Encrypted: opts.encrypt, Estimate: estimated, Stamps: stamps})
+		}
+		return
+	}
+
+	uploadOpts := dedupOptions(a, opts)
+	if opts.encrypt {
+		stage("upload", "[1/2] Encrypting and uploading...")
+	} else {
+		stage("upload", "[1/2] Uploading (--no-encrypt)...")
+	}
+	uploadStart := time.Now()
+	bar := newByteProgress(fileSize, "upload", "Uploading       ")
+
+	var reused int
+	var reusedBytes int64
+	uploadOpts.Filename = name
+	uploadOpts.Encrypt = opts.encrypt
+	uploadOpts.ChunkSize = chunkSizeBytes
+	uploadOpts.Store = store
+	uploadOpts.OnProgress = func(n int64) { bar.Add(int(n)) }
+	uploadOpts.OnReuse = func(n int64) {
+		reused++
+		reusedBytes += n
+	}
+	metadata, err := finalride.UploadStream(input, uploadOpts)
+	if input.err != nil {
+		fail(exitFailure, "Failed to read input: %v", input.err)
+	}
+	if err != nil {
+		fail(transferExitCode(err), "Upload failed: %v", err)
+	}
+
+	uploadDuration := time.Since(uploadStart)
+	steps.record("upload", uploadDuration)
+	uploadSpeed := float64(metadata.Size) / uploadDuration.Seconds()
+	fmt.Fprintf(out, "      Upload complete: %s in %d pieces, %s (%s)\n", formatSize(metadata.Size), metadata.ChunkCount(), formatDuration(uploadDuration), formatSpeed(uploadSpeed))
+	if reused > 0 {
+		fmt.Fprintf(out, "      Reused %d of %d pieces already stored (%s not uploaded again)\n", reused, metadata.ChunkCount(), formatSize(reusedBytes))
+	}
+
+	stage("metadata", "[2/2] Uploading metadata...")
+	metadataStart := time.Now()
+	metadataCID, err := finalride.UploadMetadata(metadata, store)
+	if err != nil {
+		fail(exitNetwork, "Failed to upload metadata: %v", err)
+	}
+	steps.record("metadata", time.Since(metadataStart))
+
+	// The upload itself succeeded, so a feed failure is reported at the end
+	var feed *feedInfo
+	var feedErr error
+	if opts.feed != "" {
+		feedStart := time.Now()
+		info, err := publishFeed(a, opts.identity, opts.feed, metadataCID)
+		if err == nil {
+			feed = &info
+		}
+		feedErr = err
+		steps.record("feed", time.Since(feedStart))
+	}
+
+	syncStart := time.Now()
+	sync := checkSync(a, opts)
+	if opts.waitSync {
+		steps.record("sync", time.Since(syncStart))
+	}
+
+	totalDuration := time.Since(totalStart)
+	steps.record("total", totalDuration)
+	avgSpeed := float64(metadata.Size) / totalDuration.Seconds()
+	shareLink := fmt.Sprintf(config.DownloadLink, metadataCID)
+
+	fmt.Fprintln(out, "\n========================================")
+	fmt.Fprintln(out, "UPLOAD SUCCESSFUL!")
+	fmt.Fprintln(out, "========================================")
+	fmt.Fprintf(out, "Metadata CID: %s\n", metadataCID)
+	fmt.Fprintf(out, "Encrypted: %v\n", metadata.Encrypted)
+	fmt.Fprintf(out, "Chunked: %v\n", metadata.Chunked)
+	if metadata.Chunked {
+		fmt.Fprintf(out, "Chunks: %d\n", len(metadata.ChunkIDs))
+	}
+	var placement []placementInfo
+	if replicas != nil {
+		placement = printPlacement(replicas, metadata, metadataCID)
+	}
+	printSync(sync)
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "Total time: %s\n", formatDuration(totalDuration))
+	fmt.Fprintf(out, "Average speed: %s\n", formatSpeed(avgSpeed))
+	fmt.Fprintln(out, "----------------------------------------")
+	fmt.Fprintf(out, "Shareable Download Link:\n%s\n", shareLink)
+	if feed != nil {
+		fmt.Fprintf(out, "Feed Link (always the latest version):\n%s\n", feed.Link)
+	}
+
+	recordHistory(finalride.HistoryEntry{
+		Action:      finalride.HistoryUpload,
+		Filename:    metadata.Filename,
+		Size:        metadata.Size,
+		CID:         metadataCID,
+		Encrypted:   metadata.Encrypted,
+		KeyHandling: finalride.KeyHandlingFor(metadata),
+		Gateway:     config.SwarmAPI,
+		Link:        shareLink,
+		Feed:        feedLabel(feed),
+	})
+
+	syncFailed := opts.waitSync && (sync == nil || !sync.Done)
+	if jsonMode {
+		printResult(uploadResult{
+			OK:          !syncFailed && feedErr == nil,
+			Command:     currentCommand,
+			CID:         metadataCID,
+			Link:        shareLink,
+			Filename:    metadata.Filename,
+			Size:        metadata.Size,
+			Encrypted:   metadata.Encrypted,
+			Chunked:     metadata.Chunked,
+			Chunks:      metadata.ChunkCount(),
+			Chunking:    metadata.ChunkingScheme(),
+			Reused:      reused,
+			ReusedBytes: reusedBytes,
+			FileHash:    metadata.FileHash,
+			ChunkHashes: metadata.ChunkHashes,
+			Placement:   placement,
+			Sync:        sync,
+			Estimate:    estimated,
+			Stamps:      stamps,
+			Feed:        feed,
+			FeedError:   errorString(feedErr),
+			Timings:     steps,
+		})
+	}
+	if feedErr != nil {
+		if !jsonMode {
+			log.Printf("Uploaded %s, but %v", metadataCID, feedErr)
+		}
+		os.Exit(exitNetwork)
+	}
+	if syncFailed {
+		if !jsonMode {
+			log.Printf("Could not confirm that %s has synced to the network", metadataCID)
+		}
+		os.Exit(exitNetwork)
+	}
+}
+
+// dedupOptions returns the chunking options of an upload. Content-defined
+// chunks are encrypted with keys derived from the dedup key unless convergent,
+// and the pieces of --base, or else of the latest version of the feed being
+// published to, are offered for reuse.
+func dedupOptions(a *app, opts uploadOptions) finalride.UploadOptions {
+	upload := finalride.UploadOptions{Chunking: opts.chunking}
+	var err error
+	if upload.DedupKey, err = finalride.DedupKeyFor(opts.chunking, opts.encrypt, opts.convergent); err != nil {
+		fail(exitCrypto, "%v", err)
+	}
+
+	base := finalride.ParseReference(opts.base)
+	if base == "" && opts.feed != "" && opts.chunking == finalride.ChunkingContentDefined {
+		owner := loadIdentity(opts.identity).Owner()
+		update, err := finalride.LatestFeedUpdate(owner, finalride.FeedTopic(opts.feed), a.config().SwarmAPI)
+		switch {
+		case err == nil:
+			base = update.Reference
+		case !errors.Is(err, finalride.ErrNoFeedUpdate):
+			fmt.Fprintf(os.Stderr, "Warning: cannot reuse the previous version of feed %q: %v\n", opts.feed, err)
+		}
+	}
+	if base == "" {
+		return upload
+	}
+	metadata, err := finalride.FetchMetadata(base, finalride.NewCachedStore(a.store(), a.chunkCache()))
+	if err != nil && opts.base == "" {
+		fmt.Fprintf(os.Stderr, "Warning: cannot reuse the previous version of feed %q: %v\n", opts.feed, err)
+		return upload
+	}
+	if err != nil {
+		fail(metadataExitCode(err), "Failed to fetch --base metadata: %v", err)
+	}
+	upload.Known = finalride.KnownPieces(metadata)
+	fmt.Fprintf(out, "Base: %s (%d pieces)\n", base, metadata.ChunkCount())
+	return upload
+}
+
+// checkSync reads the upload tags once, or waits for the network to sync every
+// chunk if requested. It returns nil if no endpoint supports tags.
+func checkSync(a *app, opts uploadOptions) *syncInfo {
+	var status finalride.SyncStatus
+	var err error
+	if opts.waitSync {
+		stage("sync", "Waiting for the network to sync...")
+		var bar progressReporter
+		var reported int64
+		status, err = a.store().WaitSynced(opts.syncTimeout, 2*time.Second, func(s finalride.SyncStatus) {
+			if bar == nil {
+				bar = newCountProgress(s.Total(), "sync", "Syncing         ")
+			}
+			if s.Synced > reported {
+				bar.Add(int(s.Synced - reported))
+				reported = s.Synced
+			}
+		})
+	} else {
+		status, err = a.store().SyncStatus()
+	}
+	if errors.Is(err, finalride.ErrSyncUnsupported) {
+		return nil
+	}
+
+	info := &syncInfo{Done: status.Done(), Synced: status.Synced, Total: status.Total()}
+	for _, t := range status.Tags {
+		info.Tags = append(info.Tags, tagInfo{Endpoint: t.URL, UID: t.Tag.UID})
+	}
+	if err != nil {
+		info.Error = err.Error()
+	}
+	return info
+}
+
+func printSync(sync *syncInfo) {
+	switch {
+	case sync == nil:
+		fmt.Fprintln(out, "Sync: not tracked (the endpoint does not support tags)")
+	case sync.Error != "":
+		fmt.Fprintf(out, "Sync: %d of %d chunks synced (%s)\n", sync.Synced, sync.Total, sync.Error)
+	case sync.Done:
+		fmt.Fprintf(out, "Sync: all %d chunks synced to the network\n", sync.Total)
+	default:
+		fmt.Fprintf(out, "Sync: %d of %d chunks synced; links may not work for others yet (use --wait-sync)\n", sync.Synced, sync.Total)
+	}
+	if sync != nil {
+		for _, t := range sync.Tags {
+			fmt.Fprintf(out, "      Tag %d on %s\n", t.UID, t.Endpoint)
+		}
+	}
+}
+
+// feedLabel names a feed version in the history
+func feedLabel(feed *feedInfo) string {
+	if feed == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s#%d", feed.Name, feed.Index)
+}
+
+// errorString returns the message of err, or "" for nil
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+
+	"final-ride/internal/finalride"
+)
+
+// linkSuffix is appended to a file's name for the sidecar holding its link
+const linkSuffix = ".link"
+
+// watchResult is the JSON result of the watch command, printed on shutdown
+type watchResult struct {
+	OK       bool        `json:"ok"`
+	Command  string      `json:"command"`
+	Dir      string      `json:"dir"`
+	Uploaded int         `json:"uploaded"`
+	Failed   int         `json:"failed"`
+	Files    []watchFile `json:"files"`
+}
+
+// watchFile is one file picked up by the watch command
+type watchFile struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	CID       string `json:"cid,omitempty"`
+	Link      string `json:"link,omitempty"`
+	Error     string `json:"error,omitempty"`
+	HookError string `json:"hook_error,omitempty"`
+}
+
+// watchOptions holds the watch flags resolved against the configuration
+type watchOptions struct {
+	encrypt  bool
+	quiet    time.Duration
+	existing bool
+	logPath  string // Append links here instead of writing sidecars, if set
+	hook     string // Command run after each upload, if set
+	filter   finalride.PathFilter
+}
+
+func watchCommand() *command {
+	return &command{
+		name:    "watch",
+		args:    "<dir>",
+		summary: "Upload files dropped into a folder as they are completed",
+		examples: []string{
+			"watch D:/Outbox                    # Write <file>.link next to each upload",
+			"watch D:/Outbox --log links.txt    # Append the links to a log instead",
+			"watch D:/Outbox --exclude '*.crdownload' --debounce 5s",
+			"watch ~/outbox --exec 'notify-send \"$FINAL_RIDE_FILE\" \"$FINAL_RIDE_LINK\"'",
+		},
+		setup: func(fs *flag.FlagSet) runFunc {
+			var include, exclude stringList
+			fs.Var(&include, "include", "Only upload file names matching this pattern (repeatable)")
+			fs.Var(&exclude, "exclude", "Skip file names matching this pattern (repeatable)")
+			debounce := fs.Duration("debounce", finalride.DefaultWatchQuiet, "How long a file must stay unchanged before it is uploaded")
+			existing := fs.Bool("existing", false, "Also upload the files already in the folder")
+			logPath := fs.String("log", "", "Append \"time, file, CID, link\" lines to this file instead of writing .link sidecars")
+			hook := fs.String("exec", "", "Command run after each upload, with FINAL_RIDE_FILE, FINAL_RIDE_CID and FINAL_RIDE_LINK set")
+			forceEncrypt := fs.Bool("encrypt", false, "Encrypt the files")
+			noEncrypt := fs.Bool("no-encrypt", false, "Do not encrypt the files (default: respects config.yaml)")
+
+			return func(a *app, args []string) {
+				if len(args) != 1 {
+					usage("Usage: %s watch [options] <dir>", a.execName)
+				}
+				if info, err := os.Stat(args[0]); err != nil || !info.IsDir() {
+					usage("%s is not a directory", args[0])
+				}
+				filter := finalride.PathFilter{Include: include, Exclude: exclude}
+				if err := filter.Validate(); err != nil {
+					usage("%v", err)
+				}
+				if *debounce <= 0 {
+					usage("--debounce must be positive")
+				}
+				encrypt := a.config().EncryptDefault
+				if *forceEncrypt {
+					encrypt = true
+				}
+				if *noEncrypt {
+					encrypt = false
+				}
+				runWatch(a, args[0], watchOptions{encrypt: encrypt, quiet: *debounce, existing: *existing, logPath: *logPath, hook: *hook, filter: filter})
+			}
+		},
+	}
+}
+
+func runWatch(a *app, dir string, opts watchOptions) {
+	config := a.config()
+	dedupKey, err := finalride.DedupKeyFor(config.Chunking, opts.encrypt, config.ConvergentEncryption)
+	if err != nil {
+		fail(exitCrypto, "%v", err)
+	}
+	if opts.logPath != "" {
+		opts.logPath, _ = filepath.Abs(opts.logPath)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	stage("watch", "Watching %s (Ctrl+C to stop)", dir)
+	fmt.Fprintf(out, "Encryption: %v\n", opts.encrypt)
+	if opts.logPath != "" {
+		fmt.Fprintf(out, "Links: appended to %s\n", opts.logPath)
+	} else {
+		fmt.Fprintf(out, "Links: written to <file>%s\n", linkSuffix)
+	}
+
+	result := watchResult{OK: true, Command: currentCommand, Dir: dir, Files: []watchFile{}}
+	err = finalride.WatchFolder(ctx, dir, finalride.WatchOptions{
+		Filter:   opts.filter,
+		Quiet:    opts.quiet,
+		Existing: opts.existing,
+		Sidecar:  linkSuffix,
+		Skip: func(path string) bool {
+			abs, _ := filepath.Abs(path)
+			return abs == opts.logPath
+		},
+		OnReady: func(path string) {
+			file := watchUpload(a, path, opts, dedupKey)
+			if file.Error != "" {
+				result.Failed++
+				log.Printf("Failed to upload %s: %s", filepath.Base(path), file.Error)
+			} else {
+				result.Uploaded++
+			}
+			result.Files = append(result.Files, file)
+		},
+	})
+	if err != nil {
+		fail(exitFailure, "%v", err)
+	}
+
+	fmt.Fprintf(out, "\nStopped: %d uploaded, %d failed\n", result.Uploaded, result.Failed)
+	if jsonMode {
+		printResult(result)
+	}
+}
+
+// watchUpload uploads one completed file, records its link next to it or in
+// the log, and runs the hook
+func watchUpload(a *app, path string, opts watchOptions, dedupKey []byte) watchFile {
+	config := a.config()
+	name := filepath.Base(path)
+	file := watchFile{Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	file.Size = info.Size()
+
+	stage("upload", "Uploading %s (%s)...", name, formatSize(file.Size))
+	chunkSize := config.ChunkSizeMB * 1024 * 1024
+	estimate := finalride.EstimateUpload(file.Size, chunkSize, opts.encrypt)
+	if _, err := a.store().PrepareStamps(config.PostageBatch, estimate.Chunks); err != nil {
+		file.Error = fmt.Sprintf("no postage batch: %v", err)
+		return file
+	}
+	metadata, err := finalride.UploadStream(f, finalride.UploadOptions{
+		Filename:  name,
+		Encrypt:   opts.encrypt,
+		ChunkSize: chunkSize,
+		Store:     a.store(),
+		Chunking:  config.Chunking,
+		DedupKey:  dedupKey,
+	})
+	if err != nil {
+		file.Error = err.Error()
+		return file
+	}
+	if file.CID, err = finalride.UploadMetadata(metadata, a.store()); err != nil {
+		file.Error = fmt.Sprintf("failed to upload metadata: %v", err)
+		return file
+	}
+	file.Link = fmt.Sprintf(config.DownloadLink, file.CID)
+	fmt.Fprintf(out, "      %s -> %s\n", name, file.Link)
+
+	recordHistory(finalride.HistoryEntry{
+		Action:      finalride.HistoryUpload,
+		Filename:    metadata.Filename,
+		Size:        metadata.Size,
+		CID:         file.CID,
+		Encrypted:   metadata.Encrypted,
+		KeyHandling: finalride.KeyHandlingFor(metadata),
+		Gateway:     config.SwarmAPI,
+		Link:        file.Link,
+	})
+
+	if err := writeLink(path, file, opts.logPath); err != nil {
+		log.Printf("Uploaded %s, but %v", name, err)
+	}
+	if opts.hook != "" {
+		if err := runHook(opts.hook, file); err != nil {
+			file.HookError = err.Error()
+			log.Printf("Hook for %s failed: %v", name, err)
+		}
+	}
+	return file
+}
+
+// writeLink appends the link to the log, or writes it to the file's sidecar
+func writeLink(path string, file watchFile, logPath string) error {
+	if logPath == "" {
+		if err := os.WriteFile(path+linkSuffix, []byte(file.Link+"\n"), 0o644); err != nil {
+			return fmt.Errorf("failed to write link: %v", err)
+		}
+		return nil
+	}
+	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open link log: %v", err)
+	}
+	line := fmt.Sprintf("%s\t%s\t%s\t%s\n", time.Now().Format(time.RFC3339), filepath.Base(path), file.CID, file.Link)
+	if _, err := f.WriteString(line); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write link log: %v", err)
+	}
+	return f.Close()
+}
+
+// runHook runs the post-upload command through the system shell, passing the
+// upload in environment variables. Its output goes to stderr so that it does
+// not mix with JSON results.
+func runHook(command string, file watchFile) error {
+	cmd := exec.Command("sh", "-c", command)
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	}
+	cmd.Env = append(os.Environ(), "FINAL_RIDE_FILE="+file.Path, "FINAL_RIDE_CID="+file.CID, "FINAL_RIDE_LINK="+file.Link)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	return cmd.Run()
+}
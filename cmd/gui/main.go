@@ -1,13 +1,11 @@
 package main
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
-	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -73,7 +71,7 @@ var (
 
 // Icons
 var (
-	icMenu, icTheme, icUpload, icDownload, icSettings, icInfo, icClose, icCheck, icFolder, icPaste *widget.Icon
+	icMenu, icTheme, icUpload, icDownload, icSettings, icInfo, icClose, icCheck, icFolder, icPaste, icHistory *widget.Icon
 )
 
 // AppState holds the application state
@@ -81,26 +79,33 @@ type AppState struct {
 	mu sync.Mutex
 
 	// UI State
-	currentTab     int // 0=Upload, 1=Download, 2=Settings
-	isSidebarOpen  bool
-	isDarkMode     bool
-	filePath       string
-	
+	currentTab    int // 0=Upload, 1=Download, 2=Settings, 3=History
+	isSidebarOpen bool
+	isDarkMode    bool
+	filePath      string
+
 	// Settings
 	downloadDir    string
 	encryptDefault bool
 
-	metadataCID    string
-	encryptFile    bool
-	isProcessing   bool
-	progress       float32
-	status         string
-	logs           []string
-	resultCID      string
-	speed          string
+	metadataCID  string
+	preview      *finalride.Metadata // Metadata fetched for confirmation
+	previewCID   string
+	encryptFile  bool
+	keepPinned   bool
+	isProcessing bool
+	progress     float32
+	status       string
+	logs         []string
+	resultCID    string
+	speed        string
+	syncStatus   string // Network sync progress of the last upload
+	syncGen      int    // Incremented per upload so stale sync watchers stop
+
+	// History
+	history []finalride.HistoryEntry
 
 	// Connectivity
-	isOnline bool
 	lastPing time.Time
 
 	// Stats
@@ -119,10 +124,12 @@ type UI struct {
 	navUpload   widget.Clickable
 	navDownload widget.Clickable
 	navSettings widget.Clickable
+	navHistory  widget.Clickable
 
 	// Upload
 	selectFileBtn widget.Clickable
 	encryptCheck  widget.Bool
+	pinCheck      widget.Bool
 	uploadBtn     widget.Clickable
 
 	// Download
@@ -136,6 +143,11 @@ type UI struct {
 	settingsSaveBtn        widget.Clickable
 	settingsDownloadDirEd  widget.Editor
 
+	// History
+	historyList         widget.List
+	historyCopyBtns     []widget.Clickable
+	historyDownloadBtns []widget.Clickable
+
 	// Common
 	copyResultBtn widget.Clickable
 	copyURLBtn    widget.Clickable
@@ -147,11 +159,14 @@ type UI struct {
 }
 
 var (
-	config   *finalride.Config
-	configMu sync.Mutex // Protects config
-	appState *AppState
-	ui       *UI
-	window   *app.Window
+	config     *finalride.Config
+	configPath string          // File that settings are saved to
+	configMu   sync.Mutex      // Protects config
+	pool       *finalride.Pool // Swarm endpoints and their health
+	fetchStore finalride.Store // pool behind the chunk cache, for downloads
+	appState   *AppState
+	ui         *UI
+	window     *app.Window
 )
 
 func init() {
@@ -166,36 +181,57 @@ func init() {
 	icCheck, _ = widget.NewIcon(icons.ActionCheckCircle)
 	icFolder, _ = widget.NewIcon(icons.FileFolder)
 	icPaste, _ = widget.NewIcon(icons.ContentContentPaste)
+	icHistory, _ = widget.NewIcon(icons.ActionHistory)
 }
 
 func main() {
-	config, _ = finalride.LoadConfig("config.yaml")
-	
+	// Fall back to the built-in defaults so a broken config never stops the app.
+	// Settings are then not saved, to avoid overwriting the file that failed.
+	var configErr error
+	config, configPath, configErr = finalride.ResolveConfig(finalride.ConfigOptions{})
+	if configErr != nil {
+		config = finalride.DefaultConfig()
+	} else if configPath == "" {
+		configPath, _ = finalride.DefaultConfigPath()
+	}
+
 	// Default values if config missing
 	if config.DownloadDir == "" {
 		wd, _ := os.Getwd()
 		config.DownloadDir = wd
 	}
 
+	pool = finalride.NewConfigPool(config)
+	cache, cacheErr := finalride.NewConfigCache(config)
+	fetchStore = finalride.NewCachedStore(pool, cache)
+
 	appState = &AppState{
 		encryptFile:    config.EncryptDefault,
+		keepPinned:     config.PinUploads,
 		downloadDir:    config.DownloadDir,
 		encryptDefault: config.EncryptDefault,
 		logs:           make([]string, 0),
-		isOnline:       false,
 		isSidebarOpen:  true, // Default open
 		isDarkMode:     config.Theme == "dark",
 	}
+	if configErr != nil {
+		appState.logs = append(appState.logs, "Error loading config, using defaults: "+configErr.Error())
+	}
+	if cacheErr != nil {
+		appState.logs = append(appState.logs, "Chunk cache unavailable: "+cacheErr.Error())
+	}
 
 	ui = &UI{}
 	ui.theme = material.NewTheme()
 	
 	ui.encryptCheck.Value = appState.encryptFile
+	ui.pinCheck.Value = appState.keepPinned
 	ui.settingsEncryptCheck.Value = appState.encryptDefault
 	ui.settingsThemeSwitch.Value = appState.isDarkMode
 	ui.settingsDownloadDirEd.SetText(appState.downloadDir)
 	
 	ui.logsList.List.Axis = layout.Vertical
+	ui.historyList.List.Axis = layout.Vertical
 	ui.cidEditor.SingleLine = true
 	ui.filePathEditor.SingleLine = true
 	ui.settingsDownloadDirEd.SingleLine = true
@@ -209,6 +245,7 @@ func main() {
 		)
 
 		go startPingLoop()
+		go reloadHistory()
 
 		if err := run(window); err != nil {
 			fmt.Println("Error:", err)
@@ -221,18 +258,9 @@ func main() {
 func startPingLoop() {
 	ticker := time.NewTicker(5 * time.Second)
 	check := func() {
-		client := http.Client{Timeout: 2 * time.Second}
-		configMu.Lock()
-		apiURL := config.SwarmAPI
-		configMu.Unlock()
-		resp, err := client.Get(apiURL)
+		pool.Ping(2 * time.Second)
 
 		appState.mu.Lock()
-		if err == nil && resp.StatusCode < 500 {
-			appState.isOnline = true
-		} else {
-			appState.isOnline = false
-		}
 		appState.lastPing = time.Now()
 		appState.mu.Unlock()
 
@@ -359,7 +387,8 @@ func drawHeader(gtx layout.Context) layout.Dimensions {
 						} else {
 							config.Theme = "light"
 						}
-						finalride.SaveConfig("config.yaml", config)
+						theme := config.Theme
+						go saveSetting(func(c *finalride.Config) error { c.Theme = theme; return nil })
 						appState.mu.Unlock()
 						window.Invalidate()
 					}
@@ -406,6 +435,12 @@ func drawSidebar(gtx layout.Context) layout.Dimensions {
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 							return layout.Spacer{Height: unit.Dp(8)}.Layout(gtx)
 						}),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return drawNavButton(gtx, &ui.navHistory, "History", 3, icHistory)
+						}),
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							return layout.Spacer{Height: unit.Dp(8)}.Layout(gtx)
+						}),
 						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
 							return drawNavButton(gtx, &ui.navSettings, "Settings", 2, icSettings)
 						}),
@@ -430,7 +465,8 @@ func drawSidebar(gtx layout.Context) layout.Dimensions {
 									} else {
 										config.Theme = "light"
 									}
-									if err := finalride.SaveConfig("config.yaml", config); err != nil {
+									theme := config.Theme
+									if err := saveSetting(func(c *finalride.Config) error { c.Theme = theme; return nil }); err != nil {
 										addLog("Error saving theme: " + err.Error())
 									}
 									configMu.Unlock()
@@ -539,51 +575,75 @@ func drawNavButton(gtx layout.Context, btn *widget.Clickable, label string, inde
 }
 
 func drawStatusIndicator(gtx layout.Context) layout.Dimensions {
-	appState.mu.Lock()
-	isOnline := appState.isOnline
-	appState.mu.Unlock()
+	endpoints := pool.Status()
 
-	statusColor := CurrentTheme.Error
-	statusText := "Server Offline"
-	if isOnline {
-		statusColor = CurrentTheme.Success
-		statusText = "Server Online"
+	rows := []layout.FlexChild{
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			l := material.Caption(ui.theme, "SERVER STATUS")
+			l.Color = CurrentTheme.TextLight
+			l.Font.Weight = font.Bold
+			l.Font.Typeface = "Montserrat"
+			return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, l.Layout)
+		}),
+	}
+	for _, endpoint := range endpoints {
+		endpoint := endpoint
+		rows = append(rows, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Inset{Top: unit.Dp(2), Bottom: unit.Dp(2)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+				return drawEndpointStatus(gtx, endpoint)
+			})
+		}))
 	}
 
 	return widget.Border{Color: CurrentTheme.Border, CornerRadius: unit.Dp(4), Width: unit.Dp(1)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
 		return layout.Inset{Top: unit.Dp(8), Bottom: unit.Dp(8), Left: unit.Dp(12), Right: unit.Dp(12)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
-			return layout.Flex{Axis: layout.Vertical, Alignment: layout.Start}.Layout(gtx,
-				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-					l := material.Caption(ui.theme, "SERVER STATUS")
-					l.Color = CurrentTheme.TextLight
-					l.Font.Weight = font.Bold
-					l.Font.Typeface = "Montserrat"
-					return layout.Inset{Bottom: unit.Dp(4)}.Layout(gtx, l.Layout)
-				}),
-				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-					return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
-						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-							size := gtx.Dp(unit.Dp(8))
-							rect := image.Rectangle{Max: image.Point{X: size, Y: size}}
-							paint.FillShape(gtx.Ops, statusColor, clip.Ellipse(rect).Op(gtx.Ops))
-							return layout.Dimensions{Size: rect.Max}
-						}),
-						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-							return layout.Spacer{Width: unit.Dp(8)}.Layout(gtx)
-						}),
-						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-							l := material.Body2(ui.theme, statusText) // body2 for visibility
-							l.Color = CurrentTheme.Text
-							l.Font.Typeface = "Montserrat"
-							return l.Layout(gtx)
-						}),
-					)
-				}),
-			)
+			return layout.Flex{Axis: layout.Vertical, Alignment: layout.Start}.Layout(gtx, rows...)
 		})
 	})
 }
 
+// drawEndpointStatus draws one Swarm endpoint with its health and latency
+func drawEndpointStatus(gtx layout.Context, endpoint finalride.EndpointStatus) layout.Dimensions {
+	statusColor := CurrentTheme.TextLight
+	statusText := endpointHost(endpoint.URL) + " - checking"
+	if !endpoint.LastCheck.IsZero() {
+		if endpoint.Healthy {
+			statusColor = CurrentTheme.Success
+			statusText = fmt.Sprintf("%s - %d ms", endpointHost(endpoint.URL), endpoint.Latency.Milliseconds())
+		} else {
+			statusColor = CurrentTheme.Error
+			statusText = endpointHost(endpoint.URL) + " - offline"
+		}
+	}
+
+	return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			size := gtx.Dp(unit.Dp(8))
+			rect := image.Rectangle{Max: image.Point{X: size, Y: size}}
+			paint.FillShape(gtx.Ops, statusColor, clip.Ellipse(rect).Op(gtx.Ops))
+			return layout.Dimensions{Size: rect.Max}
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			return layout.Spacer{Width: unit.Dp(8)}.Layout(gtx)
+		}),
+		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+			l := material.Body2(ui.theme, statusText) // body2 for visibility
+			l.Color = CurrentTheme.Text
+			l.Font.Typeface = "Montserrat"
+			l.MaxLines = 1
+			return l.Layout(gtx)
+		}),
+	)
+}
+
+// endpointHost shortens an endpoint URL to its host for the status panel
+func endpointHost(endpoint string) string {
+	if u, err := url.Parse(endpoint); err == nil && u.Host != "" {
+		return u.Host
+	}
+	return endpoint
+}
+
 func drawContent(gtx layout.Context) layout.Dimensions {
 	appState.mu.Lock()
 	tab := appState.currentTab
@@ -593,6 +653,8 @@ func drawContent(gtx layout.Context) layout.Dimensions {
 		return drawUploadTab(gtx)
 	} else if tab == 1 {
 		return drawDownloadTab(gtx)
+	} else if tab == 3 {
+		return drawHistoryTab(gtx)
 	}
 	return drawSettingsTab(gtx)
 }
@@ -660,6 +722,16 @@ func drawUploadTab(gtx layout.Context) layout.Dimensions {
 						cb.Font.Typeface = "Montserrat"
 						return cb.Layout(gtx)
 					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						appState.mu.Lock()
+						appState.keepPinned = ui.pinCheck.Value
+						appState.mu.Unlock()
+						cb := material.CheckBox(ui.theme, &ui.pinCheck, "Keep pinned on the node")
+						cb.Color = CurrentTheme.Text
+						cb.IconColor = CurrentTheme.Primary
+						cb.Font.Typeface = "Montserrat"
+						return cb.Layout(gtx)
+					}),
 				)
 			})
 		}),
@@ -743,10 +815,26 @@ func drawDownloadTab(gtx layout.Context) layout.Dimensions {
 			return layout.Spacer{Height: unit.Dp(24)}.Layout(gtx)
 		}),
 		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
-			return drawPrimaryActionBtn(gtx, &ui.downloadBtn, "Download", func() {
-				cid := ui.cidEditor.Text()
-				if cid != "" {
+			return drawPreviewSection(gtx)
+		}),
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			cid := finalride.ParseReference(ui.cidEditor.Text())
+			appState.mu.Lock()
+			confirmed := appState.preview != nil && appState.previewCID == cid
+			appState.mu.Unlock()
+
+			label := "Fetch Info"
+			if confirmed {
+				label = "Download"
+			}
+			return drawPrimaryActionBtn(gtx, &ui.downloadBtn, label, func() {
+				if cid == "" {
+					return
+				}
+				if confirmed {
 					go performDownload(cid)
+				} else {
+					go performPreview(cid)
 				}
 			})
 		}),
@@ -765,6 +853,68 @@ func drawDownloadTab(gtx layout.Context) layout.Dimensions {
 	)
 }
 
+func drawPreviewSection(gtx layout.Context) layout.Dimensions {
+	appState.mu.Lock()
+	metadata := appState.preview
+	previewCID := appState.previewCID
+	appState.mu.Unlock()
+
+	if metadata == nil || previewCID != finalride.ParseReference(ui.cidEditor.Text()) {
+		return layout.Dimensions{}
+	}
+
+	size := "unknown"
+	if metadata.Size > 0 {
+		size = formatSize(metadata.Size)
+	}
+	chunking := metadata.ChunkingScheme()
+	if metadata.Chunked {
+		chunking = fmt.Sprintf("%s, %d chunks", chunking, metadata.ChunkCount())
+	}
+	rows := [][2]string{
+		{"Filename", metadata.Filename},
+		{"Size", size},
+		{"Encryption", metadata.EncryptionScheme()},
+		{"Chunking", chunking},
+		{"Schema", fmt.Sprintf("v%d", metadata.SchemaVersion())},
+	}
+
+	return layout.Inset{Top: unit.Dp(24)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+		return drawCard(gtx, func(gtx layout.Context) layout.Dimensions {
+			children := []layout.FlexChild{
+				layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					l := material.Body2(ui.theme, "File Information")
+					l.Color = CurrentTheme.Success
+					l.Font.Weight = font.Bold
+					l.Font.Typeface = "Montserrat"
+					return layout.Inset{Bottom: unit.Dp(8)}.Layout(gtx, l.Layout)
+				}),
+			}
+			for _, row := range rows {
+				row := row
+				children = append(children, layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+					return layout.Flex{Axis: layout.Horizontal}.Layout(gtx,
+						layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+							gtx.Constraints.Min.X = gtx.Dp(unit.Dp(110))
+							l := material.Body2(ui.theme, row[0])
+							l.Color = CurrentTheme.TextLight
+							l.Font.Typeface = "Montserrat"
+							return l.Layout(gtx)
+						}),
+						layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+							l := material.Body2(ui.theme, row[1])
+							l.Color = CurrentTheme.Text
+							l.Font.Typeface = "Montserrat"
+							return l.Layout(gtx)
+						}),
+					)
+				}))
+			}
+			return layout.Flex{Axis: layout.Vertical}.Layout(gtx, children...)
+		})
+	})
+}
+
 func drawSettingsTab(gtx layout.Context) layout.Dimensions {
 	// Handle Browse
 	if ui.settingsDownloadDirBtn.Clicked(gtx) {
@@ -779,7 +929,7 @@ func drawSettingsTab(gtx layout.Context) layout.Dimensions {
 				go func(newDir string) {
 					configMu.Lock()
 					config.DownloadDir = newDir
-					if err := finalride.SaveConfig("config.yaml", config); err != nil {
+					if err := saveSetting(func(c *finalride.Config) error { c.DownloadDir = newDir; return nil }); err != nil {
 						addLog("Error saving config: " + err.Error())
 					} else {
 						addLog("Directory setting updated")
@@ -842,6 +992,110 @@ func drawSettingsTab(gtx layout.Context) layout.Dimensions {
 	)
 }
 
+func drawHistoryTab(gtx layout.Context) layout.Dimensions {
+	appState.mu.Lock()
+	entries := make([]finalride.HistoryEntry, len(appState.history))
+	copy(entries, appState.history)
+	appState.mu.Unlock()
+
+	for len(ui.historyCopyBtns) < len(entries) {
+		ui.historyCopyBtns = append(ui.historyCopyBtns, widget.Clickable{})
+		ui.historyDownloadBtns = append(ui.historyDownloadBtns, widget.Clickable{})
+	}
+
+	return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+		layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+			l := material.H6(ui.theme, "History")
+			l.Color = CurrentTheme.Text
+			l.Font.Weight = font.Bold
+			l.Font.Typeface = "Montserrat"
+			return layout.Inset{Bottom: unit.Dp(20)}.Layout(gtx, l.Layout)
+		}),
+		layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+			if len(entries) == 0 {
+				l := material.Body1(ui.theme, "No uploads or downloads yet.")
+				l.Color = CurrentTheme.TextLight
+				l.Font.Typeface = "Montserrat"
+				return l.Layout(gtx)
+			}
+			return material.List(ui.theme, &ui.historyList).Layout(gtx, len(entries), func(gtx layout.Context, i int) layout.Dimensions {
+				return layout.Inset{Bottom: unit.Dp(12)}.Layout(gtx, func(gtx layout.Context) layout.Dimensions {
+					return drawHistoryEntry(gtx, entries[i], &ui.historyCopyBtns[i], &ui.historyDownloadBtns[i])
+				})
+			})
+		}),
+	)
+}
+
+func drawHistoryEntry(gtx layout.Context, entry finalride.HistoryEntry, copyBtn, downloadBtn *widget.Clickable) layout.Dimensions {
+	if copyBtn.Clicked(gtx) {
+		clipboard.WriteAll(entry.Link)
+	}
+	if downloadBtn.Clicked(gtx) {
+		appState.mu.Lock()
+		busy := appState.isProcessing
+		if !busy {
+			appState.currentTab = 1
+		}
+		appState.mu.Unlock()
+		if !busy {
+			ui.cidEditor.SetText(entry.CID)
+			go performDownload(entry.CID)
+		}
+	}
+
+	title := fmt.Sprintf("%s  %s (%s)", strings.ToUpper(entry.Action), entry.Filename, formatSize(entry.Size))
+	details := fmt.Sprintf("%s  |  Encrypted: %v  |  %s", entry.Time.Local().Format("2006-01-02 15:04"), entry.Encrypted, entry.Gateway)
+
+	return drawCard(gtx, func(gtx layout.Context) layout.Dimensions {
+		return layout.Flex{Axis: layout.Horizontal, Alignment: layout.Middle}.Layout(gtx,
+			layout.Flexed(1, func(gtx layout.Context) layout.Dimensions {
+				return layout.Flex{Axis: layout.Vertical}.Layout(gtx,
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						l := material.Body1(ui.theme, title)
+						l.Color = CurrentTheme.Text
+						l.Font.Weight = font.Bold
+						l.Font.Typeface = "Montserrat"
+						return l.Layout(gtx)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						l := material.Caption(ui.theme, entry.CID)
+						l.Color = CurrentTheme.Primary
+						l.Font.Typeface = "Montserrat"
+						return layout.Inset{Top: unit.Dp(4)}.Layout(gtx, l.Layout)
+					}),
+					layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+						l := material.Caption(ui.theme, details)
+						l.Color = CurrentTheme.TextLight
+						l.Font.Typeface = "Montserrat"
+						return layout.Inset{Top: unit.Dp(4)}.Layout(gtx, l.Layout)
+					}),
+				)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Spacer{Width: unit.Dp(16)}.Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				btn := material.Button(ui.theme, copyBtn, "Copy Link")
+				btn.Background = CurrentTheme.Surface
+				btn.Color = CurrentTheme.Primary
+				btn.Inset = layout.UniformInset(unit.Dp(10))
+				return btn.Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				return layout.Spacer{Width: unit.Dp(8)}.Layout(gtx)
+			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				btn := material.Button(ui.theme, downloadBtn, "Download")
+				btn.Background = CurrentTheme.Primary
+				btn.Color = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+				btn.Inset = layout.UniformInset(unit.Dp(10))
+				return btn.Layout(gtx)
+			}),
+		)
+	})
+}
+
 func drawTerminal(gtx layout.Context) layout.Dimensions {
 	// Frame styling
 	border := widget.Border{Color: CurrentTheme.Border, CornerRadius: unit.Dp(6), Width: unit.Dp(1)}
@@ -1006,6 +1260,7 @@ func drawProgressSection(gtx layout.Context) layout.Dimensions {
 	isProcessing := appState.isProcessing
 	status := appState.status
 	speed := appState.speed
+	syncStatus := appState.syncStatus
 	appState.mu.Unlock()
 
 	if !isProcessing && progress <= 0 {
@@ -1054,6 +1309,15 @@ func drawProgressSection(gtx layout.Context) layout.Dimensions {
 				l.Font.Typeface = "Montserrat"
 				return l.Layout(gtx)
 			}),
+			layout.Rigid(func(gtx layout.Context) layout.Dimensions {
+				if syncStatus == "" {
+					return layout.Dimensions{}
+				}
+				l := material.Caption(ui.theme, syncStatus)
+				l.Color = CurrentTheme.TextLight
+				l.Font.Typeface = "Montserrat"
+				return layout.Inset{Top: unit.Dp(4)}.Layout(gtx, l.Layout)
+			}),
 		)
 	})
 }
@@ -1121,6 +1385,15 @@ func drawPrimaryActionBtn(gtx layout.Context, btn *widget.Clickable, label strin
 }
 
 // Backend Functions (Copy/Pasted and minimally adjusted for new UI state)
+// saveSetting writes a change to the config file without persisting profile or
+// environment overrides
+func saveSetting(update func(*finalride.Config) error) error {
+	if configPath == "" {
+		return fmt.Errorf("config file could not be loaded, settings are not saved")
+	}
+	return finalride.UpdateConfigFile(configPath, update)
+}
+
 func addLog(msg string) {
 	appState.mu.Lock()
 	appState.logs = append(appState.logs, fmt.Sprintf("%s %s", time.Now().Format("15:04:05"), msg))
@@ -1130,6 +1403,40 @@ func addLog(msg string) {
 	}
 }
 
+// reloadHistory reads the history file into the app state, newest first
+func reloadHistory() {
+	historyPath, err := finalride.DefaultHistoryPath()
+	if err != nil {
+		return
+	}
+	entries, err := finalride.LoadHistory(historyPath)
+	if err != nil {
+		addLog("Error loading history: " + err.Error())
+		return
+	}
+	entries = finalride.FilterHistory(entries, finalride.HistoryFilter{})
+
+	appState.mu.Lock()
+	appState.history = entries
+	appState.mu.Unlock()
+	if window != nil {
+		window.Invalidate()
+	}
+}
+
+// recordHistory appends an entry to the history file and refreshes the History tab
+func recordHistory(entry finalride.HistoryEntry) {
+	historyPath, err := finalride.DefaultHistoryPath()
+	if err == nil {
+		err = finalride.AppendHistory(historyPath, entry)
+	}
+	if err != nil {
+		addLog("Error saving history: " + err.Error())
+		return
+	}
+	reloadHistory()
+}
+
 func updateStatus(status string) {
 	appState.mu.Lock()
 	appState.status = status
@@ -1164,9 +1471,13 @@ func performUpload(filePath string) {
 	appState.isProcessing = true
 	appState.progress = 0
 	appState.resultCID = ""
+	appState.syncStatus = ""
+	appState.syncGen++
+	syncGen := appState.syncGen
 	appState.logs = make([]string, 0)
 	appState.startTime = time.Now()
 	encrypt := appState.encryptFile
+	keepPinned := appState.keepPinned
 	appState.mu.Unlock()
 	
 	window.Invalidate()
@@ -1178,7 +1489,14 @@ func performUpload(filePath string) {
 		window.Invalidate()
 	}()
 
-	fileInfo, err := os.Stat(filePath)
+	file, err := os.Open(filePath)
+	if err != nil {
+		addLog("ERROR: " + err.Error())
+		return
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
 	if err != nil {
 		addLog("ERROR: " + err.Error())
 		return
@@ -1186,88 +1504,71 @@ func performUpload(filePath string) {
 
 	addLog(fmt.Sprintf("FILE: %s (%s)", filepath.Base(filePath), formatSize(fileInfo.Size())))
 	addLog(fmt.Sprintf("ENCRYPTION: %v", encrypt))
+	addLog(fmt.Sprintf("PINNED: %v", keepPinned))
+	pool.SetPin(keepPinned)
+	pool.TrackSync()
 
-	updateStatus("Reading file...")
-	plaintext, err := os.ReadFile(filePath)
+	configMu.Lock()
+	chunkSizeBytes := config.ChunkSizeMB * 1024 * 1024
+	postageBatch := config.PostageBatch
+	chunking, convergent := config.Chunking, config.ConvergentEncryption
+	configMu.Unlock()
+	dedupKey, err := finalride.DedupKeyFor(chunking, encrypt, convergent)
 	if err != nil {
-		addLog("ERROR reading file: " + err.Error())
+		addLog("ERROR: " + err.Error())
 		return
 	}
-	updateProgress(0.1)
-	addLog("SUCCESS: File read")
 
-	metadata := finalride.Metadata{
-		Filename:  filepath.Base(filePath),
-		Encrypted: encrypt,
+	estimate := finalride.EstimateUpload(fileInfo.Size(), chunkSizeBytes, encrypt)
+	addLog(fmt.Sprintf("STAMP SLOTS: ~%d", estimate.Chunks))
+	batches, err := pool.PrepareStamps(postageBatch, estimate.Chunks)
+	if err != nil {
+		addLog("ERROR: " + err.Error())
+		updateStatus("No postage batch with room for this file")
+		return
+	}
+	for endpoint, batch := range batches {
+		line := fmt.Sprintf("BATCH: %s on %s", batch.BatchID, endpointHost(endpoint))
+		if batch.Amount != "" {
+			line += " (~" + finalride.FormatBZZ(estimate.Cost(batch.AmountPLUR())) + ")"
+		}
+		addLog(line)
 	}
-
-	var dataToUpload []byte
 
 	if encrypt {
-		key, err := finalride.GenerateKey()
-		if err != nil {
-			addLog("ERROR generating key: " + err.Error())
-			return
-		}
-		updateStatus("Encrypting...")
-		dataToUpload, err = finalride.EncryptData(plaintext, key)
-		if err != nil {
-			addLog("ERROR Encryption failed: " + err.Error())
-			return
-		}
-		metadata.Key = base64.StdEncoding.EncodeToString(key)
-		addLog("SUCCESS: Encryption complete")
+		updateStatus("Encrypting and uploading...")
 	} else {
-		dataToUpload = plaintext
+		updateStatus("Uploading...")
 	}
-	updateProgress(0.3)
-
-	chunkSizeBytes := config.ChunkSizeMB * 1024 * 1024
-
-	if len(dataToUpload) > chunkSizeBytes {
-		updateStatus("Chunking...")
-		chunks, hashes := finalride.SplitIntoChunks(dataToUpload, chunkSizeBytes)
-		addLog(fmt.Sprintf("SUCCESS: Split into %d chunks", len(chunks)))
-		updateProgress(0.4)
-
-		updateStatus("Uploading chunks...")
-		chunkIDs := make(map[string]string)
-		totalChunks := len(chunks)
-		uploaded := 0
-
-		for k, chunk := range chunks {
-			ref, err := finalride.UploadToSwarm(chunk, config.SwarmAPI)
-			if err != nil {
-				addLog(fmt.Sprintf("ERROR upload chunk %s: %v", k, err))
-				return
+	var uploaded int64
+	metadata, err := finalride.UploadStream(file, finalride.UploadOptions{
+		Filename:  filepath.Base(filePath),
+		Encrypt:   encrypt,
+		ChunkSize: chunkSizeBytes,
+		Store:     pool,
+		Chunking:  chunking,
+		DedupKey:  dedupKey,
+		OnProgress: func(n int64) {
+			uploaded += n
+			if fileInfo.Size() > 0 {
+				updateProgress(0.9 * float32(uploaded) / float32(fileInfo.Size()))
 			}
-			chunkIDs[k] = ref
-			uploaded++
-			updateProgress(0.4 + 0.5*float32(uploaded)/float32(totalChunks))
-			updateSpeed(int64(uploaded * chunkSizeBytes))
-		}
-		metadata.Chunked = true
-		metadata.ChunkIDs = chunkIDs
-		metadata.ChunkHashes = hashes
-		addLog("SUCCESS: All chunks uploaded")
+			updateSpeed(uploaded)
+		},
+	})
+	if err != nil {
+		addLog("ERROR Upload failed: " + err.Error())
+		return
+	}
+	if metadata.Chunked {
+		addLog(fmt.Sprintf("SUCCESS: Uploaded %d chunks", len(metadata.ChunkIDs)))
 	} else {
-		updateStatus("Uploading...")
-		fileID, err := finalride.UploadToSwarm(dataToUpload, config.SwarmAPI)
-		if err != nil {
-			addLog("ERROR Upload failed: " + err.Error())
-			return
-		}
-		hash := sha256.Sum256(dataToUpload)
-		metadata.Chunked = false
-		metadata.FileID = fileID
-		metadata.FileHash = fmt.Sprintf("%x", hash)
 		addLog("SUCCESS: File uploaded")
 	}
 	updateProgress(0.9)
 
 	updateStatus("Uploading metadata...")
-	metadataJSON, _ := json.Marshal(metadata)
-	metadataCID, err := finalride.UploadToSwarm(metadataJSON, config.SwarmAPI)
+	metadataCID, err := finalride.UploadMetadata(metadata, pool)
 	if err != nil {
 		addLog("ERROR upload metadata: " + err.Error())
 		return
@@ -1282,6 +1583,101 @@ func performUpload(filePath string) {
 	appState.resultCID = metadataCID
 	appState.mu.Unlock()
 	window.Invalidate()
+	go watchSync(syncGen)
+
+	configMu.Lock()
+	gateway := config.SwarmAPI
+	shareURL := fmt.Sprintf(config.DownloadLink, metadataCID)
+	configMu.Unlock()
+	recordHistory(finalride.HistoryEntry{
+		Action:      finalride.HistoryUpload,
+		Filename:    metadata.Filename,
+		Size:        fileInfo.Size(),
+		CID:         metadataCID,
+		Encrypted:   metadata.Encrypted,
+		KeyHandling: finalride.KeyHandlingFor(metadata),
+		Gateway:     gateway,
+		Link:        shareURL,
+	})
+}
+
+// watchSync shows how much of the last upload has synced to the network, until
+// it is done or another upload starts
+func watchSync(gen int) {
+	setSync := func(text string) bool {
+		appState.mu.Lock()
+		defer appState.mu.Unlock()
+		if appState.syncGen != gen {
+			return false
+		}
+		appState.syncStatus = text
+		window.Invalidate()
+		return true
+	}
+
+	for start := time.Now(); time.Since(start) < 30*time.Minute; time.Sleep(3 * time.Second) {
+		status, err := pool.SyncStatus()
+		if errors.Is(err, finalride.ErrSyncUnsupported) {
+			setSync("Network sync: not tracked by this gateway")
+			return
+		}
+		if err != nil {
+			if !setSync("Network sync: " + err.Error()) {
+				return
+			}
+			continue
+		}
+		if status.Done() {
+			if setSync(fmt.Sprintf("Network sync: complete (%d chunks)", status.Total())) {
+				addLog("SYNC: All chunks reached the network")
+			}
+			return
+		}
+		if !setSync(fmt.Sprintf("Network sync: %d / %d chunks - links may not work for others yet", status.Synced, status.Total())) {
+			return
+		}
+	}
+}
+
+// performPreview fetches and validates the metadata so the user can confirm the download
+func performPreview(cid string) {
+	appState.mu.Lock()
+	if appState.isProcessing {
+		appState.mu.Unlock()
+		return
+	}
+	appState.isProcessing = true
+	appState.progress = 0
+	appState.preview = nil
+	appState.logs = make([]string, 0)
+	appState.mu.Unlock()
+
+	window.Invalidate()
+
+	defer func() {
+		appState.mu.Lock()
+		appState.isProcessing = false
+		appState.mu.Unlock()
+		window.Invalidate()
+	}()
+
+	addLog(fmt.Sprintf("Fetching info for CID: %s", cid))
+	updateStatus("Fetching metadata...")
+
+	metadata, err := finalride.FetchMetadata(cid, fetchStore)
+	if err != nil {
+		updateStatus("Failed")
+		addLog("ERROR metadata: " + err.Error())
+		return
+	}
+
+	appState.mu.Lock()
+	appState.preview = metadata
+	appState.previewCID = cid
+	appState.mu.Unlock()
+
+	updateStatus("Ready to download")
+	addLog(fmt.Sprintf("Info: %s (%s, %d pieces)", metadata.Filename, metadata.EncryptionScheme(), metadata.ChunkCount()))
 }
 
 func performDownload(cid string) {
@@ -1306,14 +1702,9 @@ func performDownload(cid string) {
 	}()
 
 	// URL Extraction Logic
-	if strings.Contains(cid, "download=") {
-		parts := strings.Split(cid, "download=")
-		if len(parts) > 1 {
-			// Extract CID and remove any trailing params
-			extracted := strings.Split(parts[1], "&")[0]
-			addLog(fmt.Sprintf("Extracted CID from URL: %s", extracted))
-			cid = extracted
-		}
+	if extracted := finalride.ParseReference(cid); extracted != cid {
+		addLog(fmt.Sprintf("Extracted CID from URL: %s", extracted))
+		cid = extracted
 	} else if strings.HasPrefix(cid, "http") {
 		addLog("Warning: URL detected but no 'download' parameter found.")
 	}
@@ -1321,96 +1712,63 @@ func performDownload(cid string) {
 	addLog(fmt.Sprintf("Starting Download CID: %s", cid))
 
 	updateStatus("Downloading metadata...")
-	metadataJSON, err := finalride.DownloadFromSwarm(cid, config.SwarmAPI)
+	metadata, err := finalride.FetchMetadata(cid, fetchStore)
 	if err != nil {
-		addLog("ERROR metadata download: " + err.Error())
+		addLog("ERROR metadata: " + err.Error())
 		return
 	}
 	updateProgress(0.1)
 
-	var metadata finalride.Metadata
-	if err := json.Unmarshal(metadataJSON, &metadata); err != nil {
-		addLog("ERROR parse metadata: " + err.Error())
-		return
-	}
-
 	addLog(fmt.Sprintf("Info: %s (Encrypted: %v)", metadata.Filename, metadata.Encrypted))
 
-	var downloadedData []byte
-
-	if metadata.Chunked {
-		updateStatus("Downloading chunks...")
-		addLog(fmt.Sprintf("Downloading %d chunks...", len(metadata.ChunkIDs)))
-
-		chunks := make(map[string][]byte)
-		totalChunks := len(metadata.ChunkIDs)
-		downloaded := 0
-
-		for k, ref := range metadata.ChunkIDs {
-			chunkData, err := finalride.DownloadFromSwarm(ref, config.SwarmAPI)
-			if err != nil {
-				addLog(fmt.Sprintf("ERROR download chunk %s: %v", k, err))
-				return
-			}
-			hash := sha256.Sum256(chunkData)
-			if metadata.ChunkHashes[k] != fmt.Sprintf("%x", hash) {
-				addLog(fmt.Sprintf("ERROR Integrity failed chunk %s", k))
-				return
-			}
-			chunks[k] = chunkData
-			downloaded++
-			updateProgress(0.1 + 0.6*float32(downloaded)/float32(totalChunks))
-			updateSpeed(int64(downloaded * config.ChunkSizeMB * 1024 * 1024))
-		}
-		updateStatus("Reassembling...")
-		downloadedData = finalride.ReassembleChunks(chunks)
-	} else {
-		updateStatus("Downloading file...")
-		downloadedData, err = finalride.DownloadFromSwarm(metadata.FileID, config.SwarmAPI)
-		if err != nil {
-			addLog("ERROR Download failed: " + err.Error())
-			return
-		}
-		hash := sha256.Sum256(downloadedData)
-		if metadata.FileHash != fmt.Sprintf("%x", hash) {
-			addLog("ERROR File integrity failed")
-			return
-		}
-	}
-	updateProgress(0.8)
-
-	var finalData []byte
-	if metadata.Encrypted {
-		updateStatus("Decrypting...")
-		key, err := base64.StdEncoding.DecodeString(metadata.Key)
-		if err != nil {
-			addLog("ERROR decode key: " + err.Error())
-			return
-		}
-		finalData, err = finalride.DecryptData(downloadedData, key)
-		if err != nil {
-			addLog("ERROR Decryption: " + err.Error())
-			return
-		}
-		addLog("SUCCESS: Decrypted")
-	} else {
-		finalData = downloadedData
-	}
-	updateProgress(0.9)
-
-	updateStatus("Saving file...")
 	savePath := metadata.Filename
 	if config.DownloadDir != "" {
 		savePath = filepath.Join(config.DownloadDir, metadata.Filename)
 	}
-	if err := os.WriteFile(savePath, finalData, 0644); err != nil {
+	file, err := os.Create(savePath)
+	if err != nil {
 		addLog("ERROR Save file: " + err.Error())
 		return
 	}
 
+	updateStatus("Downloading...")
+	addLog(fmt.Sprintf("Downloading %d pieces...", metadata.ChunkCount()))
+	totalPieces := metadata.ChunkCount()
+	downloaded := 0
+	var downloadedBytes int64
+	written, err := finalride.DownloadStream(metadata, fetchStore, file, func(n int64) {
+		downloaded++
+		downloadedBytes += n
+		updateProgress(0.1 + 0.85*float32(downloaded)/float32(totalPieces))
+		updateSpeed(downloadedBytes)
+	})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(savePath)
+		addLog("ERROR Download failed: " + err.Error())
+		return
+	}
+
 	updateProgress(1.0)
 	updateStatus("Complete!")
-	addLog(fmt.Sprintf("SUCCESS: Saved %s (%s)", savePath, formatSize(int64(len(finalData)))))
+	addLog(fmt.Sprintf("SUCCESS: Saved %s (%s)", savePath, formatSize(written)))
+
+	configMu.Lock()
+	gateway := config.SwarmAPI
+	shareURL := fmt.Sprintf(config.DownloadLink, cid)
+	configMu.Unlock()
+	recordHistory(finalride.HistoryEntry{
+		Action:      finalride.HistoryDownload,
+		Filename:    metadata.Filename,
+		Size:        written,
+		CID:         cid,
+		Encrypted:   metadata.Encrypted,
+		KeyHandling: finalride.KeyHandlingFor(metadata),
+		Gateway:     gateway,
+		Link:        shareURL,
+	})
 }
 
 func formatSpeed(bytesPerSec float64) string {
@@ -0,0 +1,9 @@
+// Package web embeds the browser frontend so the CLI can serve it
+package web
+
+import "embed"
+
+// Files holds index.html and any assets next to it
+//
+//go:embed index.html
+var Files embed.FS
@@ -4,21 +4,23 @@ go 1.25.3
 
 require (
 	gioui.org v0.9.0
+	github.com/atotto/clipboard v0.1.4
+	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.4.0
 	github.com/schollz/progressbar/v3 v3.19.0
+	github.com/sqweek/dialog v0.0.0-20240226140203-065105509627
+	golang.org/x/crypto v0.46.0
+	golang.org/x/exp/shiny v0.0.0-20251219203646-944ab1f22d93
+	golang.org/x/sys v0.39.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
 	gioui.org/shader v1.0.8 // indirect
 	github.com/TheTitanrain/w32 v0.0.0-20180517000239-4f5cfb03fabf // indirect
-	github.com/atotto/clipboard v0.1.4 // indirect
 	github.com/go-text/typesetting v0.3.0 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
-	github.com/sqweek/dialog v0.0.0-20240226140203-065105509627 // indirect
-	golang.org/x/exp/shiny v0.0.0-20251219203646-944ab1f22d93 // indirect
 	golang.org/x/image v0.34.0 // indirect
-	golang.org/x/sys v0.39.0 // indirect
-	golang.org/x/term v0.28.0 // indirect
+	golang.org/x/term v0.38.0 // indirect
 	golang.org/x/text v0.32.0 // indirect
 )
@@ -0,0 +1,391 @@
+package finalride
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// cacheHeader is the SHA-256 of the content stored in front of every entry
+const cacheHeader = sha256.Size
+
+// Cache is an on-disk store of downloaded data keyed by Swarm reference. Every
+// entry carries the SHA-256 of its content, which is checked again on each read;
+// entries that fail are dropped. Once the cache grows past its quota, the least
+// recently used entries are evicted.
+type Cache struct {
+	dir   string
+	quota int64 // Bytes on disk, 0 for no limit
+
+	mu   sync.Mutex
+	size int64 // Bytes on disk, -1 until the directory has been scanned
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+// CacheStats describes the contents of a Cache
+type CacheStats struct {
+	Dir     string
+	Entries int
+	Bytes   int64
+	Quota   int64
+	Oldest  time.Time // Least recent use, zero if empty
+	Newest  time.Time // Most recent use, zero if empty
+}
+
+// PruneResult reports what Prune removed
+type PruneResult struct {
+	Corrupt int   // Entries that failed their check
+	Expired int   // Entries unused for longer than the age limit
+	Evicted int   // Entries removed to get under the quota
+	Freed   int64 // Bytes removed
+}
+
+// cacheEntry is one file in the cache directory
+type cacheEntry struct {
+	path string
+	size int64
+	used time.Time
+}
+
+// DefaultCacheDir returns the per-user chunk cache location
+func DefaultCacheDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user cache dir: %v", err)
+	}
+	return filepath.Join(dir, "final-ride", "chunks"), nil
+}
+
+// OpenCache opens or creates a cache in dir limited to quota bytes
+func OpenCache(dir string, quota int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir: %v", err)
+	}
+	return &Cache{dir: dir, quota: quota, size: -1}, nil
+}
+
+// NewConfigCache opens the cache described by the configuration, or returns
+// nil if cache_size_mb disables it
+func NewConfigCache(config *Config) (*Cache, error) {
+	if config.CacheSizeMB <= 0 {
+		return nil, nil
+	}
+	dir := config.CacheDir
+	if dir == "" {
+		var err error
+		if dir, err = DefaultCacheDir(); err != nil {
+			return nil, err
+		}
+	}
+	return OpenCache(dir, int64(config.CacheSizeMB)*1024*1024)
+}
+
+// Dir returns the cache directory
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+// Counts returns the hits and misses of Get since the cache was opened
+func (c *Cache) Counts() (hits, misses int64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// path returns the file of a reference, or "" if it is not a valid reference
+func (c *Cache) path(reference string) string {
+	if !referencePattern.MatchString(reference) {
+		return ""
+	}
+	return filepath.Join(c.dir, reference[:2], reference)
+}
+
+// Get returns the cached data of a reference
+func (c *Cache) Get(reference string) ([]byte, bool) {
+	path := c.path(reference)
+	if path == "" {
+		return nil, false
+	}
+	entry, err := os.ReadFile(path)
+	if err != nil {
+		c.misses.Add(1)
+		return nil, false
+	}
+	data, ok := verifyEntry(entry)
+	if !ok {
+		c.remove(path, int64(len(entry)))
+		c.misses.Add(1)
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	c.hits.Add(1)
+	return data, true
+}
+
+// Put stores the data of a reference and evicts old entries over the quota
+func (c *Cache) Put(reference string, data []byte) error {
+	path := c.path(reference)
+	if path == "" {
+		return fmt.Errorf("invalid reference %q", reference)
+	}
+	if c.quota > 0 && int64(len(data)+cacheHeader) > c.quota {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create cache dir: %v", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial entry
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	_, err = tmp.Write(append(sum[:], data...))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write cache entry: %v", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size >= 0 {
+		c.size += int64(len(data) + cacheHeader)
+	}
+	if c.quota > 0 {
+		if c.size < 0 || c.size > c.quota {
+			_, _, err = c.evictLocked(c.quota)
+		}
+	}
+	return err
+}
+
+// Stats scans the cache
+func (c *Cache) Stats() (CacheStats, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return CacheStats{}, err
+	}
+	stats := CacheStats{Dir: c.dir, Entries: len(entries), Quota: c.quota}
+	for _, e := range entries {
+		stats.Bytes += e.size
+		if stats.Oldest.IsZero() || e.used.Before(stats.Oldest) {
+			stats.Oldest = e.used
+		}
+		if e.used.After(stats.Newest) {
+			stats.Newest = e.used
+		}
+	}
+	return stats, nil
+}
+
+// Clear removes every entry and returns the number of bytes freed
+func (c *Cache) Clear() (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.entries()
+	if err != nil {
+		return 0, err
+	}
+	var freed int64
+	for _, e := range entries {
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return freed, fmt.Errorf("failed to clear cache: %v", err)
+		}
+		freed += e.size
+	}
+	c.size = 0
+	return freed, nil
+}
+
+// Prune checks every entry, removes those that are corrupt or unused for longer
+// than maxAge (0 for no age limit), then evicts down to the quota
+func (c *Cache) Prune(maxAge time.Duration) (PruneResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.entries()
+	if err != nil {
+		return PruneResult{}, err
+	}
+	var result PruneResult
+	for _, e := range entries {
+		expired := maxAge > 0 && time.Since(e.used) > maxAge
+		corrupt := false
+		if !expired {
+			data, err := os.ReadFile(e.path)
+			_, ok := verifyEntry(data)
+			corrupt = err != nil || !ok
+		}
+		if !expired && !corrupt {
+			continue
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return result, fmt.Errorf("failed to prune cache: %v", err)
+		}
+		result.Freed += e.size
+		if expired {
+			result.Expired++
+		} else {
+			result.Corrupt++
+		}
+	}
+
+	c.size = -1
+	if c.quota > 0 {
+		evicted, freed, err := c.evictLocked(c.quota)
+		result.Evicted, result.Freed = evicted, result.Freed+freed
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// evictLocked removes the least recently used entries until the cache holds at
+// most limit bytes. The caller holds c.mu.
+func (c *Cache) evictLocked(limit int64) (int, int64, error) {
+	entries, err := c.entries()
+	if err != nil {
+		return 0, 0, err
+	}
+	c.size = 0
+	for _, e := range entries {
+		c.size += e.size
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].used.Before(entries[j].used) })
+
+	var evicted int
+	var freed int64
+	for _, e := range entries {
+		if c.size <= limit {
+			break
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return evicted, freed, fmt.Errorf("failed to evict cache entry: %v", err)
+		}
+		c.size -= e.size
+		freed += e.size
+		evicted++
+	}
+	return evicted, freed, nil
+}
+
+// remove drops a corrupt entry
+func (c *Cache) remove(path string, size int64) {
+	if os.Remove(path) != nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size >= 0 {
+		c.size -= size
+	}
+}
+
+// entries lists the cache files with their size and last use
+func (c *Cache) entries() ([]cacheEntry, error) {
+	var entries []cacheEntry
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !referencePattern.MatchString(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil // Removed while scanning
+		}
+		entries = append(entries, cacheEntry{path: path, size: info.Size(), used: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan cache: %v", err)
+	}
+	return entries, nil
+}
+
+// verifyEntry checks an entry against its header and returns its content
+func verifyEntry(entry []byte) ([]byte, bool) {
+	if len(entry) < cacheHeader {
+		return nil, false
+	}
+	sum := sha256.Sum256(entry[cacheHeader:])
+	return entry[cacheHeader:], bytes.Equal(sum[:], entry[:cacheHeader])
+}
+
+// CachedStore is a Store whose verified piece downloads are served from a
+// Cache when possible and added to it otherwise. Plain downloads, uploads and
+// checks go to the store, since references such as feed manifests can resolve
+// to new content over time.
+type CachedStore struct {
+	Store
+	Cache *Cache
+}
+
+// NewCachedStore wraps store with cache, or returns store if cache is nil
+func NewCachedStore(store Store, cache *Cache) Store {
+	if cache == nil {
+		return store
+	}
+	return &CachedStore{Store: store, Cache: cache}
+}
+
+// ResolveFeed asks the wrapped store whether a reference is a feed manifest
+func (s *CachedStore) ResolveFeed(reference string) (string, bool, error) {
+	return resolveFeed(s.Store, reference)
+}
+
+// DownloadBytes reads raw data through the wrapped store, without caching it
+func (s *CachedStore) DownloadBytes(reference string) ([]byte, error) {
+	r, err := rawDownloader(s.Store)
+	if err != nil {
+		return nil, err
+	}
+	return r.DownloadBytes(reference)
+}
+
+// DownloadChunk reads a single chunk through the wrapped store
+func (s *CachedStore) DownloadChunk(reference string) ([]byte, error) {
+	r, err := rawDownloader(s.Store)
+	if err != nil {
+		return nil, err
+	}
+	return r.DownloadChunk(reference)
+}
+
+// DownloadVerified retrieves a piece and checks it against its hash. Only
+// content matching the hash is cached, and a cached copy that does not match
+// is replaced.
+func (s *CachedStore) DownloadVerified(reference, hash string) ([]byte, error) {
+	if data, ok := s.Cache.Get(reference); ok {
+		if checkHash(data, hash) == nil {
+			return data, nil
+		}
+	}
+	data, err := downloadPiece(s.Store, reference, hash)
+	if err != nil {
+		return nil, err
+	}
+	s.Cache.Put(reference, data)
+	return data, nil
+}
@@ -2,17 +2,24 @@ package finalride
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"math/bits"
 	"sort"
 	"strconv"
 )
 
-// SplitIntoChunks splits data into chunks
+// SplitIntoChunks splits data into chunks. A chunk size below 1 yields a single chunk.
 func SplitIntoChunks(data []byte, chunkSize int) (map[string][]byte, map[string]string) {
 	chunks := make(map[string][]byte)
 	hashes := make(map[string]string)
 	chunkNum := 1
 
+	if chunkSize <= 0 {
+		chunkSize = len(data)
+	}
+
 	for i := 0; i < len(data); i += chunkSize {
 		end := i + chunkSize
 		if end > len(data) {
@@ -45,3 +52,112 @@ func ReassembleChunks(chunks map[string][]byte) []byte {
 	}
 	return result
 }
+
+// gearTable holds the random values the content-defined chunker mixes in for
+// each byte. It is derived from a fixed seed, so chunk boundaries are the same
+// on every client.
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("final-ride gear %d", i)))
+		table[i] = binary.BigEndian.Uint64(sum[:8])
+	}
+	return table
+}()
+
+// ContentChunker cuts a stream into chunks at boundaries chosen by the content
+// (FastCDC), so inserting or removing bytes only changes the chunks around the
+// edit. Chunks average the requested size and are between a quarter and four
+// times that size; only the last chunk may be shorter.
+type ContentChunker struct {
+	r        io.Reader
+	buf      []byte
+	start    int // Unconsumed data is buf[start:end]
+	end      int
+	eof      bool
+	min, avg int
+	maskS    uint64 // Harder to match, used before the average size
+	maskL    uint64 // Easier to match, used after it
+}
+
+// NewContentChunker returns a chunker over r producing chunks of about avg bytes
+func NewContentChunker(r io.Reader, avg int) *ContentChunker {
+	avg = max(avg, 64)
+	// Normalized chunking: two bits more than the average before it, two fewer after
+	n := bits.Len(uint(avg)) - 1
+	mask := func(ones int) uint64 { return ^uint64(0) << (64 - ones) }
+	return &ContentChunker{
+		r:     r,
+		buf:   make([]byte, 4*avg),
+		min:   avg / 4,
+		avg:   avg,
+		maskS: mask(n + 2),
+		maskL: mask(n - 2),
+	}
+}
+
+// Next returns the next chunk, valid until the following call, or io.EOF after
+// the last one
+func (c *ContentChunker) Next() ([]byte, error) {
+	if err := c.fill(); err != nil {
+		return nil, err
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// More reports whether another chunk follows
+func (c *ContentChunker) More() (bool, error) {
+	if err := c.fill(); err != nil {
+		return false, err
+	}
+	return c.start < c.end, nil
+}
+
+// fill moves unconsumed data to the front and reads until the buffer is full
+// or the input ends
+func (c *ContentChunker) fill() error {
+	if c.eof || c.end-c.start == len(c.buf) {
+		return nil
+	}
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	n, err := io.ReadFull(c.r, c.buf[c.end:])
+	c.end += n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		c.eof = true
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read input: %v", err)
+	}
+	return nil
+}
+
+// cut returns the length of the chunk at the start of data
+func (c *ContentChunker) cut(data []byte) int {
+	if len(data) <= c.min {
+		return len(data)
+	}
+	normal := min(c.avg, len(data))
+	var hash uint64
+	i := c.min
+	for ; i < normal; i++ {
+		hash = hash<<1 + gearTable[data[i]]
+		if hash&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < len(data); i++ {
+		hash = hash<<1 + gearTable[data[i]]
+		if hash&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
@@ -0,0 +1,278 @@
+package finalride
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// CollectionType marks a Directory read from a Bee /bzz collection manifest,
+// such as a tar upload, rather than a manifest written by Sync
+const CollectionType = "collection"
+
+// errNotCollection is returned by FetchCollection for references that are not
+// Bee manifests
+var errNotCollection = fmt.Errorf("%w: not a Bee collection manifest", ErrInvalidMetadata)
+
+// Mantaray, the trie Bee stores collection manifests in. Each node is raw data
+// holding an obfuscation key, the format version, the node's entry and one
+// fork per next path byte, each pointing to the node below it.
+const (
+	mantarayKeySize     = 32 // Obfuscation key the rest of the node is XORed with
+	mantarayVersionSize = 31
+	mantarayForkSize    = 32 // Fork type, prefix length and prefix, before the reference
+	mantarayValue       = 2  // Fork type flag: the node below has an entry
+	mantarayMetadata    = 16 // Fork type flag: JSON metadata follows the reference
+	maxCollectionPath   = 4096
+)
+
+// mantarayVersion identifies version 0.2 nodes, the only ones Bee writes
+var mantarayVersion = keccak256([]byte("mantaray:0.2"))[:mantarayVersionSize]
+
+// mantarayNode is a decoded manifest node
+type mantarayNode struct {
+	entry []byte // Content reference, or zeros for a node without one
+	forks []mantarayFork
+}
+
+type mantarayFork struct {
+	prefix    string
+	flags     byte
+	reference []byte
+}
+
+// FetchCollection reads a Bee /bzz collection manifest and lists its files,
+// each with the reference of its content and its size. Bee records no modes,
+// times or SHA-256 hashes, so the files are checked against their Swarm
+// reference instead. The store must be a RawDownloader.
+func FetchCollection(reference string, store Store) (*Directory, error) {
+	raw, err := rawDownloader(store)
+	if err != nil {
+		return nil, err
+	}
+	root, err := fetchMantaray(raw, reference)
+	if err != nil {
+		return nil, err
+	}
+	d := &Directory{Type: CollectionType, Files: []DirEntry{}}
+	if err := walkCollection(raw, root, "", d); err != nil {
+		return nil, err
+	}
+	sortEntries(d.Files)
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// FetchShare downloads what a reference points to. Sync manifests and Bee
+// collections are returned as a Directory; anything else is returned as data
+// for ParseMetadata.
+func FetchShare(reference string, store Store) (*Directory, []byte, error) {
+	data, err := store.Download(reference)
+	if err == nil {
+		if IsDirectory(data) {
+			d, err := ParseDirectory(data)
+			return d, nil, err
+		}
+		if _, parseErr := ParseMetadata(data); parseErr == nil {
+			return nil, data, nil
+		}
+	}
+
+	// Bee serves a collection's index document, or nothing, under /bzz
+	d, collectionErr := FetchCollection(reference, store)
+	switch {
+	case collectionErr == nil:
+		return d, nil, nil
+	case err != nil:
+		return nil, nil, err
+	case errors.Is(collectionErr, errNotCollection), errors.Is(collectionErr, errRawUnknown):
+		return nil, data, nil
+	}
+	return nil, nil, collectionErr
+}
+
+// walkCollection adds the files below a node, whose path is prefix
+func walkCollection(raw RawDownloader, node *mantarayNode, prefix string, d *Directory) error {
+	for _, f := range node.forks {
+		path := prefix + f.prefix
+		if len(path) > maxCollectionPath {
+			return fmt.Errorf("%w: collection path too long", ErrInvalidMetadata)
+		}
+		child, err := fetchMantaray(raw, hex.EncodeToString(f.reference))
+		if errors.Is(err, errNotCollection) {
+			return fmt.Errorf("%w: corrupt collection node below %q", ErrInvalidMetadata, path)
+		}
+		if err != nil {
+			return err
+		}
+		// The root document settings are kept under "/", without content
+		if f.flags&mantarayValue != 0 && !isZero(child.entry) && path != "/" {
+			entry := DirEntry{Path: path, Reference: hex.EncodeToString(child.entry)}
+			if entry.Size, err = collectionFileSize(raw, child.entry); err != nil {
+				return fmt.Errorf("failed to get the size of %s: %w", path, err)
+			}
+			d.Files = append(d.Files, entry)
+		}
+		if err := walkCollection(raw, child, path, d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectionFileSize reads the size of a file from the span of its root chunk.
+// Encrypted chunks hide it, so their size is reported as zero.
+func collectionFileSize(raw RawDownloader, reference []byte) (int64, error) {
+	if len(reference) != 32 {
+		return 0, nil
+	}
+	chunk, err := raw.DownloadChunk(hex.EncodeToString(reference))
+	if err != nil {
+		return 0, err
+	}
+	return int64(binary.LittleEndian.Uint64(chunk[:spanSize])), nil
+}
+
+// fetchMantaray downloads and decodes a manifest node
+func fetchMantaray(raw RawDownloader, reference string) (*mantarayNode, error) {
+	data, err := raw.DownloadBytes(reference)
+	if err != nil {
+		return nil, err
+	}
+	return parseMantaray(data)
+}
+
+// parseMantaray decodes a version 0.2 manifest node
+func parseMantaray(data []byte) (*mantarayNode, error) {
+	const headerSize = mantarayKeySize + mantarayVersionSize + 1
+	if len(data) < headerSize {
+		return nil, errNotCollection
+	}
+	plain := append([]byte(nil), data...)
+	for i := mantarayKeySize; i < len(plain); i++ {
+		plain[i] ^= data[i%mantarayKeySize]
+	}
+	if !bytes.Equal(plain[mantarayKeySize:headerSize-1], mantarayVersion) {
+		return nil, errNotCollection
+	}
+
+	corrupt := fmt.Errorf("%w: truncated collection manifest node", ErrInvalidMetadata)
+	refSize := int(plain[headerSize-1])
+	rest := plain[headerSize:]
+	if refSize != 0 && refSize != 32 && refSize != 64 || len(rest) < refSize+32 {
+		return nil, corrupt
+	}
+	node := &mantarayNode{entry: rest[:refSize]}
+	index := rest[refSize : refSize+32]
+	rest = rest[refSize+32:]
+	for b := 0; b < 256; b++ {
+		if index[b/8]&(1<<(b%8)) == 0 {
+			continue
+		}
+		if len(rest) < mantarayForkSize+refSize {
+			return nil, corrupt
+		}
+		prefixSize := int(rest[1])
+		if prefixSize == 0 || prefixSize > mantarayForkSize-2 {
+			return nil, corrupt
+		}
+		fork := mantarayFork{flags: rest[0], prefix: string(rest[2 : 2+prefixSize]), reference: rest[mantarayForkSize : mantarayForkSize+refSize]}
+		rest = rest[mantarayForkSize+refSize:]
+		if fork.flags&mantarayMetadata != 0 {
+			// Metadata such as the content type is not needed to list files
+			if len(rest) < 2 || len(rest) < 2+int(binary.BigEndian.Uint16(rest)) {
+				return nil, corrupt
+			}
+			rest = rest[2+int(binary.BigEndian.Uint16(rest)):]
+		}
+		if len(fork.reference) == 0 || fork.prefix[0] != byte(b) {
+			return nil, corrupt
+		}
+		node.forks = append(node.forks, fork)
+	}
+	return node, nil
+}
+
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// swarmAddress returns the Swarm reference of unencrypted data uploaded through
+// /bytes: the root of a tree of 4 KB chunks, each addressed by bmtHash. As in
+// Bee, a single reference left over at the end of a level is carried up
+// rather than wrapped in a chunk of its own.
+func swarmAddress(data []byte) []byte {
+	type ref struct {
+		address []byte
+		span    uint64
+	}
+	var level []ref
+	for offset := 0; ; offset += SwarmChunkSize {
+		payload := data[offset:min(offset+SwarmChunkSize, len(data))]
+		level = append(level, ref{chunkAddress(uint64(len(payload)), payload), uint64(len(payload))})
+		if offset+SwarmChunkSize >= len(data) {
+			break
+		}
+	}
+
+	const branches = SwarmChunkSize / 32
+	for len(level) > 1 {
+		var next []ref
+		for i := 0; i < len(level); i += branches {
+			group := level[i:min(i+branches, len(level))]
+			if len(group) == 1 {
+				next = append(next, group[0])
+				continue
+			}
+			var payload []byte
+			var span uint64
+			for _, r := range group {
+				payload = append(payload, r.address...)
+				span += r.span
+			}
+			next = append(next, ref{chunkAddress(span, payload), span})
+		}
+		level = next
+	}
+	return level[0].address
+}
+
+// chunkAddress returns the address of a content-addressed chunk
+func chunkAddress(span uint64, payload []byte) []byte {
+	chunk := make([]byte, spanSize, spanSize+len(payload))
+	binary.LittleEndian.PutUint64(chunk, span)
+	return bmtHash(append(chunk, payload...))
+}
+
+// checkAddress checks raw data against its Swarm reference. Encrypted
+// references cannot be recomputed from the plain data, so Bee, which decrypts
+// them, is trusted with their chunks.
+func checkAddress(data []byte, reference string) error {
+	if len(reference) != 64 {
+		return nil
+	}
+	if got := hex.EncodeToString(swarmAddress(data)); got != reference {
+		return fmt.Errorf("%w: Swarm address mismatch: got %s, want %s", ErrIntegrity, got, reference)
+	}
+	return nil
+}
+
+// checkChunk checks a chunk, span first, against its address
+func checkChunk(chunk []byte, reference string) error {
+	if len(chunk) < spanSize || len(chunk) > spanSize+SwarmChunkSize {
+		return fmt.Errorf("%w: invalid chunk of %d bytes", ErrIntegrity, len(chunk))
+	}
+	if got := hex.EncodeToString(bmtHash(chunk)); got != reference {
+		return fmt.Errorf("%w: chunk address mismatch: got %s, want %s", ErrIntegrity, got, reference)
+	}
+	return nil
+}
@@ -0,0 +1,402 @@
+package finalride
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Environment variables that control configuration lookup
+const (
+	ConfigPathEnv = "FINAL_RIDE_CONFIG"  // Path to the config file
+	ProfileEnv    = "FINAL_RIDE_PROFILE" // Profile applied when none is given explicitly
+	envPrefix     = "FINAL_RIDE_"        // Prefix of per-field overrides, e.g. FINAL_RIDE_SWARM_API
+)
+
+// ConfigOptions selects how the effective configuration is resolved
+type ConfigOptions struct {
+	Path    string // Explicit config file (empty to search)
+	Profile string // Profile to apply (empty falls back to FINAL_RIDE_PROFILE)
+}
+
+// PublicGateway is the default swarm_api, a gateway that stamps uploads itself
+// and does not pin them
+const PublicGateway = "https://api.gateway.ethswarm.org"
+
+// DefaultConfig returns the built-in configuration used when no file exists
+func DefaultConfig() *Config {
+	return &Config{
+		SwarmAPI:         PublicGateway,
+		DownloadStrategy: StrategyHedge,
+		HedgeDelayMS:     500,
+		WebURL:           "https://final-ride.ethswarm.org",
+		DownloadLink:     "https://final-ride.ethswarm.org/index.html?download=%s",
+		ChunkSizeMB:      10,
+		Chunking:         ChunkingFixed,
+		Theme:            "dark",
+		BrandName:        "Final Ride",
+		EncryptDefault:   true,
+		PinUploads:       true,
+		PostageBatch:     "",
+		CacheSizeMB:      1024,
+	}
+}
+
+// IsPublicGateway reports whether apiEndpoint is a public Swarm gateway rather
+// than a Bee node of the user's own
+func IsPublicGateway(apiEndpoint string) bool {
+	u, err := url.Parse(apiEndpoint)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == "gateway.ethswarm.org" || strings.HasSuffix(host, ".gateway.ethswarm.org")
+}
+
+// DefaultConfigPath returns the per-user config file location
+func DefaultConfigPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.yaml"), nil
+}
+
+// FindConfig returns the config file to use. An explicit path or FINAL_RIDE_CONFIG
+// must exist; otherwise the user config dir and then the working directory are
+// searched. An empty path means no file was found.
+func FindConfig(explicit string) (string, error) {
+	for _, path := range []string{explicit, os.Getenv(ConfigPathEnv)} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("failed to read config file: %v", err)
+		}
+		return path, nil
+	}
+
+	var candidates []string
+	if path, err := DefaultConfigPath(); err == nil {
+		candidates = append(candidates, path)
+	}
+	candidates = append(candidates, "config.yaml")
+
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+	return "", nil
+}
+
+// ResolveConfig builds the effective configuration: built-in defaults, then the
+// config file, then the selected profile, then FINAL_RIDE_* environment
+// variables. It also returns the config file used, which is empty if none exists.
+func ResolveConfig(opts ConfigOptions) (*Config, string, error) {
+	path, err := FindConfig(opts.Path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	config := DefaultConfig()
+	if path != "" {
+		if err := readConfigInto(path, config); err != nil {
+			return nil, "", err
+		}
+	}
+
+	profile := opts.Profile
+	if profile == "" {
+		profile = os.Getenv(ProfileEnv)
+	}
+	if profile != "" {
+		if err := config.ApplyProfile(profile); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := config.ApplyEnv(); err != nil {
+		return nil, "", err
+	}
+	if err := config.Validate(); err != nil {
+		return nil, path, err
+	}
+	return config, path, nil
+}
+
+// Validate checks that every setting is usable before a transfer starts
+func (c *Config) Validate() error {
+	if err := validateURL("swarm_api", c.SwarmAPI); err != nil {
+		return err
+	}
+	for _, endpoint := range c.Endpoints {
+		if err := validateURL("endpoints entry", endpoint); err != nil {
+			return err
+		}
+	}
+	switch c.DownloadStrategy {
+	case StrategyFailover, StrategyHedge, StrategyRace:
+	default:
+		return fmt.Errorf("invalid download_strategy %q: must be \"failover\", \"hedge\" or \"race\"", c.DownloadStrategy)
+	}
+	if c.HedgeDelayMS < 0 {
+		return fmt.Errorf("invalid hedge_delay_ms %d: must not be negative", c.HedgeDelayMS)
+	}
+	if c.PostageBatch != "" && c.PostageBatch != BatchAuto && !ValidBatchID(c.PostageBatch) {
+		return fmt.Errorf("invalid postage_batch %q: must be \"auto\", empty or a 64-character hex batch ID", c.PostageBatch)
+	}
+	if c.WebURL != "" {
+		if err := validateURL("web_url", c.WebURL); err != nil {
+			return err
+		}
+	}
+	if strings.Count(c.DownloadLink, "%s") != 1 || strings.Contains(fmt.Sprintf(c.DownloadLink, "cid"), "%!") {
+		return fmt.Errorf("invalid download_link %q: must contain exactly one %%s where the CID goes", c.DownloadLink)
+	}
+	if c.ChunkSizeMB <= 0 {
+		return fmt.Errorf("invalid chunk_size_mb %d: must be at least 1", c.ChunkSizeMB)
+	}
+	if c.Chunking != "" && c.Chunking != ChunkingFixed && c.Chunking != ChunkingContentDefined {
+		return fmt.Errorf("invalid chunking %q: must be %q or %q", c.Chunking, ChunkingFixed, ChunkingContentDefined)
+	}
+	if c.CacheSizeMB < 0 {
+		return fmt.Errorf("invalid cache_size_mb %d: must not be negative (0 disables the cache)", c.CacheSizeMB)
+	}
+	if c.Theme != "light" && c.Theme != "dark" {
+		return fmt.Errorf("invalid theme %q: must be \"light\" or \"dark\"", c.Theme)
+	}
+	if c.DownloadDir != "" {
+		info, err := os.Stat(c.DownloadDir)
+		if err != nil {
+			return fmt.Errorf("invalid download_dir: %v", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("invalid download_dir %q: not a directory", c.DownloadDir)
+		}
+	}
+	return nil
+}
+
+// APIEndpoints returns swarm_api followed by the fallback endpoints, without
+// duplicates
+func (c *Config) APIEndpoints() []string {
+	seen := make(map[string]bool)
+	var endpoints []string
+	for _, endpoint := range append([]string{c.SwarmAPI}, c.Endpoints...) {
+		endpoint = strings.TrimRight(endpoint, "/")
+		if endpoint == "" || seen[endpoint] {
+			continue
+		}
+		seen[endpoint] = true
+		endpoints = append(endpoints, endpoint)
+	}
+	return endpoints
+}
+
+// ValidateProfiles checks every profile as it would be applied on top of c
+func (c *Config) ValidateProfiles() error {
+	for _, name := range c.ProfileNames() {
+		profile := *c
+		if err := profile.ApplyProfile(name); err != nil {
+			return err
+		}
+		if err := profile.Validate(); err != nil {
+			return fmt.Errorf("profile %q: %v", name, err)
+		}
+	}
+	return nil
+}
+
+func validateURL(key, value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %v", key, value, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid %s %q: must be an http:// or https:// URL", key, value)
+	}
+	return nil
+}
+
+// readConfigInto decodes a config file over existing values, so keys missing
+// from the file keep their defaults
+func readConfigInto(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+	return nil
+}
+
+// ProfileNames returns the names of the profiles defined in the config
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyProfile overlays the settings of a named profile
+func (c *Config) ApplyProfile(name string) error {
+	node, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(c.ProfileNames(), ", "))
+	}
+
+	var overlay Config
+	if err := node.Decode(&overlay); err != nil {
+		return fmt.Errorf("failed to parse profile %q: %v", name, err)
+	}
+	if len(overlay.Profiles) > 0 {
+		return fmt.Errorf("profile %q must not define profiles", name)
+	}
+	if err := node.Decode(c); err != nil {
+		return fmt.Errorf("failed to parse profile %q: %v", name, err)
+	}
+	c.Profile = name
+	return nil
+}
+
+// ApplyEnv overrides fields from FINAL_RIDE_<YAML KEY> environment variables,
+// for example FINAL_RIDE_SWARM_API or FINAL_RIDE_CHUNK_SIZE_MB
+func (c *Config) ApplyEnv() error {
+	for _, key := range ConfigKeys() {
+		value, ok := os.LookupEnv(EnvName(key))
+		if !ok {
+			continue
+		}
+		if err := c.Set(key, value); err != nil {
+			return fmt.Errorf("invalid %s: %v", EnvName(key), err)
+		}
+	}
+	return nil
+}
+
+// ConfigKeys returns the YAML keys of all settings that can be set from a string
+func ConfigKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := configKey(t.Field(i)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// Get returns a setting by its YAML key
+func (c *Config) Get(key string) (string, error) {
+	field, err := c.field(key)
+	if err != nil {
+		return "", err
+	}
+	if list, ok := field.Interface().([]string); ok {
+		return strings.Join(list, ","), nil
+	}
+	return fmt.Sprint(field.Interface()), nil
+}
+
+// Set changes a setting by its YAML key, parsing the value for its type
+func (c *Config) Set(key, value string) error {
+	field, err := c.field(key)
+	if err != nil {
+		return err
+	}
+	return setConfigField(field, value)
+}
+
+func (c *Config) field(key string) (reflect.Value, error) {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if configKey(t.Field(i)) == key {
+			return v.Field(i), nil
+		}
+	}
+	return reflect.Value{}, fmt.Errorf("unknown setting %q (valid: %s)", key, strings.Join(ConfigKeys(), ", "))
+}
+
+// EnvName returns the environment variable overriding a config key
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(key)
+}
+
+// configKey returns the YAML key of a scalar or string list config field, or ""
+// for fields that cannot be set from a single string. Lists are comma-separated.
+func configKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if key == "" || key == "-" {
+		return ""
+	}
+	switch field.Type.Kind() {
+	case reflect.String, reflect.Int, reflect.Bool:
+		return key
+	case reflect.Slice:
+		if field.Type.Elem().Kind() == reflect.String {
+			return key
+		}
+	}
+	return ""
+}
+
+func setConfigField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Kind())
+	}
+	return nil
+}
+
+// UpdateConfigFile changes settings in a config file without writing profile or
+// environment overrides into it. The file is created if it does not exist yet,
+// and is left untouched if the update fails or the result does not validate.
+func UpdateConfigFile(path string, update func(*Config) error) error {
+	config := DefaultConfig()
+	if _, err := os.Stat(path); err == nil {
+		if err := readConfigInto(path, config); err != nil {
+			return err
+		}
+	}
+	if err := update(config); err != nil {
+		return err
+	}
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	return SaveConfig(path, config)
+}
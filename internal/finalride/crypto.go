@@ -3,9 +3,15 @@ package finalride
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 )
 
 // GenerateKey generates a random 32-byte AES-256 key
@@ -59,3 +65,84 @@ func DecryptData(encryptedData []byte, key []byte) ([]byte, error) {
 
 	return aesgcm.Open(nil, nonce, ciphertext, nil)
 }
+
+// DeriveChunkKey returns the key a content-defined chunk is encrypted with: the
+// SHA-256 of the chunk for convergent encryption (secret is nil), or its
+// HMAC-SHA256 under secret, which only matches uploads made with the same secret
+func DeriveChunkKey(plaintext []byte, secret []byte) []byte {
+	if secret == nil {
+		sum := sha256.Sum256(plaintext)
+		return sum[:]
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(plaintext)
+	return mac.Sum(nil)
+}
+
+// EncryptDeterministic encrypts data with AES-GCM under a key derived from the
+// data itself, so the same plaintext always gives the same ciphertext. Since the
+// key never encrypts anything else, the nonce is fixed at zero. The result has
+// the layout of EncryptData and is decrypted with DecryptData.
+func EncryptDeterministic(plaintext []byte, key []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, 12)
+	return aesgcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// DefaultDedupKeyPath returns the location of the secret that keyed
+// deduplication derives chunk keys from
+func DefaultDedupKeyPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "dedup.key"), nil
+}
+
+// LoadDedupKey reads a hex-encoded deduplication secret, creating a new one if
+// the file does not exist
+func LoadDedupKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := GenerateKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate dedup key: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create dedup key dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write dedup key: %v", err)
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dedup key: %v", err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("invalid dedup key in %s: expected a 32-byte hex key", path)
+	}
+	return key, nil
+}
+
+// DedupKeyFor returns the DedupKey of an upload: the one in the user config dir
+// for encrypted, content-defined uploads that are not convergent, nil otherwise
+func DedupKeyFor(chunking string, encrypt bool, convergent bool) ([]byte, error) {
+	if chunking != ChunkingContentDefined || !encrypt || convergent {
+		return nil, nil
+	}
+	path, err := DefaultDedupKeyPath()
+	if err != nil {
+		return nil, err
+	}
+	return LoadDedupKey(path)
+}
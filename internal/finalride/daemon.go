@@ -0,0 +1,442 @@
+package finalride
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upload job states reported by the daemon
+const (
+	JobUploading = "uploading"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// jobRetention is how long finished jobs stay queryable
+const jobRetention = 24 * time.Hour
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// DaemonOptions configures a Daemon
+type DaemonOptions struct {
+	Token       string // Bearer token every /v1 request must carry
+	MaxUpload   int64  // Largest upload accepted in bytes, 0 for no limit
+	SpoolDir    string // Where uploads wait for the transfer, empty for the system temp dir
+	HistoryPath string // History file finished uploads are recorded in, empty for none
+}
+
+// UploadJob is the status of an upload handed to the daemon
+type UploadJob struct {
+	ID        string     `json:"id"`
+	State     string     `json:"state"`
+	Filename  string     `json:"filename"`
+	Encrypted bool       `json:"encrypted"`
+	Size      int64      `json:"size"`     // Input size in bytes
+	Uploaded  int64      `json:"uploaded"` // Input bytes stored so far
+	CID       string     `json:"cid,omitempty"`
+	Link      string     `json:"link,omitempty"`
+	Error     string     `json:"error,omitempty"`
+	Created   time.Time  `json:"created"`
+	Finished  *time.Time `json:"finished,omitempty"`
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Daemon is the REST API for programmatic uploads and downloads. Uploads are
+// spooled to disk and transferred in the background as jobs; downloads stream
+// the decrypted file.
+type Daemon struct {
+	config *Config
+	opts   DaemonOptions
+	cache  *Cache // Nil if disabled
+	mux    *http.ServeMux
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+// job is an UploadJob with its live progress counter
+type job struct {
+	UploadJob
+	uploaded atomic.Int64
+}
+
+// NewDaemon returns the REST API handler
+func NewDaemon(config *Config, opts DaemonOptions) *Daemon {
+	d := &Daemon{config: config, opts: opts, mux: http.NewServeMux(), jobs: make(map[string]*job)}
+	d.cache, _ = NewConfigCache(config) // Without it downloads are only slower
+	d.mux.HandleFunc("GET /v1/openapi.json", d.handleOpenAPI)
+	d.mux.HandleFunc("POST /v1/uploads", d.authorized(d.handleCreateUpload))
+	d.mux.HandleFunc("GET /v1/uploads", d.authorized(d.handleListUploads))
+	d.mux.HandleFunc("GET /v1/uploads/{id}", d.authorized(d.handleGetUpload))
+	d.mux.HandleFunc("DELETE /v1/uploads/{id}", d.authorized(d.handleCancelUpload))
+	d.mux.HandleFunc("GET /v1/files/{cid}", d.authorized(d.handleDownload))
+	return d
+}
+
+func (d *Daemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mux.ServeHTTP(w, r)
+}
+
+// authorized rejects requests without the daemon's bearer token
+func (d *Daemon) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || d.opts.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(d.opts.Token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="final-ride"`)
+			apiError(w, http.StatusUnauthorized, "missing or invalid API token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (d *Daemon) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// handleCreateUpload spools the request body, or the first file of a multipart
+// form, and starts the upload job. With ?wait=true it answers once the job has
+// finished.
+func (d *Daemon) handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	encrypt := d.config.EncryptDefault
+	if value := query.Get("encrypt"); value != "" {
+		var err error
+		if encrypt, err = strconv.ParseBool(value); err != nil {
+			apiError(w, http.StatusBadRequest, "invalid encrypt %q", value)
+			return
+		}
+	}
+	if d.opts.MaxUpload > 0 {
+		if r.ContentLength > d.opts.MaxUpload {
+			apiError(w, http.StatusRequestEntityTooLarge, "upload of %d bytes exceeds the limit of %d bytes", r.ContentLength, d.opts.MaxUpload)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, d.opts.MaxUpload)
+	}
+
+	body, name, err := uploadBody(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if value := query.Get("name"); value != "" {
+		name = value
+	}
+	if name == "" {
+		apiError(w, http.StatusBadRequest, "missing filename: use ?name= or a multipart file")
+		return
+	}
+
+	spool, err := os.CreateTemp(d.opts.SpoolDir, "final-ride-upload-*")
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "failed to spool upload: %v", err)
+		return
+	}
+	size, err := io.Copy(spool, body)
+	if err != nil {
+		spool.Close()
+		os.Remove(spool.Name())
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			apiError(w, http.StatusRequestEntityTooLarge, "upload exceeds the limit of %d bytes", d.opts.MaxUpload)
+			return
+		}
+		apiError(w, http.StatusBadRequest, "failed to read upload: %v", err)
+		return
+	}
+
+	j := d.startUpload(spool, name, size, encrypt)
+	if wait, _ := strconv.ParseBool(query.Get("wait")); wait {
+		select {
+		case <-j.done:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	status := d.snapshot(j)
+	w.Header().Set("Location", "/v1/uploads/"+status.ID)
+	code := http.StatusAccepted
+	if status.State == JobDone {
+		code = http.StatusCreated
+	}
+	writeJSON(w, code, status)
+}
+
+// uploadBody returns the file in a request and its name, if the request has one
+func uploadBody(r *http.Request) (io.Reader, string, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, "", nil
+	}
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, "", errors.New("multipart upload has no file")
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if part.FileName() != "" {
+			return part, part.FileName(), nil
+		}
+	}
+}
+
+// startUpload transfers a spooled file in the background and removes it after
+func (d *Daemon) startUpload(spool *os.File, name string, size int64, encrypt bool) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{UploadJob: UploadJob{
+		ID:        newJobID(),
+		State:     JobUploading,
+		Filename:  name,
+		Encrypted: encrypt,
+		Size:      size,
+		Created:   time.Now().UTC(),
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}}
+
+	d.mu.Lock()
+	d.pruneJobs()
+	d.jobs[j.ID] = j
+	d.mu.Unlock()
+
+	go func() {
+		defer close(j.done)
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+
+		cid, err := d.upload(ctx, j, spool)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		finished := time.Now().UTC()
+		j.Finished = &finished
+		switch {
+		case err != nil && ctx.Err() != nil:
+			j.State = JobCancelled
+		case err != nil:
+			j.State, j.Error = JobFailed, err.Error()
+		default:
+			j.State, j.CID, j.Link = JobDone, cid, fmt.Sprintf(d.config.DownloadLink, cid)
+		}
+	}()
+	return j
+}
+
+// upload runs one job on its own pool, so that jobs' postage batches and
+// endpoint health do not interfere
+func (d *Daemon) upload(ctx context.Context, j *job, spool *os.File) (string, error) {
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	chunkSize := d.config.ChunkSizeMB * 1024 * 1024
+	pool := NewConfigPool(d.config)
+	if _, err := pool.PrepareStamps(d.config.PostageBatch, EstimateUpload(j.Size, chunkSize, j.Encrypted).Chunks); err != nil {
+		return "", fmt.Errorf("no postage batch for this upload: %v", err)
+	}
+	dedupKey, err := DedupKeyFor(d.config.Chunking, j.Encrypted, d.config.ConvergentEncryption)
+	if err != nil {
+		return "", err
+	}
+
+	metadata, err := UploadStream(&contextReader{ctx: ctx, r: spool}, UploadOptions{
+		Filename:   j.Filename,
+		Encrypt:    j.Encrypted,
+		ChunkSize:  chunkSize,
+		Store:      pool,
+		Chunking:   d.config.Chunking,
+		DedupKey:   dedupKey,
+		OnProgress: func(n int64) { j.uploaded.Add(n) },
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	cid, err := UploadMetadata(metadata, pool)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload metadata: %v", err)
+	}
+
+	if d.opts.HistoryPath != "" {
+		AppendHistory(d.opts.HistoryPath, HistoryEntry{
+			Action:      HistoryUpload,
+			Filename:    metadata.Filename,
+			Size:        metadata.Size,
+			CID:         cid,
+			Encrypted:   metadata.Encrypted,
+			KeyHandling: KeyHandlingFor(metadata),
+			Gateway:     d.config.SwarmAPI,
+			Link:        fmt.Sprintf(d.config.DownloadLink, cid),
+		})
+	}
+	return cid, nil
+}
+
+// pruneJobs forgets jobs that finished long ago. The caller holds d.mu.
+func (d *Daemon) pruneJobs() {
+	for id, j := range d.jobs {
+		if j.Finished != nil && time.Since(*j.Finished) > jobRetention {
+			delete(d.jobs, id)
+		}
+	}
+}
+
+// snapshot returns a copy of a job's status that is safe to encode
+func (d *Daemon) snapshot(j *job) UploadJob {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	status := j.UploadJob
+	status.Uploaded = j.uploaded.Load()
+	return status
+}
+
+func (d *Daemon) lookup(w http.ResponseWriter, r *http.Request) *job {
+	d.mu.Lock()
+	j, ok := d.jobs[r.PathValue("id")]
+	d.mu.Unlock()
+	if !ok {
+		apiError(w, http.StatusNotFound, "upload %s not found", r.PathValue("id"))
+		return nil
+	}
+	return j
+}
+
+func (d *Daemon) handleListUploads(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	jobs := make([]*job, 0, len(d.jobs))
+	for _, j := range d.jobs {
+		jobs = append(jobs, j)
+	}
+	d.mu.Unlock()
+
+	list := make([]UploadJob, 0, len(jobs))
+	for _, j := range jobs {
+		list = append(list, d.snapshot(j))
+	}
+	sort.Slice(list, func(i, k int) bool { return list[i].Created.Before(list[k].Created) })
+	writeJSON(w, http.StatusOK, map[string][]UploadJob{"uploads": list})
+}
+
+func (d *Daemon) handleGetUpload(w http.ResponseWriter, r *http.Request) {
+	if j := d.lookup(w, r); j != nil {
+		writeJSON(w, http.StatusOK, d.snapshot(j))
+	}
+}
+
+// handleCancelUpload stops a running job and waits for it to wind down
+func (d *Daemon) handleCancelUpload(w http.ResponseWriter, r *http.Request) {
+	j := d.lookup(w, r)
+	if j == nil {
+		return
+	}
+	if status := d.snapshot(j); status.State != JobUploading {
+		apiError(w, http.StatusConflict, "upload %s is already %s", status.ID, status.State)
+		return
+	}
+	j.cancel()
+	select {
+	case <-j.done:
+	case <-r.Context().Done():
+		return
+	}
+	writeJSON(w, http.StatusOK, d.snapshot(j))
+}
+
+// handleDownload streams the decrypted file of a share
+func (d *Daemon) handleDownload(w http.ResponseWriter, r *http.Request) {
+	store := NewCachedStore(NewConfigPool(d.config), d.cache)
+	metadata, err := FetchMetadata(ParseReference(r.PathValue("cid")), store)
+	if err != nil {
+		apiError(w, http.StatusBadGateway, "%v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": metadata.Filename}))
+	if metadata.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(metadata.Size, 10))
+	}
+	sent := &countingWriter{w: w}
+	if _, err := DownloadStream(metadata, store, sent, nil); err != nil {
+		if sent.n == 0 {
+			w.Header().Del("Content-Length")
+			w.Header().Del("Content-Disposition")
+			apiError(w, downloadStatus(err), "%v", err)
+			return
+		}
+		// Headers are gone; cut the response short so the client sees the failure
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// downloadStatus maps a transfer error to an HTTP status
+func downloadStatus(err error) int {
+	if errors.Is(err, ErrIntegrity) || errors.Is(err, ErrDecryption) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
+
+// apiError reports an error as {"error": "..."}
+func apiError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]string{"error": fmt.Sprintf(format, args...)})
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return fmt.Sprintf("%x", b)
+}
+
+// contextReader fails reads once its context is done, which stops a transfer
+// at the next piece
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *contextReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
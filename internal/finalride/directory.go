@@ -0,0 +1,210 @@
+package finalride
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DirectoryType marks a metadata document as a directory manifest
+const DirectoryType = "directory"
+
+// DirEntry is one file of a directory, as recorded in manifests and sync state
+type DirEntry struct {
+	Path      string      `json:"path"` // Slash-separated, relative to the directory
+	Size      int64       `json:"size"`
+	Mode      fs.FileMode `json:"mode"` // Permission bits
+	ModTime   time.Time   `json:"mtime"`
+	Hash      string      `json:"sha256"` // SHA-256 of the file content
+	Reference string      `json:"cid"`    // Metadata reference of the file's share, or content reference in a collection
+}
+
+// Directory is a manifest listing the shares of the files in a directory, as
+// written by Sync, or the files of a Bee /bzz collection read by
+// FetchCollection
+type Directory struct {
+	Type     string     `json:"type"` // DirectoryType, or CollectionType for a Bee collection
+	Name     string     `json:"name"`
+	Created  time.Time  `json:"created"`
+	Previous string     `json:"previous,omitempty"` // Manifest this one replaces, if any
+	Files    []DirEntry `json:"files"`              // Sorted by path
+}
+
+// ParseDirectory parses and validates a directory manifest
+func ParseDirectory(data []byte) (*Directory, error) {
+	var d Directory
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	if err := d.Validate(); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// FetchDirectory downloads, parses and validates a directory manifest
+func FetchDirectory(reference string, store Store) (*Directory, error) {
+	data, err := store.Download(reference)
+	if err != nil {
+		return nil, err
+	}
+	return ParseDirectory(data)
+}
+
+// IsDirectory reports whether a metadata document is a directory manifest
+// written by Sync
+func IsDirectory(data []byte) bool {
+	var doc struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(data, &doc) == nil && doc.Type == DirectoryType
+}
+
+// UploadDirectory stores a directory manifest and returns its reference
+func UploadDirectory(d *Directory, store Store) (string, error) {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to create directory manifest JSON: %v", err)
+	}
+	return store.Upload(data)
+}
+
+// Validate checks that every path is relative and stays inside the directory,
+// so that the manifest is safe to restore
+func (d *Directory) Validate() error {
+	if d.Type != DirectoryType && d.Type != CollectionType {
+		return fmt.Errorf("%w: not a directory manifest", ErrInvalidMetadata)
+	}
+	seen := make(map[string]bool, len(d.Files))
+	for _, f := range d.Files {
+		if !validEntryPath(f.Path) {
+			return fmt.Errorf("%w: unsafe path %q", ErrInvalidMetadata, f.Path)
+		}
+		if seen[f.Path] {
+			return fmt.Errorf("%w: duplicate path %q", ErrInvalidMetadata, f.Path)
+		}
+		seen[f.Path] = true
+		if f.Reference == "" {
+			return fmt.Errorf("%w: missing reference for %s", ErrInvalidMetadata, f.Path)
+		}
+		if f.Size < 0 {
+			return fmt.Errorf("%w: negative size for %s", ErrInvalidMetadata, f.Path)
+		}
+	}
+	return nil
+}
+
+// Size returns the total size of the files
+func (d *Directory) Size() int64 {
+	var total int64
+	for _, f := range d.Files {
+		total += f.Size
+	}
+	return total
+}
+
+// validEntryPath accepts clean, relative, slash-separated paths without
+// backslashes or parent references
+func validEntryPath(p string) bool {
+	return p != "" && p != "." && path.Clean(p) == p && !path.IsAbs(p) && !strings.ContainsRune(p, '\\') &&
+		p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// sortEntries orders entries by path
+func sortEntries(entries []DirEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+}
+
+// PathFilter selects files by slash-separated path. A pattern without a slash
+// matches any file or directory name along the path, e.g. "*.tmp" or
+// "node_modules"; one with a slash matches the path from the top, or a
+// directory above it, e.g. "docs/*.pdf", "build/cache" or "/notes.txt".
+// Patterns use path.Match syntax, plus "**" for any number of directories,
+// e.g. "logs/**/*.txt".
+type PathFilter struct {
+	Include []string // If not empty, only paths matching one of these pass
+	Exclude []string // Paths matching any of these never pass
+}
+
+// Validate checks the syntax of every pattern
+func (f PathFilter) Validate() error {
+	for _, pattern := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether a file passes the filter
+func (f PathFilter) Match(p string) bool {
+	if f.Excluded(p) {
+		return false
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, pattern := range f.Include {
+		if matchPath(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Excluded reports whether a path, or a directory above it, is excluded
+func (f PathFilter) Excluded(p string) bool {
+	for _, pattern := range f.Exclude {
+		if matchPath(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath matches a pattern against a path as described for PathFilter
+func matchPath(pattern string, p string) bool {
+	anchored := strings.HasPrefix(pattern, "/")
+	pattern = strings.Trim(pattern, "/")
+	if !anchored && !strings.Contains(pattern, "/") {
+		for _, name := range strings.Split(p, "/") {
+			if ok, _ := path.Match(pattern, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+	// Any leading part of the path may match, so a directory covers its files
+	parts, segments := strings.Split(pattern, "/"), strings.Split(p, "/")
+	for n := len(segments); n > 0; n-- {
+		if matchSegments(parts, segments[:n]) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchSegments matches pattern segments against path segments, letting "**"
+// stand for zero or more of them
+func matchSegments(parts []string, segments []string) bool {
+	if len(parts) == 0 {
+		return len(segments) == 0
+	}
+	if parts[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(parts[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	ok, _ := path.Match(parts[0], segments[0])
+	return ok && matchSegments(parts[1:], segments[1:])
+}
@@ -0,0 +1,368 @@
+package finalride
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4"
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+// Feed errors
+var (
+	// ErrNoFeedUpdate is returned when a feed has no update at an index, or none at all
+	ErrNoFeedUpdate = errors.New("feed update not found")
+
+	// ErrFeedSignature is returned when a feed update is not signed by the feed
+	// owner; it wraps ErrIntegrity
+	ErrFeedSignature = fmt.Errorf("%w: feed update not signed by the owner", ErrIntegrity)
+)
+
+const (
+	socHeaderSize = 32 + 65 // Identifier and signature in front of a single-owner chunk
+	spanSize      = 8
+)
+
+// Identity is the secp256k1 key that signs feed updates. Its Ethereum-style
+// address is the feed owner.
+type Identity struct {
+	key *secp256k1.PrivateKey
+}
+
+// FeedUpdate is one version of a sequence feed
+type FeedUpdate struct {
+	Index     uint64
+	Time      time.Time // When the update was published
+	Reference string    // Metadata CID the feed pointed to
+}
+
+// DefaultIdentityPath returns the location of the feed signing key
+func DefaultIdentityPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "identity.key"), nil
+}
+
+// LoadIdentity reads a hex-encoded private key, creating a new one if the file
+// does not exist
+func LoadIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		key, err := secp256k1.GeneratePrivateKey()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate identity: %v", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create identity dir: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(hex.EncodeToString(key.Serialize())+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write identity: %v", err)
+		}
+		return &Identity{key: key}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity: %v", err)
+	}
+
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("invalid identity in %s: expected a 32-byte hex key", path)
+	}
+	return &Identity{key: secp256k1.PrivKeyFromBytes(raw)}, nil
+}
+
+// Owner returns the feed owner address of the identity
+func (id *Identity) Owner() string {
+	return hex.EncodeToString(ownerAddress(id.key.PubKey()))
+}
+
+// sign signs a digest the way Bee does: with the Ethereum message prefix and
+// the recovery byte last
+func (id *Identity) sign(digest []byte) []byte {
+	sig := ecdsa.SignCompact(id.key, ethereumHash(digest), false)
+	return append(sig[1:], sig[0])
+}
+
+// FeedTopic derives the topic of a named feed
+func FeedTopic(name string) []byte {
+	return keccak256([]byte(name))
+}
+
+// ParseOwner validates a feed owner address, with or without 0x
+func ParseOwner(owner string) (string, error) {
+	owner = strings.ToLower(strings.TrimPrefix(owner, "0x"))
+	if raw, err := hex.DecodeString(owner); err != nil || len(raw) != 20 {
+		return "", fmt.Errorf("invalid feed owner %q: must be a 20-byte hex address", owner)
+	}
+	return owner, nil
+}
+
+// PublishFeed points a sequence feed at a new reference by uploading the next
+// update as a single-owner chunk, stamped with batch if not empty. It returns
+// the published update.
+func PublishFeed(id *Identity, topic []byte, reference string, batch string, apiEndpoint string) (*FeedUpdate, error) {
+	ref, err := hex.DecodeString(reference)
+	if err != nil || len(ref) != 32 {
+		return nil, fmt.Errorf("invalid reference %q: feeds can only point to 32-byte references", reference)
+	}
+
+	index := uint64(0)
+	latest, err := LatestFeedUpdate(id.Owner(), topic, apiEndpoint)
+	switch {
+	case err == nil:
+		index = latest.Index + 1
+	case !errors.Is(err, ErrNoFeedUpdate):
+		return nil, err
+	}
+
+	update := &FeedUpdate{Index: index, Time: time.Unix(time.Now().Unix(), 0), Reference: reference}
+	payload := make([]byte, 8, 8+len(ref))
+	binary.BigEndian.PutUint64(payload, uint64(update.Time.Unix()))
+	payload = append(payload, ref...)
+
+	data := make([]byte, spanSize, spanSize+len(payload))
+	binary.LittleEndian.PutUint64(data, uint64(len(payload)))
+	data = append(data, payload...)
+
+	identifier := feedIdentifier(topic, index)
+	signature := id.sign(keccak256(identifier, bmtHash(data)))
+
+	endpoint := fmt.Sprintf("%s/soc/%s/%x?sig=%x", apiEndpoint, id.Owner(), identifier, signature)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if batch != "" {
+		req.Header.Set("Swarm-Postage-Batch-Id", batch)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to publish feed update: %s - %s", resp.Status, string(body))
+	}
+	return update, nil
+}
+
+// CreateFeedManifest creates the manifest that resolves a feed to its latest
+// reference under /bzz and returns its reference. The manifest only depends on
+// the owner and topic, so the link stays the same across updates.
+func CreateFeedManifest(owner string, topic []byte, batch string, apiEndpoint string) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/feeds/%s/%x?type=sequence", apiEndpoint, owner, topic), nil)
+	if err != nil {
+		return "", err
+	}
+	if batch != "" {
+		req.Header.Set("Swarm-Postage-Batch-Id", batch)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("failed to create feed manifest: %s - %s", resp.Status, string(body))
+	}
+
+	var response struct {
+		Reference string `json:"reference"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", err
+	}
+	return response.Reference, nil
+}
+
+// GetFeedUpdate fetches and verifies one update of a sequence feed
+func GetFeedUpdate(owner string, topic []byte, index uint64, apiEndpoint string) (*FeedUpdate, error) {
+	ownerBytes, err := hex.DecodeString(owner)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed owner %q", owner)
+	}
+	identifier := feedIdentifier(topic, index)
+	address := keccak256(identifier, ownerBytes)
+
+	resp, err := http.Get(fmt.Sprintf("%s/chunks/%x", apiEndpoint, address))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: index %d not found", ErrNoFeedUpdate, index)
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to fetch feed update: %s - %s", resp.Status, string(body))
+	}
+	chunk, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := verifySOC(chunk, identifier, ownerBytes)
+	if err != nil {
+		return nil, fmt.Errorf("feed update %d: %w", index, err)
+	}
+	if len(payload) != 8+32 {
+		return nil, fmt.Errorf("feed update %d: unexpected payload of %d bytes", index, len(payload))
+	}
+	return &FeedUpdate{
+		Index:     index,
+		Time:      time.Unix(int64(binary.BigEndian.Uint64(payload)), 0),
+		Reference: hex.EncodeToString(payload[8:]),
+	}, nil
+}
+
+// LatestFeedUpdate finds the newest update of a sequence feed. Updates are
+// numbered without gaps, so the end is found by doubling the index and then
+// bisecting.
+func LatestFeedUpdate(owner string, topic []byte, apiEndpoint string) (*FeedUpdate, error) {
+	latest, err := GetFeedUpdate(owner, topic, 0, apiEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Invariant: lo exists, hi does not
+	lo, hi := uint64(0), uint64(1)
+	for {
+		update, err := GetFeedUpdate(owner, topic, hi, apiEndpoint)
+		if errors.Is(err, ErrNoFeedUpdate) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		lo, hi, latest = hi, hi*2, update
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		update, err := GetFeedUpdate(owner, topic, mid, apiEndpoint)
+		switch {
+		case errors.Is(err, ErrNoFeedUpdate):
+			hi = mid
+		case err != nil:
+			return nil, err
+		default:
+			lo, latest = mid, update
+		}
+	}
+	return latest, nil
+}
+
+// FeedHistory returns every update of a sequence feed, oldest first
+func FeedHistory(owner string, topic []byte, apiEndpoint string) ([]FeedUpdate, error) {
+	var updates []FeedUpdate
+	for index := uint64(0); ; index++ {
+		update, err := GetFeedUpdate(owner, topic, index, apiEndpoint)
+		if errors.Is(err, ErrNoFeedUpdate) {
+			return updates, nil
+		}
+		if err != nil {
+			return updates, err
+		}
+		updates = append(updates, *update)
+	}
+}
+
+// verifySOC checks that a single-owner chunk has the expected identifier and is
+// signed by owner, and returns the payload of the chunk it wraps
+func verifySOC(chunk []byte, identifier []byte, owner []byte) ([]byte, error) {
+	if len(chunk) < socHeaderSize+spanSize {
+		return nil, fmt.Errorf("%w: chunk too short", ErrIntegrity)
+	}
+	if !bytes.Equal(chunk[:32], identifier) {
+		return nil, fmt.Errorf("%w: unexpected identifier", ErrIntegrity)
+	}
+
+	signature, data := chunk[32:socHeaderSize], chunk[socHeaderSize:]
+	compact := append([]byte{signature[64]}, signature[:64]...)
+	key, _, err := ecdsa.RecoverCompact(compact, ethereumHash(keccak256(identifier, bmtHash(data))))
+	if err != nil || !bytes.Equal(ownerAddress(key), owner) {
+		return nil, ErrFeedSignature
+	}
+	return data[spanSize:], nil
+}
+
+// feedIdentifier derives the single-owner chunk identifier of a feed index
+func feedIdentifier(topic []byte, index uint64) []byte {
+	var i [8]byte
+	binary.BigEndian.PutUint64(i[:], index)
+	return keccak256(topic, i[:])
+}
+
+// bmtHash returns the Swarm address of a content-addressed chunk: the span
+// followed by a binary Merkle tree over the zero-padded 4 KB payload
+func bmtHash(data []byte) []byte {
+	tree := make([]byte, SwarmChunkSize)
+	copy(tree, data[spanSize:])
+	for len(tree) > 32 {
+		for i := 0; i < len(tree)/64; i++ {
+			copy(tree[i*32:], keccak256(tree[i*64:i*64+64]))
+		}
+		tree = tree[:len(tree)/2]
+	}
+	return keccak256(data[:spanSize], tree)
+}
+
+func ownerAddress(key *secp256k1.PublicKey) []byte {
+	return keccak256(key.SerializeUncompressed()[1:])[12:]
+}
+
+func ethereumHash(digest []byte) []byte {
+	return keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d", len(digest))), digest)
+}
+
+func keccak256(parts ...[]byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	for _, p := range parts {
+		h.Write(p)
+	}
+	return h.Sum(nil)
+}
+
+// FeedIndexHeader is set by Bee on /bzz responses resolved through a feed
+const FeedIndexHeader = "Swarm-Feed-Index"
+
+// ResolveFeedManifest reports whether a reference is a feed manifest and, if
+// so, which content it currently resolves to under /bzz. The resolved
+// reference is Bee's ETag for the content, or the feed index if it sends none.
+func ResolveFeedManifest(reference string, apiEndpoint string) (string, bool, error) {
+	resp, err := http.Head(fmt.Sprintf("%s/bzz/%s", apiEndpoint, reference))
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", false, &httpError{resp.StatusCode, fmt.Sprintf("reference not available: %s", resp.Status)}
+	}
+	index := resp.Header.Get(FeedIndexHeader)
+	if index == "" {
+		return reference, false, nil
+	}
+	if resolved := strings.Trim(resp.Header.Get("ETag"), `"`); resolved != "" && resolved != reference {
+		return resolved, true, nil
+	}
+	return reference + "@" + index, true, nil
+}
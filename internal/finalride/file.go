@@ -0,0 +1,205 @@
+package finalride
+
+import (
+	"container/list"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// DefaultFileCache is the number of decrypted chunks a File keeps
+const DefaultFileCache = 4
+
+// ErrNotAddressable is returned for files whose byte offsets cannot be read on
+// their own, such as chunked files encrypted as a whole
+var ErrNotAddressable = errors.New("file does not support random access")
+
+// File reads a stored file at arbitrary offsets, downloading and verifying only
+// the pieces that cover them. It implements io.ReaderAt, io.ReadSeeker and
+// io.Closer; ReadAt is safe for concurrent use.
+type File struct {
+	metadata *Metadata
+	store    Store
+	key      []byte
+	pieces   []Piece
+	offsets  []int64 // Start of each piece, then the file size
+
+	mu     sync.Mutex
+	offset int64 // Position for Read and Seek
+	limit  int
+	order  *list.List              // Cached piece indexes, most recently used first
+	cache  map[int64]*list.Element // Piece index -> element holding a filePiece
+}
+
+type filePiece struct {
+	index int64
+	data  []byte
+}
+
+// OpenFile fetches the metadata of a share and opens its file
+func OpenFile(reference string, store Store) (*File, error) {
+	metadata, err := FetchMetadata(reference, store)
+	if err != nil {
+		return nil, err
+	}
+	return NewFile(metadata, store)
+}
+
+// NewFile opens the file described by metadata for random access
+func NewFile(metadata *Metadata, store Store) (*File, error) {
+	if !metadata.Addressable() {
+		return nil, fmt.Errorf("%w: %s", ErrNotAddressable, metadata.EncryptionScheme())
+	}
+	f := &File{
+		metadata: metadata,
+		store:    store,
+		pieces:   metadata.Pieces(),
+		offsets:  metadata.ChunkOffsets(),
+		limit:    DefaultFileCache,
+		order:    list.New(),
+		cache:    make(map[int64]*list.Element),
+	}
+	if f.offsets[len(f.pieces)] != metadata.Size {
+		return nil, fmt.Errorf("%w: %d chunks cannot hold %d bytes", ErrInvalidMetadata, len(f.pieces), metadata.Size)
+	}
+	if metadata.Encrypted {
+		key, err := base64.StdEncoding.DecodeString(metadata.Key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to decode encryption key: %v", ErrDecryption, err)
+		}
+		f.key = key
+	}
+	return f, nil
+}
+
+// Size returns the length of the file in bytes
+func (f *File) Size() int64 {
+	return f.metadata.Size
+}
+
+// Metadata returns the metadata the file was opened with
+func (f *File) Metadata() *Metadata {
+	return f.metadata
+}
+
+// SetCacheSize sets how many decrypted pieces are kept, at least one
+func (f *File) SetCacheSize(pieces int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.limit = max(pieces, 1)
+	f.evict()
+}
+
+// ReadAt reads len(p) bytes from off, returning io.EOF if the file ends first
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= f.metadata.Size {
+			return n, io.EOF
+		}
+		index := int64(sort.Search(len(f.pieces), func(i int) bool { return f.offsets[i+1] > pos }))
+		data, err := f.piece(index)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos-f.offsets[index]:])
+	}
+	return n, nil
+}
+
+// Read reads from the current position and advances it
+func (f *File) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	off := f.offset
+	f.mu.Unlock()
+
+	n, err := f.ReadAt(p, off)
+	if n > 0 && err == io.EOF {
+		err = nil
+	}
+
+	f.mu.Lock()
+	f.offset = off + int64(n)
+	f.mu.Unlock()
+	return n, err
+}
+
+// Seek sets the position for the next Read
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.metadata.Size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Close drops the cached pieces
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.order.Init()
+	clear(f.cache)
+	return nil
+}
+
+// piece returns a decrypted piece from the cache, downloading it on a miss.
+// Concurrent misses of the same piece may download it twice.
+func (f *File) piece(index int64) ([]byte, error) {
+	f.mu.Lock()
+	if e, ok := f.cache[index]; ok {
+		f.order.MoveToFront(e)
+		f.mu.Unlock()
+		return e.Value.(*filePiece).data, nil
+	}
+	f.mu.Unlock()
+
+	p := f.pieces[index]
+	data, err := downloadPiece(f.store, p.Reference, p.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", p.Name(), err)
+	}
+	if f.key != nil {
+		if data, err = f.metadata.decryptPiece(p, data, f.key); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrDecryption, p.Name(), err)
+		}
+	}
+	if want := f.offsets[index+1] - f.offsets[index]; int64(len(data)) != want {
+		return nil, fmt.Errorf("%w: %s has %d bytes, want %d", ErrIntegrity, p.Name(), len(data), want)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.cache[index]; !ok {
+		f.cache[index] = f.order.PushFront(&filePiece{index: index, data: data})
+		f.evict()
+	}
+	return data, nil
+}
+
+// evict drops the least recently used pieces over the limit. The caller holds f.mu.
+func (f *File) evict() {
+	for f.order.Len() > f.limit {
+		e := f.order.Back()
+		f.order.Remove(e)
+		delete(f.cache, e.Value.(*filePiece).index)
+	}
+}
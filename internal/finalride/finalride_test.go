@@ -2,8 +2,30 @@ package finalride
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestEncryptionDecryption(t *testing.T) {
@@ -92,3 +114,2179 @@ func TestConfigLoadSave(t *testing.T) {
 		t.Errorf("EncryptDefault mismatch. Got %v, want %v", loadedConfig.EncryptDefault, originalConfig.EncryptDefault)
 	}
 }
+
+func TestHistoryAppendFilter(t *testing.T) {
+	historyPath := filepath.Join(t.TempDir(), "history.jsonl")
+
+	entries, err := LoadHistory(historyPath)
+	if err != nil {
+		t.Fatalf("Failed to load missing history: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("Expected empty history, got %d entries", len(entries))
+	}
+
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	records := []HistoryEntry{
+		{Time: base, Action: HistoryUpload, Filename: "report.pdf", CID: "aaa111", Encrypted: true, KeyHandling: KeyEmbedded},
+		{Time: base.Add(time.Hour), Action: HistoryDownload, Filename: "report.pdf", CID: "aaa111", Encrypted: true, KeyHandling: KeyEmbedded},
+		{Time: base.Add(2 * time.Hour), Action: HistoryUpload, Filename: "photo.png", CID: "bbb222", KeyHandling: KeyNone},
+	}
+	for _, record := range records {
+		if err := AppendHistory(historyPath, record); err != nil {
+			t.Fatalf("Failed to append history: %v", err)
+		}
+	}
+
+	entries, err = LoadHistory(historyPath)
+	if err != nil {
+		t.Fatalf("Failed to load history: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 entries, got %d", len(entries))
+	}
+
+	uploads := FilterHistory(entries, HistoryFilter{Action: HistoryUpload})
+	if len(uploads) != 2 || uploads[0].Filename != "photo.png" {
+		t.Fatalf("Expected 2 uploads newest first, got %+v", uploads)
+	}
+
+	matches := FilterHistory(entries, HistoryFilter{Query: "REPORT"})
+	if len(matches) != 2 {
+		t.Errorf("Expected 2 matches for query, got %d", len(matches))
+	}
+
+	recent := FilterHistory(entries, HistoryFilter{Since: base.Add(30 * time.Minute), Limit: 1})
+	if len(recent) != 1 || recent[0].CID != "bbb222" {
+		t.Errorf("Expected latest entry only, got %+v", recent)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	cases := map[string]string{
+		"abc123":     "abc123",
+		"  abc123\n": "abc123",
+		"http://localhost:8080/index.html?download=abc": "abc",
+		"https://host/?foo=1&download=abc&bar=2":        "abc",
+	}
+	for input, want := range cases {
+		if got := ParseReference(input); got != want {
+			t.Errorf("ParseReference(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestMetadataValidate(t *testing.T) {
+	key, _ := GenerateKey()
+	valid := `{"version":1,"filename":"a.txt","size":5,"encrypted":true,"key":"` + base64.StdEncoding.EncodeToString(key) +
+		`","chunked":true,"chunk_ids":{"1":"r1","2":"r2"},"chunk_hashes":{"1":"h1","2":"h2"}}`
+
+	metadata, err := ParseMetadata([]byte(valid))
+	if err != nil {
+		t.Fatalf("Valid metadata rejected: %v", err)
+	}
+	if metadata.ChunkCount() != 2 || metadata.EncryptionScheme() != SchemeAESGCM {
+		t.Errorf("Unexpected metadata summary: %d chunks, %s", metadata.ChunkCount(), metadata.EncryptionScheme())
+	}
+
+	invalid := map[string]string{
+		"missing filename": `{"chunked":false,"file_id":"r","file_hash":"h"}`,
+		"unsafe filename":  `{"filename":"../evil","chunked":false,"file_id":"r","file_hash":"h"}`,
+		"future version":   `{"version":99,"filename":"a","chunked":false,"file_id":"r","file_hash":"h"}`,
+		"bad key":          `{"filename":"a","encrypted":true,"key":"AAAA","chunked":false,"file_id":"r","file_hash":"h"}`,
+		"missing file id":  `{"filename":"a","chunked":false,"file_hash":"h"}`,
+		"chunk gap":        `{"filename":"a","chunked":true,"chunk_ids":{"1":"r1","3":"r3"},"chunk_hashes":{"1":"h1","3":"h3"}}`,
+		"missing hash":     `{"filename":"a","chunked":true,"chunk_ids":{"1":"r1"}}`,
+	}
+	for name, doc := range invalid {
+		if _, err := ParseMetadata([]byte(doc)); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestVerifyMetadata(t *testing.T) {
+	store := map[string][]byte{
+		"good":    []byte("chunk one"),
+		"corrupt": []byte("tampered"),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := store[strings.TrimPrefix(r.URL.Path, "/bzz/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	hashOf := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+	metadata := &Metadata{
+		Filename:    "a.bin",
+		Chunked:     true,
+		ChunkIDs:    map[string]string{"1": "good", "2": "corrupt", "3": "gone"},
+		ChunkHashes: map[string]string{"1": hashOf("chunk one"), "2": hashOf("original"), "3": hashOf("x")},
+	}
+
+	results := VerifyMetadata(metadata, Gateway(server.URL), false, nil)
+	want := []string{VerifyOK, VerifyCorrupt, VerifyMissing}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("Chunk %s: got status %s, want %s", result.Index, result.Status, want[i])
+		}
+	}
+
+	results = VerifyMetadata(metadata, Gateway(server.URL), true, nil)
+	want = []string{VerifyOK, VerifyOK, VerifyMissing}
+	for i, result := range results {
+		if result.Status != want[i] {
+			t.Errorf("HEAD chunk %s: got status %s, want %s", result.Index, result.Status, want[i])
+		}
+	}
+}
+
+// newFakeSwarm serves /bzz uploads and downloads, the /pins API and upload
+// tags from memory. Each read of a tag syncs one more chunk.
+func newFakeSwarm(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	store := map[string][]byte{}
+	pins := map[string]bool{}
+	tags := map[string]*Tag{}
+	var batches []*PostageBatch
+	chunks := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if path, ok := strings.CutPrefix(r.URL.Path, "/soc/"); ok {
+			parts := strings.Split(path, "/")
+			owner, _ := hex.DecodeString(parts[0])
+			id, _ := hex.DecodeString(parts[1])
+			sig, _ := hex.DecodeString(r.URL.Query().Get("sig"))
+			data, _ := io.ReadAll(r.Body)
+			chunk := append(append(id, sig...), data...)
+			if _, err := verifySOC(chunk, id, owner); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			address := fmt.Sprintf("%x", keccak256(id, owner))
+			chunks[address] = chunk
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"reference":%q}`, address)
+			return
+		}
+		if address, ok := strings.CutPrefix(r.URL.Path, "/chunks/"); ok {
+			chunk, ok := chunks[address]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(chunk)
+			return
+		}
+		if path, ok := strings.CutPrefix(r.URL.Path, "/feeds/"); ok && r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"reference":"%x"}`, keccak256([]byte(path)))
+			return
+		}
+
+		if r.URL.Path == "/chainstate" {
+			fmt.Fprint(w, `{"currentPrice":"24000"}`)
+			return
+		}
+		if r.URL.Path == "/stamps" {
+			list := []PostageBatch{}
+			for _, b := range batches {
+				list = append(list, *b)
+			}
+			json.NewEncoder(w).Encode(map[string][]PostageBatch{"stamps": list})
+			return
+		}
+		if path, ok := strings.CutPrefix(r.URL.Path, "/stamps/"); ok {
+			parts := strings.Split(path, "/")
+			var batch *PostageBatch
+			for _, b := range batches {
+				if len(parts) == 3 && b.BatchID == parts[1] {
+					batch = b
+				}
+			}
+			switch {
+			case r.Method == http.MethodPost && len(parts) == 2:
+				var depth uint
+				fmt.Sscan(parts[1], &depth)
+				batch = &PostageBatch{
+					BatchID:     fmt.Sprintf("%064x", len(batches)+1),
+					Usable:      true,
+					Exists:      true,
+					Label:       r.URL.Query().Get("label"),
+					Depth:       depth,
+					BucketDepth: 16,
+					Amount:      parts[0],
+					Immutable:   r.Header.Get("Immutable") == "true",
+					TTL:         3600,
+				}
+				batches = append(batches, batch)
+				w.WriteHeader(http.StatusCreated)
+			case batch != nil && parts[0] == "topup":
+				amount, _ := new(big.Int).SetString(parts[2], 10)
+				batch.Amount = new(big.Int).Add(batch.AmountPLUR(), amount).String()
+				batch.TTL *= 2
+				w.WriteHeader(http.StatusAccepted)
+			case batch != nil && parts[0] == "dilute":
+				fmt.Sscan(parts[2], &batch.Depth)
+				w.WriteHeader(http.StatusAccepted)
+			default:
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `{"batchID":%q}`, batch.BatchID)
+			return
+		}
+
+		if r.URL.Path == "/pins" {
+			var refs []string
+			for ref := range pins {
+				refs = append(refs, ref)
+			}
+			json.NewEncoder(w).Encode(map[string][]string{"references": refs})
+			return
+		}
+		if r.URL.Path == "/tags" {
+			tag := &Tag{UID: uint32(len(tags) + 1)}
+			tags[fmt.Sprint(tag.UID)] = tag
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(tag)
+			return
+		}
+		if uid, ok := strings.CutPrefix(r.URL.Path, "/tags/"); ok {
+			tag, ok := tags[uid]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			if tag.Synced < tag.Split-tag.Seen {
+				tag.Synced++
+			}
+			json.NewEncoder(w).Encode(tag)
+			return
+		}
+		if ref, ok := strings.CutPrefix(r.URL.Path, "/stewardship/"); ok {
+			_, stored := store[ref]
+			if r.Method == http.MethodPut && !stored {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]bool{"isRetrievable": stored})
+			return
+		}
+		if ref, ok := strings.CutPrefix(r.URL.Path, "/pins/"); ok {
+			switch r.Method {
+			case http.MethodPost:
+				if _, ok := store[ref]; !ok {
+					http.NotFound(w, r)
+					return
+				}
+				pins[ref] = true
+				w.WriteHeader(http.StatusCreated)
+			case http.MethodDelete:
+				delete(pins, ref)
+			default:
+				if !pins[ref] {
+					http.NotFound(w, r)
+				}
+			}
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if id := r.Header.Get("Swarm-Postage-Batch-Id"); id != "" {
+				var batch *PostageBatch
+				for _, b := range batches {
+					if b.BatchID == id {
+						batch = b
+					}
+				}
+				if batch == nil {
+					http.Error(w, "batch not found", http.StatusBadRequest)
+					return
+				}
+				batch.Utilization++
+			}
+			data, _ := io.ReadAll(r.Body)
+			ref := fmt.Sprintf("%x", sha256.Sum256(data))
+			if tag, ok := tags[r.Header.Get("Swarm-Tag")]; ok {
+				tag.Split++
+				if _, seen := store[ref]; seen {
+					tag.Seen++
+				}
+			}
+			store[ref] = data
+			if r.Header.Get("Swarm-Pin") == "true" {
+				pins[ref] = true
+			}
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"reference":%q}`, ref)
+			return
+		}
+		data, ok := store[strings.TrimPrefix(r.URL.Path, "/bzz/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	server := newFakeSwarm(t)
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	cases := []struct {
+		name     string
+		size     int
+		encrypt  bool
+		chunked  bool
+		perChunk bool
+	}{
+		{"small plain", 100, false, false, false},
+		{"small encrypted", 100, true, false, false},
+		{"exact chunk", 1000, true, false, false},
+		{"chunked plain", 2500, false, true, false},
+		{"chunked encrypted", 2500, true, true, true},
+		{"empty", 0, false, false, false},
+	}
+	for _, c := range cases {
+		var progress int64
+		metadata, err := UploadStream(bytes.NewReader(data[:c.size]), UploadOptions{
+			Filename:   "a.bin",
+			Encrypt:    c.encrypt,
+			ChunkSize:  1000,
+			Store:      Gateway(server.URL),
+			OnProgress: func(n int64) { progress += n },
+		})
+		if err != nil {
+			t.Fatalf("%s: upload failed: %v", c.name, err)
+		}
+		if err := metadata.Validate(); err != nil {
+			t.Fatalf("%s: invalid metadata: %v", c.name, err)
+		}
+		if metadata.Chunked != c.chunked || metadata.PerChunkEncryption() != c.perChunk {
+			t.Errorf("%s: chunked=%v perChunk=%v", c.name, metadata.Chunked, metadata.PerChunkEncryption())
+		}
+		if metadata.Size != int64(c.size) || progress != int64(c.size) {
+			t.Errorf("%s: size %d, progress %d, want %d", c.name, metadata.Size, progress, c.size)
+		}
+
+		var out bytes.Buffer
+		if _, err := DownloadStream(metadata, Gateway(server.URL), &out, nil); err != nil {
+			t.Fatalf("%s: download failed: %v", c.name, err)
+		}
+		if !bytes.Equal(out.Bytes(), data[:c.size]) {
+			t.Errorf("%s: downloaded data does not match", c.name)
+		}
+	}
+}
+
+func TestDownloadStreamLegacy(t *testing.T) {
+	server := newFakeSwarm(t)
+	key, _ := GenerateKey()
+	plaintext := bytes.Repeat([]byte("legacy "), 500)
+
+	// Whole-file encryption, then chunking, as written by earlier versions
+	encrypted, _ := EncryptData(plaintext, key)
+	chunks, hashes := SplitIntoChunks(encrypted, 1000)
+	metadata := &Metadata{
+		Filename:    "old.txt",
+		Encrypted:   true,
+		Key:         base64.StdEncoding.EncodeToString(key),
+		Chunked:     true,
+		ChunkIDs:    map[string]string{},
+		ChunkHashes: hashes,
+	}
+	for k, chunk := range chunks {
+		ref, err := UploadToSwarm(chunk, server.URL)
+		if err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+		metadata.ChunkIDs[k] = ref
+	}
+
+	var out bytes.Buffer
+	if _, err := DownloadStream(metadata, Gateway(server.URL), &out, nil); err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), plaintext) {
+		t.Fatal("Legacy download does not match")
+	}
+
+	metadata.ChunkHashes["2"] = hashes["1"]
+	if _, err := DownloadStream(metadata, Gateway(server.URL), io.Discard, nil); !errors.Is(err, ErrIntegrity) {
+		t.Fatalf("Expected integrity error, got %v", err)
+	}
+}
+
+func TestResolveConfig(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(ConfigPathEnv, "")
+	t.Setenv(ProfileEnv, "")
+	t.Chdir(t.TempDir())
+
+	config, path, err := ResolveConfig(ConfigOptions{})
+	if err != nil {
+		t.Fatalf("Resolve without a file failed: %v", err)
+	}
+	if path != "" || config.SwarmAPI != DefaultConfig().SwarmAPI {
+		t.Errorf("Expected defaults, got %q from %q", config.SwarmAPI, path)
+	}
+
+	configPath := filepath.Join(t.TempDir(), "custom.yaml")
+	doc := `swarm_api: http://prod:1633
+chunk_size_mb: 4
+profiles:
+  staging:
+    swarm_api: http://staging:1633
+    encrypt_default: false
+`
+	if err := os.WriteFile(configPath, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, path, err = ResolveConfig(ConfigOptions{Path: configPath, Profile: "staging"})
+	if err != nil {
+		t.Fatalf("Resolve failed: %v", err)
+	}
+	if path != configPath || config.SwarmAPI != "http://staging:1633" || config.EncryptDefault || config.ChunkSizeMB != 4 {
+		t.Errorf("Profile not applied: %+v", config)
+	}
+	if config.Theme != "dark" {
+		t.Errorf("Missing keys should keep defaults, got theme %q", config.Theme)
+	}
+
+	t.Setenv(ConfigPathEnv, configPath)
+	t.Setenv("FINAL_RIDE_CHUNK_SIZE_MB", "7")
+	t.Setenv("FINAL_RIDE_SWARM_API", "http://env:1633")
+	config, _, err = ResolveConfig(ConfigOptions{Profile: "staging"})
+	if err != nil {
+		t.Fatalf("Resolve with env failed: %v", err)
+	}
+	if config.ChunkSizeMB != 7 || config.SwarmAPI != "http://env:1633" {
+		t.Errorf("Environment overrides not applied: %+v", config)
+	}
+
+	if _, _, err := ResolveConfig(ConfigOptions{Profile: "missing"}); err == nil {
+		t.Error("Expected unknown profile to fail")
+	}
+	t.Setenv("FINAL_RIDE_CHUNK_SIZE_MB", "lots")
+	if _, _, err := ResolveConfig(ConfigOptions{}); err == nil {
+		t.Error("Expected invalid override to fail")
+	}
+	if _, _, err := ResolveConfig(ConfigOptions{Path: filepath.Join(t.TempDir(), "none.yaml")}); err == nil {
+		t.Error("Expected missing explicit config to fail")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := DefaultConfig().Validate(); err != nil {
+		t.Fatalf("Default config rejected: %v", err)
+	}
+
+	invalid := map[string]func(c *Config){
+		"chunk size":        func(c *Config) { c.ChunkSizeMB = 0 },
+		"api scheme":        func(c *Config) { c.SwarmAPI = "localhost:1633" },
+		"link without cid":  func(c *Config) { c.DownloadLink = "http://localhost/index.html" },
+		"link extra verb":   func(c *Config) { c.DownloadLink = "http://localhost/%d?download=%s" },
+		"theme":             func(c *Config) { c.Theme = "blue" },
+		"missing directory": func(c *Config) { c.DownloadDir = filepath.Join(t.TempDir(), "missing") },
+		"endpoint scheme":   func(c *Config) { c.Endpoints = []string{"bee:1633"} },
+		"strategy":          func(c *Config) { c.DownloadStrategy = "fastest" },
+		"postage batch":     func(c *Config) { c.PostageBatch = "abc" },
+	}
+	for name, mutate := range invalid {
+		config := DefaultConfig()
+		mutate(config)
+		if err := config.Validate(); err == nil {
+			t.Errorf("Expected invalid %s to be rejected", name)
+		}
+	}
+
+	config := DefaultConfig()
+	if err := config.Set("chunk_size_mb", "25"); err != nil || config.ChunkSizeMB != 25 {
+		t.Errorf("Set failed: %v", err)
+	}
+	if value, _ := config.Get("encrypt_default"); value != "true" {
+		t.Errorf("Get returned %q", value)
+	}
+	if err := config.Set("endpoints", "http://a:1633, http://b:1633/"); err != nil {
+		t.Errorf("Set endpoints failed: %v", err)
+	}
+	if got := config.APIEndpoints(); len(got) != 3 || got[2] != "http://b:1633" {
+		t.Errorf("APIEndpoints returned %v", got)
+	}
+	if err := config.Set("nope", "1"); err == nil {
+		t.Error("Expected unknown key to fail")
+	}
+
+	if chunks, _ := SplitIntoChunks([]byte("data"), 0); len(chunks) != 1 {
+		t.Errorf("Zero chunk size produced %d chunks", len(chunks))
+	}
+}
+
+func TestPoolFailover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	up := newFakeSwarm(t)
+
+	pool := NewPool([]string{down.URL, up.URL}, StrategyFailover, 0)
+	ref, err := pool.Upload([]byte("payload"))
+	if err != nil {
+		t.Fatalf("Upload did not fail over: %v", err)
+	}
+	if data, err := pool.Download(ref); err != nil || string(data) != "payload" {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	status := pool.Status()
+	if status[0].Healthy || status[0].Failures != 1 || !status[1].Healthy {
+		t.Errorf("Unexpected health: %+v", status)
+	}
+	if order := pool.order(); order[0] != 1 {
+		t.Errorf("Unhealthy endpoint should be tried last, got order %v", order)
+	}
+
+	// Missing content is not an endpoint failure and must not demote it
+	primary, secondary := newFakeSwarm(t), newFakeSwarm(t)
+	pool = NewPool([]string{primary.URL, secondary.URL}, StrategyFailover, 0)
+	if err := pool.Check(strings.Repeat("0", 64)); err == nil {
+		t.Fatal("Check found a missing reference")
+	}
+	if _, err := pool.Download(strings.Repeat("0", 64)); err == nil {
+		t.Fatal("Download found a missing reference")
+	}
+	if status := pool.Status(); !status[0].Healthy || status[0].Failures != 0 {
+		t.Errorf("404 marked the primary unhealthy: %+v", status[0])
+	}
+	ref, err = pool.Upload([]byte("stays on the primary"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := DownloadFromSwarm(ref, primary.URL); err != nil {
+		t.Errorf("Upload after a 404 did not go to the primary: %v", err)
+	}
+}
+
+func TestPoolHedgedDownload(t *testing.T) {
+	good := []byte("the real chunk")
+	hash := fmt.Sprintf("%x", sha256.Sum256(good))
+
+	corrupt := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("tampered chunk"))
+	}))
+	defer corrupt.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+		w.Write(good)
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(good)
+	}))
+	defer fast.Close()
+
+	for _, strategy := range []string{StrategyFailover, StrategyHedge, StrategyRace} {
+		pool := NewPool([]string{corrupt.URL, slow.URL, fast.URL}, strategy, 20*time.Millisecond)
+		start := time.Now()
+		data, err := pool.DownloadVerified("ref", hash)
+		if err != nil || !bytes.Equal(data, good) {
+			t.Fatalf("%s: download failed: %v", strategy, err)
+		}
+		if strategy != StrategyFailover && time.Since(start) > 500*time.Millisecond {
+			t.Errorf("%s: waited for the slow endpoint", strategy)
+		}
+		// The racing request to the corrupt endpoint may still be in flight
+		if strategy != StrategyRace && pool.Status()[0].Healthy {
+			t.Errorf("%s: corrupt endpoint still healthy", strategy)
+		}
+	}
+
+	pool := NewPool([]string{corrupt.URL}, StrategyRace, 0)
+	if _, err := pool.DownloadVerified("ref", hash); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Expected integrity error, got %v", err)
+	}
+}
+
+func TestReplicatedStore(t *testing.T) {
+	a, b := newFakeSwarm(t), newFakeSwarm(t)
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	store := NewReplicatedStore(NewPool([]string{a.URL, b.URL}, StrategyFailover, 0), 0)
+	ref, err := store.Upload([]byte("critical"))
+	if err != nil {
+		t.Fatalf("Replicated upload failed: %v", err)
+	}
+	if placement := store.Placement(ref); len(placement) != 2 {
+		t.Errorf("Expected two replicas, got %v", placement)
+	}
+	for _, server := range []*httptest.Server{a, b} {
+		if _, err := DownloadFromSwarm(ref, server.URL); err != nil {
+			t.Errorf("Replica missing on %s: %v", server.URL, err)
+		}
+	}
+
+	pool := NewPool([]string{a.URL, down.URL}, StrategyFailover, 0)
+	if _, err := NewReplicatedStore(pool, 0).Upload([]byte("critical")); err == nil {
+		t.Error("Expected upload to fail without every replica")
+	}
+	if _, err := NewReplicatedStore(pool, 1).Upload([]byte("critical")); err != nil {
+		t.Errorf("Expected one replica to be enough: %v", err)
+	}
+
+	// Endpoints that store the same data under different references disagree
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"reference":"different"}`)
+	}))
+	defer other.Close()
+	pool = NewPool([]string{a.URL, other.URL}, StrategyFailover, 0)
+	if _, err := NewReplicatedStore(pool, 1).Upload([]byte("critical")); !errors.Is(err, ErrReplicaMismatch) {
+		t.Errorf("Expected replica mismatch, got %v", err)
+	}
+}
+
+func TestReplicate(t *testing.T) {
+	source, target := newFakeSwarm(t), newFakeSwarm(t)
+
+	metadata, err := UploadStream(bytes.NewReader(bytes.Repeat([]byte("x"), 2500)), UploadOptions{
+		Filename:  "a.bin",
+		Encrypt:   true,
+		ChunkSize: 1000,
+		Store:     Gateway(source.URL),
+	})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	cid, err := UploadMetadata(metadata, Gateway(source.URL))
+	if err != nil {
+		t.Fatalf("Metadata upload failed: %v", err)
+	}
+
+	results, err := Replicate(cid, Gateway(source.URL), Gateway(target.URL), nil)
+	if err != nil {
+		t.Fatalf("Replicate failed: %v", err)
+	}
+	if len(results) != 4 || results[3].Index != "metadata" {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+
+	copied, err := FetchMetadata(cid, Gateway(target.URL))
+	if err != nil {
+		t.Fatalf("Metadata missing on target: %v", err)
+	}
+	if _, err := DownloadStream(copied, Gateway(target.URL), io.Discard, nil); err != nil {
+		t.Errorf("Download from target failed: %v", err)
+	}
+}
+
+func TestPins(t *testing.T) {
+	server := newFakeSwarm(t)
+
+	pool := NewPool([]string{server.URL}, StrategyFailover, 0)
+	pool.SetPin(true)
+	pinned, err := pool.Upload([]byte("keep me"))
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	if ok, err := IsPinned(pinned, server.URL); err != nil || !ok {
+		t.Errorf("Upload with Swarm-Pin not pinned: %v", err)
+	}
+
+	metadata, err := UploadStream(bytes.NewReader(bytes.Repeat([]byte("pin"), 900)), UploadOptions{
+		Filename:  "a.bin",
+		ChunkSize: 1000,
+		Store:     Gateway(server.URL),
+	})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	cid, _ := UploadMetadata(metadata, Gateway(server.URL))
+
+	for _, r := range SharePinStatus(cid, metadata, server.URL, nil) {
+		if r.Err != nil || r.Pinned {
+			t.Errorf("%s: expected unpinned, got %+v", r.Index, r)
+		}
+	}
+	results := PinShare(cid, metadata, server.URL, nil)
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 references, got %d", len(results))
+	}
+	for _, r := range SharePinStatus(cid, metadata, server.URL, nil) {
+		if r.Err != nil || !r.Pinned {
+			t.Errorf("%s: expected pinned, got %+v", r.Index, r)
+		}
+	}
+	if refs, err := ListPins(server.URL); err != nil || len(refs) != 5 {
+		t.Errorf("Expected 5 pins, got %d (%v)", len(refs), err)
+	}
+
+	UnpinShare(cid, metadata, server.URL, nil)
+	if refs, _ := ListPins(server.URL); len(refs) != 1 || refs[0] != pinned {
+		t.Errorf("Unpin left %v", refs)
+	}
+
+	for endpoint, want := range map[string]bool{
+		PublicGateway:                       true,
+		"https://gateway.ethswarm.org/":     true,
+		"http://localhost:1633":             false,
+		"https://bee.example.org":           false,
+		"https://gateway.ethswarm.org.evil": false,
+	} {
+		if got := IsPublicGateway(endpoint); got != want {
+			t.Errorf("IsPublicGateway(%q) = %v, want %v", endpoint, got, want)
+		}
+	}
+}
+
+func TestSyncTracking(t *testing.T) {
+	server := newFakeSwarm(t)
+	pool := NewPool([]string{server.URL}, StrategyFailover, 0)
+
+	if _, err := pool.SyncStatus(); !errors.Is(err, ErrSyncUnsupported) {
+		t.Errorf("Expected untracked uploads to be unsupported, got %v", err)
+	}
+
+	pool.TrackSync()
+	for _, data := range []string{"one", "two", "one"} {
+		if _, err := pool.Upload([]byte(data)); err != nil {
+			t.Fatalf("Upload failed: %v", err)
+		}
+	}
+
+	status, err := pool.SyncStatus()
+	if err != nil {
+		t.Fatalf("SyncStatus failed: %v", err)
+	}
+	if status.Split != 3 || status.Seen != 1 || status.Total() != 2 || len(status.Tags) != 1 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	polls := 0
+	status, err = pool.WaitSynced(time.Second, time.Millisecond, func(SyncStatus) { polls++ })
+	if err != nil || !status.Done() {
+		t.Fatalf("WaitSynced failed: %v (%+v)", err, status)
+	}
+	if polls < 1 {
+		t.Error("Expected progress callbacks")
+	}
+
+	// A gateway without the tags API still accepts uploads
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tags" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"reference":"ref"}`)
+	}))
+	defer gateway.Close()
+	pool = NewPool([]string{gateway.URL}, StrategyFailover, 0)
+	pool.TrackSync()
+	if _, err := pool.Upload([]byte("data")); err != nil {
+		t.Fatalf("Upload without tags failed: %v", err)
+	}
+	if _, err := pool.SyncStatus(); !errors.Is(err, ErrSyncUnsupported) {
+		t.Errorf("Expected unsupported sync tracking, got %v", err)
+	}
+}
+
+func TestStewardship(t *testing.T) {
+	source := newFakeSwarm(t)
+	data := bytes.Repeat([]byte("steward"), 400)
+	metadata, err := UploadStream(bytes.NewReader(data), UploadOptions{
+		Filename:  "a.bin",
+		ChunkSize: 1000,
+		Store:     Gateway(source.URL),
+	})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	cid, _ := UploadMetadata(metadata, Gateway(source.URL))
+	document, err := Gateway(source.URL).Download(cid)
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+
+	for _, r := range CheckShare(cid, metadata, source.URL, nil) {
+		if r.Err != nil || !r.Retrievable {
+			t.Errorf("%s: expected retrievable, got %+v", r.Index, r)
+		}
+	}
+
+	// A node that lost the share can neither serve nor re-push it
+	lost := newFakeSwarm(t)
+	for _, r := range CheckShare(cid, metadata, lost.URL, nil) {
+		if r.Err != nil || r.Retrievable {
+			t.Errorf("%s: expected unretrievable, got %+v", r.Index, r)
+		}
+	}
+	for _, r := range ReuploadShare(cid, metadata, lost.URL, nil) {
+		if r.Err == nil {
+			t.Errorf("%s: reupload of a missing reference succeeded", r.Index)
+		}
+	}
+
+	// A different local copy is rejected before anything is uploaded
+	changed := append([]byte{'x'}, data[1:]...)
+	if _, err := ReuploadFromFile(cid, document, bytes.NewReader(changed), Gateway(lost.URL), nil); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Expected integrity error for a changed copy, got %v", err)
+	}
+	if _, err := ReuploadFromFile(cid, document, bytes.NewReader(append(data, 'x')), Gateway(lost.URL), nil); err == nil {
+		t.Error("Expected error for a longer copy")
+	}
+
+	results, err := ReuploadFromFile(cid, document, bytes.NewReader(data), Gateway(lost.URL), nil)
+	if err != nil {
+		t.Fatalf("Reupload from file failed: %v", err)
+	}
+	if len(results) != 4 {
+		t.Errorf("Expected 4 references, got %d", len(results))
+	}
+	for _, r := range ReuploadShare(cid, metadata, lost.URL, nil) {
+		if r.Err != nil {
+			t.Errorf("%s: reupload failed: %v", r.Index, r.Err)
+		}
+	}
+	for _, r := range CheckShare(cid, metadata, lost.URL, nil) {
+		if !r.Retrievable {
+			t.Errorf("%s: expected retrievable after reupload", r.Index)
+		}
+	}
+
+	encrypted, err := UploadStream(bytes.NewReader(data), UploadOptions{
+		Filename:  "b.bin",
+		Encrypt:   true,
+		ChunkSize: 1000,
+		Store:     Gateway(source.URL),
+	})
+	if err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+	encryptedCID, _ := UploadMetadata(encrypted, Gateway(source.URL))
+	document, _ = Gateway(source.URL).Download(encryptedCID)
+	if _, err := ReuploadFromFile(encryptedCID, document, bytes.NewReader(data), Gateway(lost.URL), nil); !errors.Is(err, ErrNotReproducible) {
+		t.Errorf("Expected ErrNotReproducible, got %v", err)
+	}
+}
+
+func TestEstimateUpload(t *testing.T) {
+	cases := []struct {
+		size      int64
+		chunkSize int
+		encrypt   bool
+		pieces    int
+		chunks    int64
+	}{
+		// One data chunk plus the manifest, and the same for the metadata
+		{100, 1000, false, 1, 3 + 3},
+		// 1 MB is 256 data chunks under 2 intermediate chunks and a root
+		{1 << 20, 1 << 20, false, 1, 256 + 2 + 1 + 2 + 3},
+		// Encryption overhead spills into a 257th data chunk
+		{1 << 20, 1 << 20, true, 1, 257 + 3 + 1 + 2 + 3},
+		// Two full pieces and a short one; the metadata lists every piece
+		{2500, 1000, false, 3, 3*3 + 3},
+	}
+	for _, c := range cases {
+		e := EstimateUpload(c.size, c.chunkSize, c.encrypt)
+		if e.Pieces != c.pieces || e.Chunks != c.chunks {
+			t.Errorf("EstimateUpload(%d, %d, %v) = %d pieces, %d chunks; want %d, %d", c.size, c.chunkSize, c.encrypt, e.Pieces, e.Chunks, c.pieces, c.chunks)
+		}
+	}
+
+	e := EstimateUpload(100, 1000, false)
+	if got := FormatBZZ(e.Cost(big.NewInt(1e15))); got != "0.6 BZZ" {
+		t.Errorf("Cost of %d slots at 1e15 PLUR = %s, want 0.6 BZZ", e.Chunks, got)
+	}
+}
+
+func TestStamps(t *testing.T) {
+	node := newFakeSwarm(t)
+
+	price, err := CurrentPrice(node.URL)
+	if err != nil {
+		t.Fatalf("CurrentPrice failed: %v", err)
+	}
+	if amount := AmountForTTL(price, time.Hour); amount.Cmp(big.NewInt(24000*720)) != 0 {
+		t.Errorf("Amount for an hour = %s, want %d", amount, 24000*720)
+	}
+
+	small, err := BuyStamp(big.NewInt(1000), 17, "small", false, node.URL)
+	if err != nil {
+		t.Fatalf("BuyStamp failed: %v", err)
+	}
+	large, _ := BuyStamp(big.NewInt(1000), 20, "large", true, node.URL)
+	if err := TopUpStamp(small, big.NewInt(500), node.URL); err != nil {
+		t.Fatalf("TopUpStamp failed: %v", err)
+	}
+	if err := DiluteStamp(large, 21, node.URL); err != nil {
+		t.Fatalf("DiluteStamp failed: %v", err)
+	}
+
+	batches, err := ListStamps(node.URL)
+	if err != nil || len(batches) != 2 {
+		t.Fatalf("Expected 2 batches, got %d (%v)", len(batches), err)
+	}
+	if b := batches[0]; b.Amount != "1500" || b.Label != "small" || b.Remaining() != 1<<17 {
+		t.Errorf("Unexpected topped-up batch %+v", b)
+	}
+	if b := batches[1]; b.Depth != 21 || !b.Immutable {
+		t.Errorf("Unexpected diluted batch %+v", b)
+	}
+
+	// The small batch lives longer after its top-up, but only the large one fits
+	if b, err := SelectBatch(batches, 1000); err != nil || b.BatchID != small {
+		t.Errorf("Expected the longest-lived batch, got %v (%v)", b.BatchID, err)
+	}
+	if b, err := SelectBatch(batches, 1<<18); err != nil || b.BatchID != large {
+		t.Errorf("Expected the batch with room, got %v (%v)", b.BatchID, err)
+	}
+	if _, err := SelectBatch(batches, 1<<22); !errors.Is(err, ErrNoUsableBatch) {
+		t.Errorf("Expected ErrNoUsableBatch, got %v", err)
+	}
+
+	// Gateways without /stamps stamp uploads themselves
+	gateway := httptest.NewServer(http.NotFoundHandler())
+	defer gateway.Close()
+	pool := NewPool([]string{node.URL, gateway.URL}, StrategyFailover, 0)
+	chosen, err := pool.PrepareStamps(BatchAuto, 10)
+	if err != nil {
+		t.Fatalf("PrepareStamps failed: %v", err)
+	}
+	if len(chosen) != 1 || chosen[node.URL].BatchID != small {
+		t.Errorf("Unexpected batches %+v", chosen)
+	}
+	if _, err := pool.Upload([]byte("stamped")); err != nil {
+		t.Fatalf("Stamped upload failed: %v", err)
+	}
+	batches, _ = ListStamps(node.URL)
+	if batches[0].Utilization != 1 {
+		t.Errorf("Upload was not stamped with the selected batch")
+	}
+
+	if _, err := pool.PrepareStamps(fmt.Sprintf("%064x", 99), 10); err != nil {
+		t.Fatalf("PrepareStamps failed: %v", err)
+	}
+	if _, err := pool.UploadTo([]byte("unknown batch"), 0); err == nil {
+		t.Error("Expected upload with an unknown batch to fail")
+	}
+	if _, err := pool.PrepareStamps(BatchAuto, 1<<22); !errors.Is(err, ErrNoUsableBatch) {
+		t.Errorf("Expected ErrNoUsableBatch, got %v", err)
+	}
+}
+
+func TestBMTHash(t *testing.T) {
+	// Test vector from Bee's content-addressed chunk tests
+	payload := []byte("greaterthanspan")
+	data := make([]byte, 8, 8+len(payload))
+	data[0] = byte(len(payload))
+	data = append(data, payload...)
+	if got := hex.EncodeToString(bmtHash(data)); got != "27913f1bdb6e8e52cbd5a5fd4ab577c857287edf6969b41efe926b51de0f4f23" {
+		t.Errorf("bmtHash = %s", got)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.key")
+	if err := os.WriteFile(path, []byte("0x"+strings.Repeat("0", 63)+"1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	id, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatalf("LoadIdentity failed: %v", err)
+	}
+	if owner := id.Owner(); owner != "7e5f4552091a69125d5dfcb7b8c2659029395bdf" {
+		t.Errorf("Owner of key 1 = %s", owner)
+	}
+
+	created := filepath.Join(t.TempDir(), "new", "identity.key")
+	first, err := LoadIdentity(created)
+	if err != nil {
+		t.Fatalf("Failed to create identity: %v", err)
+	}
+	second, err := LoadIdentity(created)
+	if err != nil || second.Owner() != first.Owner() {
+		t.Errorf("Reloaded identity differs: %v", err)
+	}
+}
+
+func TestFeeds(t *testing.T) {
+	server := newFakeSwarm(t)
+	id, err := LoadIdentity(filepath.Join(t.TempDir(), "identity.key"))
+	if err != nil {
+		t.Fatalf("LoadIdentity failed: %v", err)
+	}
+	topic := FeedTopic("nightly")
+
+	if _, err := LatestFeedUpdate(id.Owner(), topic, server.URL); !errors.Is(err, ErrNoFeedUpdate) {
+		t.Errorf("Expected empty feed, got %v", err)
+	}
+
+	var refs []string
+	for i := 0; i < 5; i++ {
+		ref := fmt.Sprintf("%064x", i+100)
+		update, err := PublishFeed(id, topic, ref, "", server.URL)
+		if err != nil {
+			t.Fatalf("PublishFeed failed: %v", err)
+		}
+		if update.Index != uint64(i) {
+			t.Errorf("Update %d published at index %d", i, update.Index)
+		}
+		refs = append(refs, ref)
+	}
+
+	latest, err := LatestFeedUpdate(id.Owner(), topic, server.URL)
+	if err != nil || latest.Index != 4 || latest.Reference != refs[4] {
+		t.Errorf("Unexpected latest update %+v (%v)", latest, err)
+	}
+	if update, err := GetFeedUpdate(id.Owner(), topic, 1, server.URL); err != nil || update.Reference != refs[1] {
+		t.Errorf("Unexpected update 1: %+v (%v)", update, err)
+	}
+	history, err := FeedHistory(id.Owner(), topic, server.URL)
+	if err != nil || len(history) != 5 || history[0].Reference != refs[0] {
+		t.Errorf("Unexpected history %+v (%v)", history, err)
+	}
+	if _, err := LatestFeedUpdate(id.Owner(), FeedTopic("other"), server.URL); !errors.Is(err, ErrNoFeedUpdate) {
+		t.Errorf("Expected other topic to be empty, got %v", err)
+	}
+
+	first, _ := CreateFeedManifest(id.Owner(), topic, "", server.URL)
+	second, _ := CreateFeedManifest(id.Owner(), topic, "", server.URL)
+	if first == "" || first != second {
+		t.Errorf("Feed manifest is not stable: %s, %s", first, second)
+	}
+
+	// An update is only accepted from the owner's key
+	other, _ := LoadIdentity(filepath.Join(t.TempDir(), "other.key"))
+	resp, _ := http.Get(fmt.Sprintf("%s/chunks/%x", server.URL, keccak256(feedIdentifier(topic, 0), mustHex(id.Owner()))))
+	chunk, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if _, err := verifySOC(chunk, feedIdentifier(topic, 0), mustHex(other.Owner())); !errors.Is(err, ErrFeedSignature) || !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Expected signature error, got %v", err)
+	}
+	chunk[len(chunk)-1] ^= 1
+	if _, err := verifySOC(chunk, feedIdentifier(topic, 0), mustHex(id.Owner())); !errors.Is(err, ErrFeedSignature) {
+		t.Errorf("Expected tampered payload to be rejected, got %v", err)
+	}
+}
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestServer(t *testing.T) {
+	config := DefaultConfig()
+	config.CacheDir = t.TempDir()
+	config.SwarmAPI = "http://bee:1633"
+	config.ChunkSizeMB = 4
+	config.BrandName = "Acme Drop"
+	server := httptest.NewServer(NewServer(config, fstest.MapFS{"index.html": {Data: []byte("<html>ui</html>")}}, ServerOptions{}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var web WebConfig
+	err = json.NewDecoder(resp.Body).Decode(&web)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("Invalid config.json: %v", err)
+	}
+	if web.Gateway != "api" || web.ChunkSize != 4*1024*1024 || web.Name != "Acme Drop" || !web.EncryptDefault || web.DownloadLink != config.DownloadLink {
+		t.Errorf("Unexpected web config %+v", web)
+	}
+
+	// Share links open index.html with the CID in the query; it must not be redirected away
+	for _, path := range []string{"/", "/index.html?download=abc"} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "<html>ui</html>" || resp.Request.URL.RequestURI() != path {
+			t.Errorf("GET %s: %d %q (ended at %s)", path, resp.StatusCode, body, resp.Request.URL)
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	node := newFakeSwarm(t)
+	batch, err := BuyStamp(big.NewInt(1000), 17, "web", false, node.URL)
+	if err != nil {
+		t.Fatalf("BuyStamp failed: %v", err)
+	}
+	var auth string
+	bee := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		node.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer bee.Close()
+
+	config := DefaultConfig()
+	config.CacheDir = t.TempDir()
+	config.SwarmAPI = bee.URL
+	config.PostageBatch = BatchAuto
+	config.APIToken = "secret"
+	server := httptest.NewServer(NewServer(config, fstest.MapFS{}, ServerOptions{MaxUpload: 10}))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/api/bzz", "application/octet-stream", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var uploaded struct {
+		Reference string `json:"reference"`
+	}
+	json.NewDecoder(resp.Body).Decode(&uploaded)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || uploaded.Reference == "" {
+		t.Fatalf("Proxy upload failed: %s", resp.Status)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Proxy sent Authorization %q", auth)
+	}
+	if batches, _ := ListStamps(node.URL); batches[0].BatchID != batch || batches[0].Utilization != 1 {
+		t.Errorf("Proxy upload was not stamped: %+v", batches)
+	}
+
+	resp, err = http.Get(server.URL + "/api/bzz/" + uploaded.Reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("Proxy download returned %s %q", resp.Status, body)
+	}
+
+	for path, want := range map[string]int{
+		"/api/bzz/" + strings.Repeat("0", 64):                  http.StatusNotFound,
+		"/api/bzz/stamps":                                      http.StatusBadRequest,
+		"/api/bzz/" + uploaded.Reference + "/..%2F..%2Fstamps": http.StatusBadRequest,
+	} {
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("GET %s: %s, want %d", path, resp.Status, want)
+		}
+	}
+
+	resp, err = http.Post(server.URL+"/api/bzz", "application/octet-stream", strings.NewReader("more than ten bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("Oversized upload returned %s", resp.Status)
+	}
+
+	limiter := &rateLimiter{limit: 2, window: time.Minute, clients: map[string]*rateWindow{}}
+	now := time.Now()
+	for i, want := range []bool{true, true, false} {
+		if _, ok := limiter.allow("1.2.3.4", now); ok != want {
+			t.Errorf("Request %d allowed = %v", i+1, ok)
+		}
+	}
+	if _, ok := limiter.allow("5.6.7.8", now); !ok {
+		t.Error("Another client was limited")
+	}
+	if _, ok := limiter.allow("1.2.3.4", now.Add(time.Minute)); !ok {
+		t.Error("Limit was not reset after the window")
+	}
+
+	// Uploads and downloads draw from the same budget
+	limited := httptest.NewServer(NewServer(config, fstest.MapFS{}, ServerOptions{RateLimit: 2}))
+	defer limited.Close()
+	for i, want := range []int{http.StatusCreated, http.StatusOK, http.StatusTooManyRequests} {
+		if i == 0 {
+			resp, err = http.Post(limited.URL+"/api/bzz", "application/octet-stream", strings.NewReader("hi"))
+		} else {
+			resp, err = http.Get(limited.URL + "/api/bzz/" + uploaded.Reference)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("Request %d to the limited server: %s, want %d", i+1, resp.Status, want)
+		}
+	}
+}
+
+func TestDaemon(t *testing.T) {
+	node := newFakeSwarm(t)
+	config := DefaultConfig()
+	config.CacheDir = t.TempDir()
+	config.SwarmAPI = node.URL
+	config.PostageBatch = ""
+	config.ChunkSizeMB = 1
+	history := filepath.Join(t.TempDir(), "history.jsonl")
+	server := httptest.NewServer(NewDaemon(config, DaemonOptions{Token: "s3cret", SpoolDir: t.TempDir(), HistoryPath: history}))
+	defer server.Close()
+
+	call := func(method, path, contentType string, body io.Reader) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+path, body)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	decode := func(resp *http.Response) UploadJob {
+		t.Helper()
+		defer resp.Body.Close()
+		var job UploadJob
+		if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+			t.Fatalf("Invalid job: %v", err)
+		}
+		return job
+	}
+
+	if resp, _ := http.Get(server.URL + "/v1/uploads"); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Request without token returned %s", resp.Status)
+	}
+	if resp, _ := http.Get(server.URL + "/v1/openapi.json"); resp.StatusCode != http.StatusOK {
+		t.Errorf("OpenAPI description returned %s", resp.Status)
+	}
+
+	data := bytes.Repeat([]byte("daemon "), 300000) // Two chunks
+	resp := call(http.MethodPost, "/v1/uploads?name=raw.bin&wait=true", "application/octet-stream", bytes.NewReader(data))
+	job := decode(resp)
+	if resp.StatusCode != http.StatusCreated || job.State != JobDone || job.CID == "" || job.Uploaded != int64(len(data)) || !job.Encrypted {
+		t.Fatalf("Unexpected raw upload %s %+v", resp.Status, job)
+	}
+	if got := decode(call(http.MethodGet, "/v1/uploads/"+job.ID, "", nil)); got.CID != job.CID {
+		t.Errorf("Job status differs: %+v", got)
+	}
+
+	resp = call(http.MethodGet, "/v1/files/"+job.CID, "", nil)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(body, data) || !strings.Contains(resp.Header.Get("Content-Disposition"), "raw.bin") {
+		t.Errorf("Download returned %d bytes, disposition %q", len(body), resp.Header.Get("Content-Disposition"))
+	}
+
+	var form bytes.Buffer
+	writer := multipart.NewWriter(&form)
+	part, _ := writer.CreateFormFile("file", "form.txt")
+	part.Write([]byte("multipart"))
+	writer.Close()
+	resp = call(http.MethodPost, "/v1/uploads?encrypt=false", writer.FormDataContentType(), &form)
+	job = decode(resp)
+	if resp.StatusCode != http.StatusAccepted || resp.Header.Get("Location") != "/v1/uploads/"+job.ID {
+		t.Errorf("Unexpected async upload %s %+v", resp.Status, job)
+	}
+	for job.State == JobUploading {
+		time.Sleep(10 * time.Millisecond)
+		job = decode(call(http.MethodGet, "/v1/uploads/"+job.ID, "", nil))
+	}
+	if job.State != JobDone || job.Filename != "form.txt" || job.Encrypted {
+		t.Errorf("Unexpected multipart upload %+v", job)
+	}
+	if entries, _ := LoadHistory(history); len(entries) != 2 {
+		t.Errorf("Expected 2 history entries, got %d", len(entries))
+	}
+	if resp := call(http.MethodDelete, "/v1/uploads/"+job.ID, "", nil); resp.StatusCode != http.StatusConflict {
+		t.Errorf("Cancelling a finished upload returned %s", resp.Status)
+	}
+
+	// Cancel an upload while its first chunk is stuck at the node
+	release := make(chan struct{})
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		node.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer stuck.Close()
+	config.SwarmAPI = stuck.URL
+	resp = call(http.MethodPost, "/v1/uploads?name=slow.bin", "", bytes.NewReader(data))
+	job = decode(resp)
+	cancelled := make(chan UploadJob)
+	go func() { cancelled <- decode(call(http.MethodDelete, "/v1/uploads/"+job.ID, "", nil)) }()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	if job := <-cancelled; job.State != JobCancelled || job.CID != "" {
+		t.Errorf("Unexpected cancelled upload %+v", job)
+	}
+}
+
+// countingStore counts the downloads made through a Store
+type countingStore struct {
+	Store
+	downloads atomic.Int64
+}
+
+func (c *countingStore) Download(reference string) ([]byte, error) {
+	c.downloads.Add(1)
+	return c.Store.Download(reference)
+}
+
+func (c *countingStore) ResolveFeed(reference string) (string, bool, error) {
+	return resolveFeed(c.Store, reference)
+}
+
+// feedStore serves the latest target for a feed manifest reference, as Bee's
+// /bzz does
+type feedStore struct {
+	Store
+	feed, target string
+}
+
+func (f *feedStore) Download(reference string) ([]byte, error) {
+	if reference == f.feed {
+		reference = f.target
+	}
+	return f.Store.Download(reference)
+}
+
+func (f *feedStore) ResolveFeed(reference string) (string, bool, error) {
+	if reference == f.feed {
+		return f.target, true, nil
+	}
+	return reference, false, nil
+}
+
+func TestShareHandler(t *testing.T) {
+	node := newFakeSwarm(t)
+	store := &countingStore{Store: Gateway(node.URL)}
+	data := make([]byte, 3500)
+	for i := range data {
+		data[i] = byte(i % 253)
+	}
+	upload := func(size int, encrypt bool, chunkSize int) *Metadata {
+		metadata, err := UploadStream(bytes.NewReader(data[:size]), UploadOptions{Filename: "clip.mp4", Encrypt: encrypt, ChunkSize: chunkSize, Store: store})
+		if err != nil {
+			t.Fatalf("UploadStream failed: %v", err)
+		}
+		return metadata
+	}
+	publish := func(metadata *Metadata) string {
+		cid, err := UploadMetadata(metadata, store)
+		if err != nil {
+			t.Fatalf("UploadMetadata failed: %v", err)
+		}
+		return cid
+	}
+	server := httptest.NewServer(http.StripPrefix("/f", ShareHandler{Store: store}))
+	defer server.Close()
+
+	get := func(path string, header ...string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/f/"+path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		store.downloads.Store(0)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, body
+	}
+
+	perChunk := publish(upload(3500, true, 1000))
+	resp, body := get(perChunk)
+	if resp.StatusCode != http.StatusOK || !bytes.Equal(body, data) || resp.ContentLength != 3500 || resp.Header.Get("Content-Type") != "video/mp4" {
+		t.Errorf("Full GET: %s, %d bytes, type %q", resp.Status, len(body), resp.Header.Get("Content-Type"))
+	}
+	resp, body = get(perChunk, "Range", "bytes=1500-2600")
+	if resp.StatusCode != http.StatusPartialContent || !bytes.Equal(body, data[1500:2601]) || resp.Header.Get("Content-Range") != "bytes 1500-2600/3500" {
+		t.Errorf("Range GET: %s, %d bytes, %q", resp.Status, len(body), resp.Header.Get("Content-Range"))
+	}
+	if n := store.downloads.Load(); n != 3 { // Metadata and chunks 2 and 3
+		t.Errorf("Range GET downloaded %d references, want 3", n)
+	}
+	if resp, body = get(perChunk, "Range", "bytes=-100"); !bytes.Equal(body, data[3400:]) {
+		t.Errorf("Suffix range: %s, %d bytes", resp.Status, len(body))
+	}
+	if resp, _ = get(perChunk, "Range", "bytes=3500-"); resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || resp.Header.Get("Content-Range") != "bytes */3500" {
+		t.Errorf("Unsatisfiable range: %s %q", resp.Status, resp.Header.Get("Content-Range"))
+	}
+	if resp, _ = get(perChunk, "If-None-Match", resp.Header.Get("ETag")); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("Conditional GET: %s", resp.Status)
+	}
+	if cc := resp.Header.Get("Cache-Control"); !strings.Contains(cc, "immutable") {
+		t.Errorf("Expected a content-addressed share to be immutable, got %q", cc)
+	}
+
+	// A feed link is revalidated and tagged with the version it resolves to
+	feeds := &feedStore{Store: store, feed: "feed-manifest", target: perChunk}
+	feedServer := httptest.NewServer(http.StripPrefix("/f", ShareHandler{Store: feeds}))
+	defer feedServer.Close()
+	if resp, err := http.Get(feedServer.URL + "/f/feed-manifest"); err != nil || resp.StatusCode != http.StatusOK {
+		t.Errorf("Feed GET: %v %v", resp, err)
+	} else if resp.Header.Get("ETag") != strconv.Quote(perChunk) || resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Errorf("Feed GET headers: ETag %q, Cache-Control %q", resp.Header.Get("ETag"), resp.Header.Get("Cache-Control"))
+	}
+	node2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/feed") {
+			w.Header().Set(FeedIndexHeader, "0000000000000002")
+			w.Header().Set("ETag", `"resolved"`)
+		}
+	}))
+	defer node2.Close()
+	if resolved, feed, err := Gateway(node2.URL).ResolveFeed("feed"); resolved != "resolved" || !feed || err != nil {
+		t.Errorf("ResolveFeed of a feed: %s %v %v", resolved, feed, err)
+	}
+	if resolved, feed, err := Gateway(node2.URL).ResolveFeed("plain"); resolved != "plain" || feed || err != nil {
+		t.Errorf("ResolveFeed of content: %s %v %v", resolved, feed, err)
+	}
+
+	// Whole-file encryption and plain files serve the same ranges
+	for _, metadata := range []*Metadata{upload(3500, true, 4000), upload(3500, false, 1000)} {
+		if resp, body = get(publish(metadata), "Range", "bytes=10-19"); !bytes.Equal(body, data[10:20]) {
+			t.Errorf("Range of %s share: %s %q", metadata.EncryptionScheme(), resp.Status, body)
+		}
+	}
+
+	// The key may be kept out of the metadata and passed with the request
+	metadata := upload(3500, true, 1000)
+	key, _ := base64.StdEncoding.DecodeString(metadata.Key)
+	metadata.Key = ""
+	secret := publish(metadata)
+	if resp, _ = get(secret); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("GET without key: %s", resp.Status)
+	}
+	if resp, body = get(secret + "?key=" + base64.RawURLEncoding.EncodeToString(key)); !bytes.Equal(body, data) {
+		t.Errorf("GET with key in query: %s", resp.Status)
+	}
+	if resp, body = get(secret, KeyHeader, base64.StdEncoding.EncodeToString(key), "Range", "bytes=0-9"); !bytes.Equal(body, data[:10]) {
+		t.Errorf("GET with key header: %s", resp.Status)
+	}
+}
+
+func TestFile(t *testing.T) {
+	node := newFakeSwarm(t)
+	store := &countingStore{Store: Gateway(node.URL)}
+	data := make([]byte, 3500)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+
+	for _, encrypt := range []bool{false, true} {
+		metadata, err := UploadStream(bytes.NewReader(data), UploadOptions{Filename: "records.db", Encrypt: encrypt, ChunkSize: 1000, Store: store})
+		if err != nil {
+			t.Fatalf("UploadStream failed: %v", err)
+		}
+		cid, _ := UploadMetadata(metadata, store)
+		f, err := OpenFile(cid, store)
+		if err != nil {
+			t.Fatalf("OpenFile failed: %v", err)
+		}
+		f.SetCacheSize(2)
+
+		store.downloads.Store(0)
+		buf := make([]byte, 200)
+		if n, err := f.ReadAt(buf, 900); n != 200 || err != nil || !bytes.Equal(buf, data[900:1100]) {
+			t.Errorf("ReadAt across chunks 1 and 2: %d, %v", n, err)
+		}
+		if n, err := f.ReadAt(buf[:50], 1000); n != 50 || err != nil || store.downloads.Load() != 2 {
+			t.Errorf("Cached ReadAt: %d, %v, %d downloads", n, err, store.downloads.Load())
+		}
+		f.ReadAt(buf[:1], 3000) // Evicts chunk 1
+		f.ReadAt(buf[:1], 0)
+		if store.downloads.Load() != 4 {
+			t.Errorf("Expected chunk 1 to be evicted, %d downloads", store.downloads.Load())
+		}
+		if n, err := f.ReadAt(buf, 3400); n != 100 || err != io.EOF || !bytes.Equal(buf[:100], data[3400:]) {
+			t.Errorf("ReadAt at the end: %d, %v", n, err)
+		}
+
+		if pos, err := f.Seek(-500, io.SeekEnd); pos != 3000 || err != nil {
+			t.Errorf("Seek: %d, %v", pos, err)
+		}
+		rest, err := io.ReadAll(f)
+		if err != nil || !bytes.Equal(rest, data[3000:]) {
+			t.Errorf("Read after Seek: %d bytes, %v", len(rest), err)
+		}
+		f.Close()
+	}
+
+	// Files encrypted as a whole can only be read from the start
+	whole := &Metadata{Filename: "old.bin", Size: 10, Encrypted: true, Key: base64.StdEncoding.EncodeToString(make([]byte, 32)), Chunked: true, ChunkSize: 5,
+		ChunkIDs: map[string]string{"1": "a", "2": "b"}, ChunkHashes: map[string]string{"1": "a", "2": "b"}}
+	if _, err := NewFile(whole, store); !errors.Is(err, ErrNotAddressable) {
+		t.Errorf("Expected ErrNotAddressable, got %v", err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	entry := int64(100 + sha256.Size)
+	cache, err := OpenCache(t.TempDir(), 3*entry)
+	if err != nil {
+		t.Fatalf("OpenCache failed: %v", err)
+	}
+	refs := []string{strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64), strings.Repeat("d", 64)}
+	payload := func(i int) []byte { return bytes.Repeat([]byte{byte(i)}, 100) }
+	age := func(ref string, d time.Duration) {
+		old := time.Now().Add(-d)
+		os.Chtimes(cache.path(ref), old, old)
+	}
+
+	for i, ref := range refs[:3] {
+		if err := cache.Put(ref, payload(i)); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		age(ref, time.Duration(3-i)*time.Hour)
+	}
+	if data, ok := cache.Get(refs[0]); !ok || !bytes.Equal(data, payload(0)) {
+		t.Fatalf("Get returned %v, %v", len(data), ok)
+	}
+	if err := cache.Put("not a reference", nil); err == nil {
+		t.Error("Expected an invalid reference to be rejected")
+	}
+
+	// Over the quota the least recently used entry goes, which is b since a was just read
+	cache.Put(refs[3], payload(3))
+	for i, ref := range refs {
+		if _, ok := cache.Get(ref); ok != (i != 1) {
+			t.Errorf("Entry %d cached: %v", i, ok)
+		}
+	}
+	if stats, err := cache.Stats(); err != nil || stats.Entries != 3 || stats.Bytes != 3*entry {
+		t.Errorf("Stats: %+v, %v", stats, err)
+	}
+
+	// Corrupt entries are dropped on read and by Prune, along with expired ones
+	os.WriteFile(cache.path(refs[0]), []byte("garbage"), 0600)
+	if _, ok := cache.Get(refs[0]); ok {
+		t.Error("Corrupt entry was returned")
+	}
+	if _, err := os.Stat(cache.path(refs[0])); !os.IsNotExist(err) {
+		t.Error("Corrupt entry was not removed")
+	}
+	os.WriteFile(cache.path(refs[3]), []byte("garbage"), 0600)
+	age(refs[2], 48*time.Hour)
+	if result, err := cache.Prune(24 * time.Hour); err != nil || result.Corrupt != 1 || result.Expired != 1 || result.Evicted != 0 {
+		t.Errorf("Prune: %+v, %v", result, err)
+	}
+	cache.Put(refs[0], payload(0))
+	if freed, err := cache.Clear(); err != nil || freed != entry {
+		t.Errorf("Clear freed %d, %v", freed, err)
+	}
+
+	// A CachedStore serves repeat downloads locally
+	node := newFakeSwarm(t)
+	store := &countingStore{Store: Gateway(node.URL)}
+	data := make([]byte, 2500)
+	for i := range data {
+		data[i] = byte(i % 249)
+	}
+	metadata, err := UploadStream(bytes.NewReader(data), UploadOptions{Filename: "set.bin", Encrypt: true, ChunkSize: 1000, Store: store})
+	if err != nil {
+		t.Fatalf("UploadStream failed: %v", err)
+	}
+	cid, _ := UploadMetadata(metadata, store)
+	cache, _ = OpenCache(t.TempDir(), 0)
+	cached := NewCachedStore(store, cache)
+	for round := 0; round < 2; round++ {
+		store.downloads.Store(0)
+		metadata, err := FetchMetadata(cid, cached)
+		if err != nil {
+			t.Fatalf("FetchMetadata failed: %v", err)
+		}
+		var buf bytes.Buffer
+		if _, err := DownloadStream(metadata, cached, &buf, nil); err != nil || !bytes.Equal(buf.Bytes(), data) {
+			t.Fatalf("DownloadStream round %d: %v", round, err)
+		}
+		// Only the metadata is fetched again; it could be a feed that moved on
+		if want := []int64{4, 1}[round]; store.downloads.Load() != want {
+			t.Errorf("Round %d made %d downloads, want %d", round, store.downloads.Load(), want)
+		}
+	}
+	if _, ok := cache.Get(cid); ok {
+		t.Error("Expected plain downloads to bypass the cache")
+	}
+
+	// A cached piece that does not match its hash is fetched again
+	piece := metadata.Pieces()[0]
+	cache.Put(piece.Reference, []byte("stale"))
+	if got, err := cached.(*CachedStore).DownloadVerified(piece.Reference, piece.Hash); err != nil || checkHash(got, piece.Hash) != nil {
+		t.Errorf("DownloadVerified with a stale entry: %v", err)
+	}
+	if NewCachedStore(store, nil) != Store(store) {
+		t.Error("Expected a nil cache to leave the store unwrapped")
+	}
+}
+
+func TestContentDefinedChunking(t *testing.T) {
+	data := make([]byte, 64*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	split := func(data []byte) [][]byte {
+		var chunks [][]byte
+		c := NewContentChunker(bytes.NewReader(data), 1024)
+		for {
+			chunk, err := c.Next()
+			if err == io.EOF {
+				return chunks
+			}
+			if err != nil {
+				t.Fatalf("Next failed: %v", err)
+			}
+			chunks = append(chunks, bytes.Clone(chunk))
+		}
+	}
+
+	chunks := split(data)
+	if got := bytes.Join(chunks, nil); !bytes.Equal(got, data) {
+		t.Fatal("Chunks do not add up to the input")
+	}
+	for i, c := range chunks[:len(chunks)-1] {
+		if len(c) < 256 || len(c) > 4096 {
+			t.Errorf("Chunk %d has %d bytes, want 256-4096", i, len(c))
+		}
+	}
+	if len(chunks) < 32 || len(chunks) > 128 {
+		t.Errorf("Got %d chunks for 64 KB at a 1 KB average", len(chunks))
+	}
+
+	// An insertion only changes the chunks around it
+	edited := append(append(bytes.Clone(data[:30000]), "inserted"...), data[30000:]...)
+	seen := make(map[string]bool)
+	for _, c := range chunks {
+		seen[string(c)] = true
+	}
+	changed := 0
+	for _, c := range split(edited) {
+		if !seen[string(c)] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Errorf("Insertion changed %d chunks, want at most 2", changed)
+	}
+
+	// A new version reuses the stored pieces of the old one
+	node := newFakeSwarm(t)
+	store := Gateway(node.URL)
+	dedupKey := bytes.Repeat([]byte{7}, 32)
+	upload := func(data []byte, opts UploadOptions) (*Metadata, int) {
+		t.Helper()
+		reused := 0
+		opts.Filename, opts.Encrypt, opts.ChunkSize, opts.Store, opts.Chunking = "disk.img", true, 1024, store, ChunkingContentDefined
+		opts.OnReuse = func(int64) { reused++ }
+		metadata, err := UploadStream(bytes.NewReader(data), opts)
+		if err != nil {
+			t.Fatalf("UploadStream failed: %v", err)
+		}
+		if err := metadata.Validate(); err != nil {
+			t.Fatalf("Invalid metadata: %v", err)
+		}
+		return metadata, reused
+	}
+	v1, _ := upload(data, UploadOptions{DedupKey: dedupKey})
+	v2, reused := upload(edited, UploadOptions{DedupKey: dedupKey, Known: KnownPieces(v1)})
+	if v1.EncryptionMode != EncryptionKeyed || v1.SchemaVersion() != 3 || v1.ChunkingScheme() != SchemeContentDefined {
+		t.Errorf("Unexpected metadata: mode %q, version %d, %s", v1.EncryptionMode, v1.SchemaVersion(), v1.ChunkingScheme())
+	}
+	if reused < v2.ChunkCount()-2 {
+		t.Errorf("Reused %d of %d pieces", reused, v2.ChunkCount())
+	}
+	if v1.Key == v2.Key {
+		t.Error("Versions should have their own file keys")
+	}
+
+	var buf bytes.Buffer
+	if _, err := DownloadStream(v2, store, &buf, nil); err != nil || !bytes.Equal(buf.Bytes(), edited) {
+		t.Fatalf("DownloadStream: %v", err)
+	}
+	f, err := NewFile(v2, store)
+	if err != nil {
+		t.Fatalf("NewFile failed: %v", err)
+	}
+	part := make([]byte, 5000)
+	if _, err := f.ReadAt(part, 28000); err != nil || !bytes.Equal(part, edited[28000:33000]) {
+		t.Errorf("ReadAt across content-defined chunks: %v", err)
+	}
+
+	// Convergent keys match across uploaders, keyed ones only under the same key
+	c1, _ := upload(data, UploadOptions{})
+	c2, _ := upload(data, UploadOptions{})
+	other, _ := upload(data, UploadOptions{DedupKey: bytes.Repeat([]byte{8}, 32)})
+	if c1.EncryptionMode != EncryptionConvergent || c1.ChunkHashes["1"] != c2.ChunkHashes["1"] {
+		t.Error("Convergent uploads of the same data should store the same pieces")
+	}
+	if other.ChunkHashes["1"] == v1.ChunkHashes["1"] || other.ChunkHashes["1"] == c1.ChunkHashes["1"] {
+		t.Error("Keyed uploads should not match other keys")
+	}
+
+	// Pieces with derived keys can be rebuilt from a local copy
+	cid, _ := UploadMetadata(v2, store)
+	document, _ := store.Download(cid)
+	if _, err := ReuploadFromFile(cid, document, bytes.NewReader(edited), store, nil); err != nil {
+		t.Errorf("ReuploadFromFile failed: %v", err)
+	}
+	if _, err := ReuploadFromFile(cid, document, bytes.NewReader(data), store, nil); !errors.Is(err, ErrIntegrity) {
+		t.Errorf("Expected a different copy to be rejected, got %v", err)
+	}
+
+	// Empty input has no chunks and is stored as a single piece
+	if empty, _ := upload(nil, UploadOptions{}); empty.Chunked || empty.Size != 0 {
+		t.Errorf("Empty upload: chunked %v, size %d", empty.Chunked, empty.Size)
+	}
+
+	broken := *v2
+	broken.ChunkLengths = map[string]int{"1": 1}
+	if err := broken.Validate(); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("Expected missing chunk lengths to be rejected, got %v", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	filter := PathFilter{Exclude: []string{"*.tmp", "build/cache"}}
+	for p, want := range map[string]bool{
+		"a.txt": true, "x.tmp": false, "docs/x.tmp": false, "build/cache/o": false, "build/out": true, "src/build/cache/o": true,
+	} {
+		if filter.Match(p) != want {
+			t.Errorf("Match(%q) = %v, want %v", p, !want, want)
+		}
+	}
+	if !(PathFilter{Include: []string{"/notes.txt", "docs"}}).Match("docs/a/b.pdf") || (PathFilter{Include: []string{"/notes.txt"}}).Match("a/notes.txt") {
+		t.Error("Unexpected include matching")
+	}
+	if (PathFilter{Exclude: []string{"[a-"}}).Validate() == nil {
+		t.Error("Expected an invalid pattern to be rejected")
+	}
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a.txt", "alpha")
+	write("docs/b.txt", "bravo")
+	write("docs/skip.tmp", "temporary")
+	write("gone.txt", "going away")
+
+	node := newFakeSwarm(t)
+	store := Gateway(node.URL)
+	statePath := filepath.Join(dir, SyncStateName)
+	run := func(want map[string]string) *SyncResult {
+		t.Helper()
+		state, err := LoadSyncState(statePath)
+		if err != nil {
+			t.Fatalf("LoadSyncState failed: %v", err)
+		}
+		changes, err := PlanSync(dir, state, filter, statePath)
+		if err != nil {
+			t.Fatalf("PlanSync failed: %v", err)
+		}
+		got := make(map[string]string)
+		for _, c := range changes {
+			got[c.Entry.Path] = c.Action
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("Planned %v, want %v", got, want)
+		}
+		result, err := Sync(dir, state, changes, SyncOptions{Name: "shared", Upload: UploadOptions{ChunkSize: 4, Store: store}})
+		if err != nil || result.Failed != 0 {
+			t.Fatalf("Sync failed: %v %+v", err, result)
+		}
+		if err := SaveSyncState(statePath, state); err != nil {
+			t.Fatalf("SaveSyncState failed: %v", err)
+		}
+		return result
+	}
+
+	first := run(map[string]string{"a.txt": SyncAdd, "docs/b.txt": SyncAdd, "gone.txt": SyncAdd})
+	if first.Uploaded != 20 || first.Directory.Previous != "" {
+		t.Errorf("Unexpected first sync %+v", first)
+	}
+
+	// Touching a file without changing it uploads nothing
+	later := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "a.txt"), later, later)
+	write("docs/b.txt", "bravo two")
+	os.Remove(filepath.Join(dir, "gone.txt"))
+	write("c.txt", "charlie")
+	second := run(map[string]string{"a.txt": SyncUnchanged, "docs/b.txt": SyncUpdate, "gone.txt": SyncDelete, "c.txt": SyncAdd})
+	if second.Uploaded != 16 {
+		t.Errorf("Second sync uploaded %d bytes, want 16", second.Uploaded)
+	}
+
+	d, err := FetchDirectory(second.Manifest, store)
+	if err != nil {
+		t.Fatalf("FetchDirectory failed: %v", err)
+	}
+	if d.Previous != first.Manifest || d.Name != "shared" || len(d.Files) != 3 || d.Files[2].Path != "docs/b.txt" || d.Size() != 21 {
+		t.Fatalf("Unexpected manifest %+v", d)
+	}
+	data, _ := store.Download(second.Manifest)
+	if !IsDirectory(data) {
+		t.Error("Expected the manifest to be recognized as a directory")
+	}
+	metadata, err := FetchMetadata(d.Files[2].Reference, store)
+	if err != nil {
+		t.Fatalf("FetchMetadata failed: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := DownloadStream(metadata, store, &buf, nil); err != nil || buf.String() != "bravo two" {
+		t.Errorf("Downloaded %q, %v", buf.String(), err)
+	}
+
+	run(map[string]string{"a.txt": SyncUnchanged, "docs/b.txt": SyncUnchanged, "c.txt": SyncUnchanged})
+
+	bad := &Directory{Type: DirectoryType, Files: []DirEntry{{Path: "../x", Reference: "ref"}}}
+	if err := bad.Validate(); !errors.Is(err, ErrInvalidMetadata) {
+		t.Errorf("Expected an unsafe path to be rejected, got %v", err)
+	}
+}
+
+func TestWatchFolder(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "old.txt"), []byte("already here"), 0o644)
+	// Sidecars of an earlier run are skipped, other .link files are not
+	os.WriteFile(filepath.Join(dir, "old.txt.link"), []byte("link"), 0o644)
+	os.WriteFile(filepath.Join(dir, "bookmark.link"), []byte("not a sidecar"), 0o644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ready := make(chan string, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- WatchFolder(ctx, dir, WatchOptions{
+			Filter:   PathFilter{Exclude: []string{"*.part"}},
+			Quiet:    300 * time.Millisecond,
+			Existing: true,
+			Sidecar:  ".link",
+			OnReady: func(p string) {
+				ready <- filepath.Base(p)
+				os.WriteFile(p+".link", []byte("link"), 0o644)
+			},
+		})
+	}()
+	next := func() string {
+		select {
+		case name := <-ready:
+			return name
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for a file")
+			return ""
+		}
+	}
+	if existing := []string{next(), next()}; !slices.Contains(existing, "old.txt") || !slices.Contains(existing, "bookmark.link") {
+		t.Fatalf("Expected the existing files first, got %v", existing)
+	}
+
+	// A file still being written is only reported once it stops changing
+	started := time.Now()
+	f, _ := os.Create(filepath.Join(dir, "shot.png"))
+	os.WriteFile(filepath.Join(dir, "shot.png.part"), []byte("partial"), 0o644)
+	for i := 0; i < 4; i++ {
+		f.Write([]byte("data"))
+		time.Sleep(100 * time.Millisecond)
+	}
+	f.Close()
+	if name := next(); name != "shot.png" || time.Since(started) < 700*time.Millisecond {
+		t.Errorf("Got %s after %s", name, time.Since(started))
+	}
+	select {
+	case name := <-ready:
+		t.Errorf("Unexpected file %s", name)
+	case <-time.After(1500 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("WatchFolder returned %v", err)
+	}
+	if err := WatchFolder(context.Background(), filepath.Join(dir, "missing"), WatchOptions{}); err == nil {
+		t.Error("Expected an error for a missing folder")
+	}
+}
+
+func TestRestoreDirectory(t *testing.T) {
+	for _, c := range []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"logs/**", "logs/a/b.txt", true},
+		{"logs/**/*.txt", "logs/a/b/c.txt", true},
+		{"logs/**/*.txt", "logs/c.txt", true},
+		{"logs/**/*.txt", "logs/a/c.log", false},
+		{"**/cache", "a/b/cache/x", true},
+		{"logs/**", "src/logs/x", false},
+	} {
+		if got := matchPath(c.pattern, c.path); got != c.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", c.pattern, c.path, got, c.want)
+		}
+	}
+
+	src := t.TempDir()
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for name, content := range map[string]string{"logs/app.log": "started", "logs/old.tmp": "scratch", "logs/run/x.log": "deep", "readme.md": "hello"} {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		os.WriteFile(p, []byte(content), 0o640)
+		os.Chtimes(p, mtime, mtime)
+	}
+	node := newFakeSwarm(t)
+	store := Gateway(node.URL)
+	state := &SyncState{Files: map[string]DirEntry{}}
+	changes, err := PlanSync(src, state, PathFilter{}, "")
+	if err != nil {
+		t.Fatalf("PlanSync failed: %v", err)
+	}
+	synced, err := Sync(src, state, changes, SyncOptions{Name: "src", Upload: UploadOptions{Encrypt: true, ChunkSize: 3, Store: store}})
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	dest := t.TempDir()
+	filter := PathFilter{Include: []string{"logs/**"}, Exclude: []string{"*.tmp"}}
+	status := func(results []RestoredFile) map[string]string {
+		got := make(map[string]string)
+		for _, r := range results {
+			got[r.Entry.Path] = r.Status
+		}
+		return got
+	}
+	results := RestoreDirectory(synced.Directory, dest, RestoreOptions{Filter: filter, Store: store})
+	want := map[string]string{"logs/app.log": RestoreVerified, "logs/old.tmp": RestoreSkipped, "logs/run/x.log": RestoreVerified, "readme.md": RestoreSkipped}
+	if fmt.Sprint(status(results)) != fmt.Sprint(want) {
+		t.Fatalf("Restored %v, want %v", status(results), want)
+	}
+	info, err := os.Stat(filepath.Join(dest, "logs", "run", "x.log"))
+	if err != nil || !info.ModTime().Equal(mtime) || info.Mode().Perm() != 0o640 {
+		t.Errorf("Unexpected attributes %v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(dest, "readme.md")); !os.IsNotExist(err) {
+		t.Error("Expected readme.md to be filtered out")
+	}
+
+	// Files already restored are not downloaded again; a wrong hash fails
+	d := *synced.Directory
+	d.Files = append([]DirEntry{}, d.Files...)
+	for i := range d.Files {
+		if d.Files[i].Path == "logs/run/x.log" {
+			d.Files[i].Hash = strings.Repeat("0", 64)
+			os.Remove(filepath.Join(dest, "logs", "run", "x.log"))
+		}
+	}
+	results = RestoreDirectory(&d, dest, RestoreOptions{Filter: filter, Store: store})
+	for _, r := range results {
+		switch r.Entry.Path {
+		case "logs/app.log":
+			if r.Status != RestoreSkipped || r.Reason != "up to date" {
+				t.Errorf("Expected app.log to be up to date, got %+v", r)
+			}
+		case "logs/run/x.log":
+			if r.Status != RestoreFailed || !errors.Is(r.Err, ErrIntegrity) {
+				t.Errorf("Expected a hash mismatch, got %+v", r)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dest, "logs", "run", "x.log")); !os.IsNotExist(err) {
+		t.Error("Expected no file for the failed download")
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dest, "logs", "run")); len(entries) != 0 {
+		t.Errorf("Expected no leftover temporary files, got %v", entries)
+	}
+}
+
+// testFork is a fork of a mantaray node built by encodeMantaray
+type testFork struct {
+	prefix   string
+	flags    byte
+	node     []byte // Encoded node the fork points to
+	metadata string
+}
+
+// encodeMantaray encodes a manifest node the way Bee does
+func encodeMantaray(key, entry []byte, forks ...testFork) []byte {
+	node := append(append(append([]byte{}, key...), mantarayVersion...), 32)
+	node = append(node, append(entry, make([]byte, 32-len(entry))...)...)
+	index := make([]byte, 32)
+	for _, f := range forks {
+		index[f.prefix[0]/8] |= 1 << (f.prefix[0] % 8)
+	}
+	node = append(node, index...)
+	for _, f := range forks {
+		header := make([]byte, mantarayForkSize)
+		header[0], header[1] = f.flags, byte(len(f.prefix))
+		copy(header[2:], f.prefix)
+		node = append(append(node, header...), swarmAddress(f.node)...)
+		if f.metadata != "" {
+			node = binary.BigEndian.AppendUint16(node, uint16(len(f.metadata)))
+			node = append(node, f.metadata...)
+		}
+	}
+	for i := mantarayKeySize; i < len(node); i++ {
+		node[i] ^= key[i%mantarayKeySize]
+	}
+	return node
+}
+
+func TestBeeCollection(t *testing.T) {
+	// A single chunk is carried up as the root; 129 chunks need two levels
+	small := []byte("hello")
+	if got, want := swarmAddress(small), chunkAddress(5, small); !bytes.Equal(got, want) {
+		t.Errorf("Single chunk address %x, want %x", got, want)
+	}
+	large := bytes.Repeat([]byte{7}, 129*SwarmChunkSize)
+	leaf := chunkAddress(SwarmChunkSize, large[:SwarmChunkSize])
+	full := chunkAddress(128*SwarmChunkSize, bytes.Repeat(leaf, 128))
+	level := chunkAddress(uint64(len(large)), append(append([]byte{}, full...), leaf...))
+	if got := swarmAddress(large); !bytes.Equal(got, level) {
+		t.Errorf("Tree address %x, want %x", got, level)
+	}
+
+	files := map[string][]byte{
+		"a.txt":        []byte("alpha"),
+		"logs/big.log": bytes.Repeat([]byte("log line\n"), 600), // Two chunks
+		"logs/x.tmp":   []byte("scratch"),
+	}
+	bytesStore, chunkStore := map[string][]byte{}, map[string][]byte{}
+	put := func(data []byte) []byte {
+		address := swarmAddress(data)
+		bytesStore[hex.EncodeToString(address)] = data
+		return address
+	}
+	key := bytes.Repeat([]byte{0x5a}, mantarayKeySize)
+	leafNode := func(name string) testFork {
+		data := files[name]
+		address := put(data)
+		root := binary.LittleEndian.AppendUint64(nil, uint64(len(data)))
+		if len(data) <= SwarmChunkSize {
+			root = append(root, data...)
+		} else {
+			root = append(root, chunkAddress(SwarmChunkSize, data[:SwarmChunkSize])...)
+			root = append(root, chunkAddress(uint64(len(data)-SwarmChunkSize), data[SwarmChunkSize:])...)
+		}
+		chunkStore[hex.EncodeToString(address)] = root
+		return testFork{flags: mantarayValue | mantarayMetadata, node: encodeMantaray(key, address), metadata: `{"Content-Type":"text/plain"}` + "\n\n"}
+	}
+	fork := func(prefix string, f testFork) testFork {
+		f.prefix = prefix
+		put(f.node)
+		return f
+	}
+	logs := encodeMantaray(key, nil, fork("big.log", leafNode("logs/big.log")), fork("x.tmp", leafNode("logs/x.tmp")))
+	root := encodeMantaray(key, nil,
+		fork("/", testFork{flags: mantarayValue | mantarayMetadata, node: encodeMantaray(key, nil), metadata: `{"website-index-document":"a.txt"}`}),
+		fork("a.txt", leafNode("a.txt")),
+		fork("logs/", testFork{flags: 4, node: logs}))
+	collection := hex.EncodeToString(put(root))
+
+	var tampered atomic.Bool
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		api, ref, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		var data []byte
+		var ok bool
+		switch api {
+		case "bytes":
+			data, ok = bytesStore[ref]
+			if ok && tampered.Load() && bytes.Equal(data, files["a.txt"]) {
+				data = []byte("alphb")
+			}
+		case "chunks":
+			data, ok = chunkStore[ref]
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer node.Close()
+	store := NewPool([]string{node.URL}, StrategyFailover, 0)
+
+	d, data, err := FetchShare(collection, store)
+	if err != nil || d == nil || data != nil {
+		t.Fatalf("FetchShare did not read the collection: %v", err)
+	}
+	var listed []string
+	for _, f := range d.Files {
+		listed = append(listed, fmt.Sprintf("%s:%d", f.Path, f.Size))
+	}
+	if want := "[a.txt:5 logs/big.log:5400 logs/x.tmp:7]"; fmt.Sprint(listed) != want || d.Type != CollectionType {
+		t.Fatalf("Listed %v, want %s", listed, want)
+	}
+	if _, err := FetchCollection(hex.EncodeToString(put([]byte("just a file"))), store); !errors.Is(err, errNotCollection) {
+		t.Errorf("Expected a plain file not to be a collection, got %v", err)
+	}
+
+	dest := t.TempDir()
+	filter := PathFilter{Exclude: []string{"*.tmp"}}
+	results := RestoreDirectory(d, dest, RestoreOptions{Filter: filter, Store: store})
+	for _, r := range results {
+		if want := map[string]string{"a.txt": RestoreVerified, "logs/big.log": RestoreVerified, "logs/x.tmp": RestoreSkipped}[r.Entry.Path]; r.Status != want {
+			t.Errorf("%s: got %s (%v), want %s", r.Entry.Path, r.Status, r.Err, want)
+		}
+	}
+	if got, _ := os.ReadFile(filepath.Join(dest, "logs", "big.log")); !bytes.Equal(got, files["logs/big.log"]) {
+		t.Error("Restored big.log does not match")
+	}
+
+	// Restored files are recognised by their Swarm reference; tampered content fails
+	tampered.Store(true)
+	os.Remove(filepath.Join(dest, "a.txt"))
+	for _, r := range RestoreDirectory(d, dest, RestoreOptions{Filter: filter, Store: store}) {
+		switch r.Entry.Path {
+		case "logs/big.log":
+			if r.Status != RestoreSkipped || r.Reason != "up to date" {
+				t.Errorf("Expected big.log to be up to date, got %+v", r)
+			}
+		case "a.txt":
+			if r.Status != RestoreFailed || !errors.Is(r.Err, ErrIntegrity) {
+				t.Errorf("Expected an address mismatch, got %+v", r)
+			}
+		}
+	}
+
+	// A final-ride share is returned as metadata, not read as a collection
+	share := Gateway(newFakeSwarm(t).URL)
+	metadata, err := UploadStream(strings.NewReader("single file"), UploadOptions{Filename: "one.txt", ChunkSize: 4, Store: share})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cid, err := UploadMetadata(metadata, share)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, data, err = FetchShare(cid, share)
+	if err != nil || d != nil {
+		t.Fatalf("FetchShare(share) = %v, %v", d, err)
+	}
+	if parsed, err := ParseMetadata(data); err != nil || parsed.Filename != "one.txt" {
+		t.Errorf("Expected the share's metadata, got %v", err)
+	}
+}
@@ -0,0 +1,162 @@
+package finalride
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// History actions
+const (
+	HistoryUpload   = "upload"
+	HistoryDownload = "download"
+)
+
+// Key handling modes recorded in the history
+const (
+	KeyEmbedded = "embedded" // Key stored inside the metadata document
+	KeyNone     = "none"     // File was not encrypted
+)
+
+// HistoryEntry represents a single upload or download in the local history
+type HistoryEntry struct {
+	Time        time.Time `json:"time"`
+	Action      string    `json:"action"` // "upload" or "download"
+	Filename    string    `json:"filename"`
+	Size        int64     `json:"size"`
+	CID         string    `json:"cid"` // Metadata reference
+	Encrypted   bool      `json:"encrypted"`
+	KeyHandling string    `json:"key_handling"` // How the encryption key was handled
+	Gateway     string    `json:"gateway"`      // Swarm API used for the transfer
+	Link        string    `json:"link,omitempty"`
+	Feed        string    `json:"feed,omitempty"` // Feed name and version the upload was published as, e.g. "nightly#3"
+}
+
+// HistoryFilter selects entries from the history
+type HistoryFilter struct {
+	Action string    // Only entries with this action (empty for all)
+	Query  string    // Case-insensitive match against filename, CID or feed
+	Since  time.Time // Only entries at or after this time
+	Limit  int       // Maximum number of entries (0 for no limit)
+}
+
+// ConfigDir returns the per-user directory for Final Ride data
+func ConfigDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate user config dir: %v", err)
+	}
+	return filepath.Join(dir, "final-ride"), nil
+}
+
+// DefaultHistoryPath returns the location of the history file
+func DefaultHistoryPath() (string, error) {
+	dir, err := ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// KeyHandlingFor returns the key handling mode recorded for metadata
+func KeyHandlingFor(metadata *Metadata) string {
+	if !metadata.Encrypted {
+		return KeyNone
+	}
+	return KeyEmbedded
+}
+
+// AppendHistory appends an entry to the JSON-lines history file
+func AppendHistory(historyPath string, entry HistoryEntry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal history entry: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(historyPath), 0755); err != nil {
+		return fmt.Errorf("failed to create history dir: %v", err)
+	}
+
+	f, err := os.OpenFile(historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history file: %v", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write history file: %v", err)
+	}
+	return nil
+}
+
+// LoadHistory reads all entries from the history file, oldest first.
+// A missing file yields an empty history; malformed lines are skipped.
+func LoadHistory(historyPath string) ([]HistoryEntry, error) {
+	f, err := os.Open(historyPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file: %v", err)
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry HistoryEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file: %v", err)
+	}
+	return entries, nil
+}
+
+// FilterHistory returns the matching entries, newest first
+func FilterHistory(entries []HistoryEntry, filter HistoryFilter) []HistoryEntry {
+	query := strings.ToLower(filter.Query)
+
+	var result []HistoryEntry
+	for _, entry := range entries {
+		if filter.Action != "" && entry.Action != filter.Action {
+			continue
+		}
+		if !filter.Since.IsZero() && entry.Time.Before(filter.Since) {
+			continue
+		}
+		if query != "" &&
+			!strings.Contains(strings.ToLower(entry.Filename), query) &&
+			!strings.Contains(strings.ToLower(entry.CID), query) &&
+			!strings.Contains(strings.ToLower(entry.Feed), query) {
+			continue
+		}
+		result = append(result, entry)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.After(result[j].Time)
+	})
+
+	if filter.Limit > 0 && len(result) > filter.Limit {
+		result = result[:filter.Limit]
+	}
+	return result
+}
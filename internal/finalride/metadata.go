@@ -0,0 +1,323 @@
+package finalride
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MetadataVersion is the newest metadata schema version this package understands.
+// Version 2 adds per-chunk encryption and version 3 content-defined chunking;
+// documents not using them are still written with the older version so older
+// clients can read them.
+const MetadataVersion = 3
+
+// Encryption modes of chunked files
+const (
+	// EncryptionPerChunk marks metadata whose chunks are encrypted individually,
+	// which allows files to be encrypted and decrypted as a stream
+	EncryptionPerChunk = "per-chunk"
+
+	// EncryptionConvergent and EncryptionKeyed mark content-defined chunks
+	// encrypted under keys derived from their content (see DeriveChunkKey), so
+	// that unchanged chunks keep their references across uploads
+	EncryptionConvergent = "convergent"
+	EncryptionKeyed      = "keyed"
+)
+
+// Chunking modes
+const (
+	ChunkingFixed          = "fixed"
+	ChunkingContentDefined = "content-defined"
+)
+
+// ErrInvalidMetadata is returned when a metadata document is malformed or unsafe
+var ErrInvalidMetadata = errors.New("invalid metadata")
+
+// Encryption and chunking schemes reported for metadata
+const (
+	SchemeNone           = "none"
+	SchemeAESGCM         = "AES-256-GCM"
+	SchemeAESGCMPerChunk = "AES-256-GCM per-chunk"
+	SchemeAESGCMDerived  = "AES-256-GCM content-derived keys"
+	SchemeFixedSize      = "fixed-size"
+	SchemeContentDefined = "content-defined"
+)
+
+// ParseReference extracts a metadata reference from a CID or a shareable link
+func ParseReference(input string) string {
+	input = strings.TrimSpace(input)
+	if !strings.Contains(input, "download=") {
+		return input
+	}
+
+	if u, err := url.Parse(input); err == nil {
+		if cid := u.Query().Get("download"); cid != "" {
+			return cid
+		}
+	}
+
+	// Fall back to plain string extraction for malformed links
+	parts := strings.SplitN(input, "download=", 2)
+	return strings.Split(parts[1], "&")[0]
+}
+
+// FetchMetadata downloads, parses and validates a metadata document
+func FetchMetadata(reference string, store Store) (*Metadata, error) {
+	data, err := store.Download(reference)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMetadata(data)
+}
+
+// ParseMetadata parses and validates a metadata document
+func ParseMetadata(data []byte) (*Metadata, error) {
+	var metadata Metadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	if err := metadata.Validate(); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// Validate checks that the metadata is complete and safe to act on
+func (m *Metadata) Validate() error {
+	if m.Filename == "" {
+		return fmt.Errorf("%w: missing filename", ErrInvalidMetadata)
+	}
+	if strings.ContainsAny(m.Filename, `/\`) || m.Filename == "." || m.Filename == ".." {
+		return fmt.Errorf("%w: unsafe filename %q", ErrInvalidMetadata, m.Filename)
+	}
+	if m.SchemaVersion() > MetadataVersion {
+		return fmt.Errorf("%w: unsupported schema version %d (max %d)", ErrInvalidMetadata, m.SchemaVersion(), MetadataVersion)
+	}
+	if m.Size < 0 || m.ChunkSize < 0 {
+		return fmt.Errorf("%w: negative size", ErrInvalidMetadata)
+	}
+
+	if m.Encrypted {
+		key, err := base64.StdEncoding.DecodeString(m.Key)
+		if err != nil {
+			return fmt.Errorf("%w: malformed encryption key: %v", ErrInvalidMetadata, err)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("%w: encryption key is %d bytes, want 32", ErrInvalidMetadata, len(key))
+		}
+	}
+
+	switch m.EncryptionMode {
+	case "":
+	case EncryptionPerChunk:
+		if !m.Encrypted || !m.Chunked {
+			return fmt.Errorf("%w: per-chunk encryption requires an encrypted, chunked file", ErrInvalidMetadata)
+		}
+	case EncryptionConvergent, EncryptionKeyed:
+		if !m.Encrypted || m.Chunking != ChunkingContentDefined {
+			return fmt.Errorf("%w: %s encryption requires an encrypted, content-defined file", ErrInvalidMetadata, m.EncryptionMode)
+		}
+	default:
+		return fmt.Errorf("%w: unknown encryption mode %q", ErrInvalidMetadata, m.EncryptionMode)
+	}
+
+	switch m.Chunking {
+	case "":
+	case ChunkingContentDefined:
+		if !m.Chunked {
+			return fmt.Errorf("%w: content-defined chunking requires a chunked file", ErrInvalidMetadata)
+		}
+		if m.Encrypted && m.ChunkSecrets == nil {
+			return fmt.Errorf("%w: encrypted content-defined file has no chunk keys", ErrInvalidMetadata)
+		}
+	default:
+		return fmt.Errorf("%w: unknown chunking %q", ErrInvalidMetadata, m.Chunking)
+	}
+
+	if !m.Chunked {
+		if m.FileID == "" {
+			return fmt.Errorf("%w: missing file reference", ErrInvalidMetadata)
+		}
+		if m.FileHash == "" {
+			return fmt.Errorf("%w: missing file hash", ErrInvalidMetadata)
+		}
+		return nil
+	}
+
+	if len(m.ChunkIDs) == 0 {
+		return fmt.Errorf("%w: chunked file has no chunks", ErrInvalidMetadata)
+	}
+	for i := 1; i <= len(m.ChunkIDs); i++ {
+		k := strconv.Itoa(i)
+		if m.ChunkIDs[k] == "" {
+			return fmt.Errorf("%w: missing reference for chunk %s", ErrInvalidMetadata, k)
+		}
+		if m.ChunkHashes[k] == "" {
+			return fmt.Errorf("%w: missing hash for chunk %s", ErrInvalidMetadata, k)
+		}
+	}
+	if m.Chunking != ChunkingContentDefined {
+		return nil
+	}
+
+	// Content-defined chunks vary in size, so each one's length is recorded
+	var total int64
+	for i := 1; i <= len(m.ChunkIDs); i++ {
+		k := strconv.Itoa(i)
+		if m.ChunkLengths[k] <= 0 {
+			return fmt.Errorf("%w: missing length for chunk %s", ErrInvalidMetadata, k)
+		}
+		if m.Encrypted && m.ChunkSecrets[k] == "" {
+			return fmt.Errorf("%w: missing key for chunk %s", ErrInvalidMetadata, k)
+		}
+		total += int64(m.ChunkLengths[k])
+	}
+	if total != m.Size {
+		return fmt.Errorf("%w: chunk lengths add up to %d bytes, want %d", ErrInvalidMetadata, total, m.Size)
+	}
+	return nil
+}
+
+// SchemaVersion returns the metadata schema version (documents without one are version 1)
+func (m *Metadata) SchemaVersion() int {
+	if m.Version == 0 {
+		return 1
+	}
+	return m.Version
+}
+
+// EncryptionScheme returns a human readable name of the encryption scheme
+func (m *Metadata) EncryptionScheme() string {
+	if !m.Encrypted {
+		return SchemeNone
+	}
+	switch m.EncryptionMode {
+	case EncryptionPerChunk:
+		return SchemeAESGCMPerChunk
+	case EncryptionConvergent, EncryptionKeyed:
+		return SchemeAESGCMDerived + " (" + m.EncryptionMode + ")"
+	}
+	return SchemeAESGCM
+}
+
+// PerChunkEncryption reports whether each chunk is encrypted on its own
+func (m *Metadata) PerChunkEncryption() bool {
+	switch m.EncryptionMode {
+	case EncryptionPerChunk, EncryptionConvergent, EncryptionKeyed:
+		return m.Encrypted
+	}
+	return false
+}
+
+// chunkKey returns the key that decrypts a piece, given the file key
+func (m *Metadata) chunkKey(p Piece, key []byte) ([]byte, error) {
+	secret, ok := m.ChunkSecrets[p.Index]
+	if !ok {
+		return key, nil
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("malformed key of %s: %v", p.Name(), err)
+	}
+	chunkKey, err := DecryptData(wrapped, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key of %s: %v", p.Name(), err)
+	}
+	return chunkKey, nil
+}
+
+// decryptPiece decrypts the stored data of a piece with the file key
+func (m *Metadata) decryptPiece(p Piece, data []byte, key []byte) ([]byte, error) {
+	chunkKey, err := m.chunkKey(p, key)
+	if err != nil {
+		return nil, err
+	}
+	return DecryptData(data, chunkKey)
+}
+
+// ChunkingScheme returns a human readable name of the chunking scheme
+func (m *Metadata) ChunkingScheme() string {
+	if !m.Chunked {
+		return SchemeNone
+	}
+	if m.Chunking == ChunkingContentDefined {
+		return SchemeContentDefined
+	}
+	return SchemeFixedSize
+}
+
+// ChunkOffsets returns the offset of every piece in the file followed by the
+// file size, or nil if the sizes of the pieces are not known
+func (m *Metadata) ChunkOffsets() []int64 {
+	if !m.Chunked {
+		return []int64{0, m.Size}
+	}
+	if m.Size <= 0 || m.Chunking != ChunkingContentDefined && m.ChunkSize <= 0 {
+		return nil
+	}
+	keys := m.ChunkKeys()
+	offsets := make([]int64, len(keys)+1)
+	for i, k := range keys {
+		n := int64(m.ChunkSize)
+		if m.Chunking == ChunkingContentDefined {
+			n = int64(m.ChunkLengths[k])
+		}
+		offsets[i+1] = min(offsets[i]+n, m.Size)
+	}
+	return offsets
+}
+
+// ChunkCount returns the number of stored pieces
+func (m *Metadata) ChunkCount() int {
+	if !m.Chunked {
+		return 1
+	}
+	return len(m.ChunkIDs)
+}
+
+// ChunkKeys returns the chunk keys in numeric order
+func (m *Metadata) ChunkKeys() []string {
+	keys := make([]string, 0, len(m.ChunkIDs))
+	for k := range m.ChunkIDs {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, _ := strconv.Atoi(keys[i])
+		b, _ := strconv.Atoi(keys[j])
+		return a < b
+	})
+	return keys
+}
+
+// Piece is one stored piece of a file
+type Piece struct {
+	Index     string // Chunk key, or "file" for unchunked uploads
+	Reference string
+	Hash      string
+}
+
+// Name describes the piece in messages, e.g. "chunk 3" or "file"
+func (p Piece) Name() string {
+	if p.Index == "file" {
+		return p.Index
+	}
+	return "chunk " + p.Index
+}
+
+// Pieces returns the stored pieces in download order
+func (m *Metadata) Pieces() []Piece {
+	if !m.Chunked {
+		return []Piece{{"file", m.FileID, m.FileHash}}
+	}
+	pieces := make([]Piece, 0, len(m.ChunkIDs))
+	for _, k := range m.ChunkKeys() {
+		pieces = append(pieces, Piece{k, m.ChunkIDs[k], m.ChunkHashes[k]})
+	}
+	return pieces
+}
@@ -0,0 +1,122 @@
+package finalride
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// PinResult holds the outcome of a pin operation on one reference of a share
+type PinResult struct {
+	Index     string // Chunk key, "file" or "metadata"
+	Reference string
+	Pinned    bool // Pin state after the operation, if Err is nil
+	Err       error
+}
+
+// PinSwarm pins a reference on a Bee node so it is not garbage-collected
+func PinSwarm(reference string, apiEndpoint string) error {
+	_, err := pinRequest(http.MethodPost, reference, apiEndpoint, http.StatusOK, http.StatusCreated)
+	return err
+}
+
+// UnpinSwarm removes the pin of a reference on a Bee node
+func UnpinSwarm(reference string, apiEndpoint string) error {
+	_, err := pinRequest(http.MethodDelete, reference, apiEndpoint, http.StatusOK)
+	return err
+}
+
+// IsPinned reports whether a reference is pinned on a Bee node
+func IsPinned(reference string, apiEndpoint string) (bool, error) {
+	status, err := pinRequest(http.MethodGet, reference, apiEndpoint, http.StatusOK, http.StatusNotFound)
+	if err != nil {
+		return false, err
+	}
+	return status == http.StatusOK, nil
+}
+
+// ListPins returns every reference pinned on a Bee node
+func ListPins(apiEndpoint string) ([]string, error) {
+	resp, err := http.Get(apiEndpoint + "/pins")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to list pins: %s - %s", resp.Status, string(body))
+	}
+
+	var response struct {
+		References []string `json:"references"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return response.References, nil
+}
+
+// pinRequest calls /pins/{reference} and returns the status code if it is one
+// of the accepted ones
+func pinRequest(method, reference, apiEndpoint string, accepted ...int) (int, error) {
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/pins/%s", apiEndpoint, reference), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	for _, status := range accepted {
+		if resp.StatusCode == status {
+			return status, nil
+		}
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return 0, fmt.Errorf("pin request failed: %s - %s", resp.Status, string(body))
+}
+
+// ShareReferences returns every piece of a share followed by its metadata document
+func ShareReferences(reference string, metadata *Metadata) []Piece {
+	return append(metadata.Pieces(), Piece{Index: "metadata", Reference: reference})
+}
+
+// PinShare pins every piece and the metadata document of a share on a node. The
+// optional callback is invoked as each result becomes available.
+func PinShare(reference string, metadata *Metadata, apiEndpoint string, onResult func(PinResult)) []PinResult {
+	return walkPins(reference, metadata, onResult, func(ref string) (bool, error) {
+		return true, PinSwarm(ref, apiEndpoint)
+	})
+}
+
+// UnpinShare removes the pins of every piece and the metadata document of a share
+func UnpinShare(reference string, metadata *Metadata, apiEndpoint string, onResult func(PinResult)) []PinResult {
+	return walkPins(reference, metadata, onResult, func(ref string) (bool, error) {
+		return false, UnpinSwarm(ref, apiEndpoint)
+	})
+}
+
+// SharePinStatus reports which references of a share are pinned on a node
+func SharePinStatus(reference string, metadata *Metadata, apiEndpoint string, onResult func(PinResult)) []PinResult {
+	return walkPins(reference, metadata, onResult, func(ref string) (bool, error) {
+		return IsPinned(ref, apiEndpoint)
+	})
+}
+
+func walkPins(reference string, metadata *Metadata, onResult func(PinResult), apply func(ref string) (bool, error)) []PinResult {
+	pieces := ShareReferences(reference, metadata)
+	results := make([]PinResult, 0, len(pieces))
+	for _, p := range pieces {
+		result := PinResult{Index: p.Index, Reference: p.Reference}
+		result.Pinned, result.Err = apply(p.Reference)
+		results = append(results, result)
+		if onResult != nil {
+			onResult(result)
+		}
+	}
+	return results
+}
@@ -0,0 +1,307 @@
+package finalride
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Download strategies for a Pool
+const (
+	StrategyFailover = "failover" // Try endpoints one after another
+	StrategyHedge    = "hedge"    // Start the next endpoint when the current one is slow
+	StrategyRace     = "race"     // Ask every endpoint at once
+)
+
+// EndpointStatus is the health of one endpoint in a Pool
+type EndpointStatus struct {
+	URL       string
+	Healthy   bool          // False after a failed request or ping, until one succeeds
+	Failures  int           // Consecutive failures
+	LastError string        // Most recent failure, if any
+	Latency   time.Duration // Duration of the most recent successful request
+	LastCheck time.Time     // Zero until the endpoint has been used or pinged
+}
+
+// Pool is a Store spread over an ordered list of Swarm API endpoints. Uploads
+// and checks fail over to the next endpoint; downloads follow the pool's
+// strategy and return the first response that passes its hash check.
+// Endpoints that failed recently are tried last.
+type Pool struct {
+	strategy   string
+	hedgeDelay time.Duration
+
+	mu        sync.Mutex
+	endpoints []EndpointStatus
+	pin       bool
+	batches   map[int]string // Endpoint index -> postage batch, see PrepareStamps
+
+	tagMu sync.Mutex
+	tags  map[int]uint32 // Endpoint index -> upload tag, nil unless tracking sync
+}
+
+// NewPool creates a pool over the given endpoints, all assumed healthy. An
+// unknown strategy falls back to failover.
+func NewPool(endpoints []string, strategy string, hedgeDelay time.Duration) *Pool {
+	p := &Pool{strategy: strategy, hedgeDelay: hedgeDelay}
+	for _, url := range endpoints {
+		p.endpoints = append(p.endpoints, EndpointStatus{URL: url, Healthy: true})
+	}
+	return p
+}
+
+// NewConfigPool creates the pool described by the configuration
+func NewConfigPool(config *Config) *Pool {
+	p := NewPool(config.APIEndpoints(), config.DownloadStrategy, time.Duration(config.HedgeDelayMS)*time.Millisecond)
+	p.SetPin(config.PinUploads)
+	return p
+}
+
+// SetPin controls whether uploads ask the receiving node to pin the data.
+// Public gateways are never asked to pin.
+func (p *Pool) SetPin(pin bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pin = pin
+}
+
+// Status returns a snapshot of every endpoint's health, in configured order
+func (p *Pool) Status() []EndpointStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]EndpointStatus(nil), p.endpoints...)
+}
+
+// Ping checks every endpoint concurrently and updates its health
+func (p *Pool) Ping(timeout time.Duration) []EndpointStatus {
+	var wg sync.WaitGroup
+	for i, status := range p.Status() {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			start := time.Now()
+			err := PingSwarm(url, timeout)
+			p.record(i, time.Since(start), err)
+		}(i, status.URL)
+	}
+	wg.Wait()
+	return p.Status()
+}
+
+// Upload stores data on the first endpoint that accepts it
+func (p *Pool) Upload(data []byte) (string, error) {
+	var errs []string
+	for _, i := range p.order() {
+		ref, err := p.UploadTo(data, i)
+		if err == nil {
+			return ref, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", p.url(i), err))
+	}
+	return "", poolError(errs)
+}
+
+// UploadTo stores data on one endpoint, by index, and records its health
+func (p *Pool) UploadTo(data []byte, i int) (string, error) {
+	p.mu.Lock()
+	url := p.endpoints[i].URL
+	headers := uploadHeaders{pin: p.pin && !IsPublicGateway(url), batch: p.batches[i]}
+	p.mu.Unlock()
+
+	headers.tag = p.tagFor(i)
+	start := time.Now()
+	ref, err := uploadToSwarm(data, url, headers)
+	p.record(i, time.Since(start), err)
+	return ref, err
+}
+
+// Check reports whether any endpoint can retrieve a reference
+func (p *Pool) Check(reference string) error {
+	var errs []string
+	for _, i := range p.order() {
+		url := p.url(i)
+		start := time.Now()
+		err := CheckSwarm(reference, url)
+		p.record(i, time.Since(start), err)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+	}
+	return poolError(errs)
+}
+
+// ResolveFeed reports whether a reference is a feed manifest, asking the
+// endpoints in turn until one answers
+func (p *Pool) ResolveFeed(reference string) (string, bool, error) {
+	var errs []string
+	for _, i := range p.order() {
+		url := p.url(i)
+		start := time.Now()
+		resolved, feed, err := ResolveFeedManifest(reference, url)
+		p.record(i, time.Since(start), err)
+		if err == nil {
+			return resolved, feed, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", url, err))
+	}
+	return "", false, poolError(errs)
+}
+
+// Download retrieves a reference using the pool's strategy
+func (p *Pool) Download(reference string) ([]byte, error) {
+	return p.fetch("bzz", reference, nil)
+}
+
+// DownloadVerified retrieves a reference using the pool's strategy, skipping
+// responses that do not match the SHA-256 hash
+func (p *Pool) DownloadVerified(reference, hash string) ([]byte, error) {
+	return p.fetch("bzz", reference, func(data []byte) error { return checkHash(data, hash) })
+}
+
+// DownloadBytes retrieves raw data using the pool's strategy, skipping
+// responses that do not match the reference
+func (p *Pool) DownloadBytes(reference string) ([]byte, error) {
+	return p.fetch("bytes", reference, func(data []byte) error { return checkAddress(data, reference) })
+}
+
+// DownloadChunk retrieves a single chunk using the pool's strategy, skipping
+// responses that do not match the reference
+func (p *Pool) DownloadChunk(reference string) ([]byte, error) {
+	return p.fetch("chunks", reference, func(data []byte) error { return checkChunk(data, reference) })
+}
+
+// fetch downloads a reference through a Bee API, rejecting responses that fail
+// the check, if any
+func (p *Pool) fetch(api, reference string, check func([]byte) error) ([]byte, error) {
+	order := p.order()
+	if len(order) == 0 {
+		return nil, poolError(nil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type attempt struct {
+		url  string
+		data []byte
+		err  error
+	}
+	results := make(chan attempt, len(order))
+
+	next, pending := 0, 0
+	launch := func() {
+		i := order[next]
+		url := p.url(i)
+		next++
+		pending++
+		go func() {
+			start := time.Now()
+			data, err := getFromSwarm(ctx, api, reference, url)
+			if err == nil && check != nil {
+				err = check(data)
+			}
+			// Requests cancelled because another endpoint won say nothing about health
+			if !errors.Is(err, context.Canceled) {
+				p.record(i, time.Since(start), err)
+			}
+			results <- attempt{url, data, err}
+		}()
+	}
+
+	launch()
+	if p.strategy == StrategyRace {
+		for next < len(order) {
+			launch()
+		}
+	}
+
+	var errs []string
+	corrupt := false
+	for pending > 0 {
+		var timer *time.Timer
+		var hedge <-chan time.Time
+		if p.strategy == StrategyHedge && next < len(order) {
+			timer = time.NewTimer(p.hedgeDelay)
+			hedge = timer.C
+		}
+
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				return r.data, nil
+			}
+			errs = append(errs, fmt.Sprintf("%s: %v", r.url, r.err))
+			if errors.Is(r.err, ErrIntegrity) {
+				corrupt = true
+			}
+			if next < len(order) {
+				launch()
+			}
+		case <-hedge:
+			launch()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+
+	if corrupt {
+		return nil, fmt.Errorf("%w: no endpoint returned matching data (%s)", ErrIntegrity, strings.Join(errs, "; "))
+	}
+	return nil, poolError(errs)
+}
+
+// order returns endpoint indexes with healthy endpoints first, each group in
+// configured order
+func (p *Pool) order() []int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var healthy, unhealthy []int
+	for i, status := range p.endpoints {
+		if status.Healthy {
+			healthy = append(healthy, i)
+		} else {
+			unhealthy = append(unhealthy, i)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (p *Pool) url(i int) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.endpoints[i].URL
+}
+
+// record updates an endpoint's health after a request. Client errors such as
+// a missing reference show the endpoint is up, so only endpoint failures count.
+func (p *Pool) record(i int, latency time.Duration, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := &p.endpoints[i]
+	status.LastCheck = time.Now()
+	if err != nil && endpointFailure(err) {
+		status.Healthy = false
+		status.Failures++
+		status.LastError = err.Error()
+		return
+	}
+	status.Healthy = true
+	status.Failures = 0
+	status.LastError = ""
+	status.Latency = latency
+}
+
+func poolError(errs []string) error {
+	if len(errs) == 0 {
+		return errors.New("no Swarm endpoints configured")
+	}
+	return fmt.Errorf("all Swarm endpoints failed: %s", strings.Join(errs, "; "))
+}
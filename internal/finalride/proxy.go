@@ -0,0 +1,225 @@
+package finalride
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// referencePattern matches a plain or encrypted Swarm reference
+var referencePattern = regexp.MustCompile(`^[0-9a-fA-F]{64}([0-9a-fA-F]{64})?$`)
+
+// proxyResponseHeaders are passed from the Bee node back to the browser
+var proxyResponseHeaders = []string{"Content-Type", "Content-Length", "Content-Disposition", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"}
+
+// handleProxyUpload streams a browser upload to the first healthy endpoint,
+// adding the postage batch, pin and credentials the browser does not have
+func (s *Server) handleProxyUpload(w http.ResponseWriter, r *http.Request) {
+	if s.opts.MaxUpload > 0 {
+		if r.ContentLength > s.opts.MaxUpload {
+			proxyError(w, http.StatusRequestEntityTooLarge, "upload of %d bytes exceeds the limit of %d bytes", r.ContentLength, s.opts.MaxUpload)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, s.opts.MaxUpload)
+	}
+
+	// The body can only be sent once, so there is no failover after this
+	i := s.pool.order()[0]
+	endpoint := s.pool.url(i)
+	batch, stamped, err := stampFor(s.config.PostageBatch, endpoint, i == 0, swarmChunks(max(r.ContentLength, 0)))
+	if err != nil {
+		proxyError(w, http.StatusServiceUnavailable, "no postage batch for this upload: %v", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, endpoint+"/bzz", r.Body)
+	if err != nil {
+		proxyError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	req.ContentLength = r.ContentLength
+	if name := r.URL.Query().Get("name"); name != "" {
+		req.URL.RawQuery = url.Values{"name": {name}}.Encode()
+	}
+	req.Header.Set("Content-Type", r.Header.Get("Content-Type"))
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if s.config.PinUploads && !IsPublicGateway(endpoint) {
+		req.Header.Set("Swarm-Pin", "true")
+	}
+	if stamped {
+		req.Header.Set("Swarm-Postage-Batch-Id", batch.BatchID)
+	}
+	s.authorize(req)
+
+	start := time.Now()
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			proxyError(w, http.StatusRequestEntityTooLarge, "upload exceeds the limit of %d bytes", s.opts.MaxUpload)
+			return
+		}
+		s.pool.record(i, time.Since(start), err)
+		proxyError(w, http.StatusBadGateway, "%s: %v", endpoint, err)
+		return
+	}
+	defer resp.Body.Close()
+	s.pool.record(i, time.Since(start), statusError(resp))
+	copyResponse(w, resp)
+}
+
+// handleProxyDownload streams a reference from the first endpoint that has it
+func (s *Server) handleProxyDownload(w http.ResponseWriter, r *http.Request) {
+	path := r.PathValue("path")
+	if !validProxyPath(path) {
+		proxyError(w, http.StatusBadRequest, "invalid reference %q", path)
+		return
+	}
+	status, message := http.StatusBadGateway, "no Swarm endpoints configured"
+	for _, i := range s.pool.order() {
+		endpoint := s.pool.url(i)
+		req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, endpoint+"/bzz/"+path, nil)
+		if err != nil {
+			proxyError(w, http.StatusBadRequest, "%v", err)
+			return
+		}
+		if value := r.Header.Get("Range"); value != "" {
+			req.Header.Set("Range", value)
+		}
+		s.authorize(req)
+
+		start := time.Now()
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			s.pool.record(i, time.Since(start), err)
+			status, message = http.StatusBadGateway, fmt.Sprintf("%s: %v", endpoint, err)
+			continue
+		}
+		s.pool.record(i, time.Since(start), statusError(resp))
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode >= http.StatusInternalServerError {
+			// Another endpoint may still have it
+			status, message = resp.StatusCode, fmt.Sprintf("%s: %s", endpoint, resp.Status)
+			resp.Body.Close()
+			continue
+		}
+		defer resp.Body.Close()
+		copyResponse(w, resp)
+		return
+	}
+	proxyError(w, status, "%s", message)
+}
+
+// validProxyPath accepts a reference, optionally followed by a path inside its
+// manifest, so the proxy cannot be used to reach other Bee endpoints
+func validProxyPath(path string) bool {
+	segments := strings.Split(path, "/")
+	if !referencePattern.MatchString(segments[0]) {
+		return false
+	}
+	for _, segment := range segments[1:] {
+		if segment == ".." || segment == "." {
+			return false
+		}
+	}
+	return true
+}
+
+// authorize adds the configured credentials to a request for a Bee endpoint
+func (s *Server) authorize(req *http.Request) {
+	if s.config.APIToken != "" {
+		req.Header.Set("Authorization", "Bearer "+s.config.APIToken)
+	}
+}
+
+// statusError turns a server error into an error for the endpoint's health
+func statusError(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return nil
+}
+
+// copyResponse streams a Bee response to the client without buffering it
+func copyResponse(w http.ResponseWriter, resp *http.Response) {
+	for _, key := range proxyResponseHeaders {
+		if value := resp.Header.Get(key); value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// proxyError reports an error in the JSON shape Bee uses
+func proxyError(w http.ResponseWriter, status int, format string, args ...any) {
+	writeJSON(w, status, map[string]any{"code": status, "message": fmt.Sprintf(format, args...)})
+}
+
+// limit rejects requests from clients over the rate limit, which counts the
+// requests to every route it wraps together
+func (s *Server) limit(next http.HandlerFunc) http.HandlerFunc {
+	if s.limiter == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if wait, ok := s.limiter.allow(client, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+			proxyError(w, http.StatusTooManyRequests, "rate limit of %d requests per minute exceeded", s.opts.RateLimit)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// rateLimiter allows each client a number of requests per window
+type rateLimiter struct {
+	limit  int
+	window time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*rateWindow
+}
+
+type rateWindow struct {
+	start time.Time
+	count int
+}
+
+// allow counts a request from client and reports whether it is within the
+// limit, or else how long until the client's window resets
+func (l *rateLimiter) allow(client string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.clients) > 4096 {
+		for key, w := range l.clients {
+			if now.Sub(w.start) >= l.window {
+				delete(l.clients, key)
+			}
+		}
+	}
+	w, ok := l.clients[client]
+	if !ok || now.Sub(w.start) >= l.window {
+		w = &rateWindow{start: now}
+		l.clients[client] = w
+	}
+	if w.count >= l.limit {
+		return l.window - now.Sub(w.start), false
+	}
+	w.count++
+	return 0, true
+}
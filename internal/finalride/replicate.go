@@ -0,0 +1,162 @@
+package finalride
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrReplicaMismatch is returned when endpoints return different references
+// for the same data
+var ErrReplicaMismatch = errors.New("replica references disagree")
+
+// ReplicatedStore is a Store that writes every upload to all of its endpoints
+// and checks that they agree on the reference. Reads go to the pool it wraps.
+type ReplicatedStore struct {
+	*Pool
+
+	minReplicas int
+
+	mu         sync.Mutex
+	placements map[string][]string // Reference -> endpoints holding it
+}
+
+// NewReplicatedStore replicates uploads to every endpoint of the pool. An upload
+// fails unless at least minReplicas endpoints store it; 0 requires all of them.
+func NewReplicatedStore(pool *Pool, minReplicas int) *ReplicatedStore {
+	if n := len(pool.Status()); minReplicas <= 0 || minReplicas > n {
+		minReplicas = n
+	}
+	return &ReplicatedStore{Pool: pool, minReplicas: minReplicas, placements: make(map[string][]string)}
+}
+
+// Upload stores data on every endpoint concurrently
+func (r *ReplicatedStore) Upload(data []byte) (string, error) {
+	type replica struct {
+		url string
+		ref string
+		err error
+	}
+
+	status := r.Status()
+	replicas := make([]replica, len(status))
+	var wg sync.WaitGroup
+	for i, endpoint := range status {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			ref, err := r.UploadTo(data, i)
+			replicas[i] = replica{url, ref, err}
+		}(i, endpoint.URL)
+	}
+	wg.Wait()
+
+	var ref string
+	var stored, errs []string
+	refs := make(map[string][]string)
+	for _, replica := range replicas {
+		if replica.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", replica.url, replica.err))
+			continue
+		}
+		ref = replica.ref
+		stored = append(stored, replica.url)
+		refs[replica.ref] = append(refs[replica.ref], replica.url)
+	}
+
+	if len(refs) > 1 {
+		var parts []string
+		for ref, urls := range refs {
+			parts = append(parts, fmt.Sprintf("%s from %s", ref, strings.Join(urls, ", ")))
+		}
+		sort.Strings(parts)
+		return "", fmt.Errorf("%w: %s", ErrReplicaMismatch, strings.Join(parts, "; "))
+	}
+	if len(stored) < r.minReplicas {
+		return "", fmt.Errorf("stored on %d of %d required endpoints: %s", len(stored), r.minReplicas, strings.Join(errs, "; "))
+	}
+
+	r.mu.Lock()
+	r.placements[ref] = stored
+	r.mu.Unlock()
+	return ref, nil
+}
+
+// Placement returns the endpoints a reference was uploaded to
+func (r *ReplicatedStore) Placement(reference string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.placements[reference]
+}
+
+// ReplicaResult holds the outcome of copying one piece to another node
+type ReplicaResult struct {
+	Index     string // Chunk key, "file" or "metadata"
+	Reference string
+	Err       error
+}
+
+// Replicate copies a share, every piece and then the metadata document, from
+// source to target, checking each piece's hash and that the target returns the
+// same reference. The metadata is only copied once every piece has been, so the
+// share never resolves on the target while incomplete. The optional callback is
+// invoked as each result becomes available.
+func Replicate(reference string, source, target Store, onResult func(ReplicaResult)) ([]ReplicaResult, error) {
+	document, err := source.Download(reference)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download metadata: %v", err)
+	}
+	metadata, err := ParseMetadata(document)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []ReplicaResult
+	report := func(result ReplicaResult) {
+		results = append(results, result)
+		if onResult != nil {
+			onResult(result)
+		}
+	}
+
+	failed := 0
+	for _, p := range metadata.Pieces() {
+		result := ReplicaResult{Index: p.Index, Reference: p.Reference}
+		data, err := downloadPiece(source, p.Reference, p.Hash)
+		if err == nil {
+			err = copyTo(target, data, p.Reference)
+		}
+		if err != nil {
+			result.Err = err
+			failed++
+		}
+		report(result)
+	}
+
+	result := ReplicaResult{Index: "metadata", Reference: reference}
+	if failed > 0 {
+		result.Err = fmt.Errorf("skipped: %d pieces failed", failed)
+	} else {
+		result.Err = copyTo(target, document, reference)
+	}
+	report(result)
+
+	if result.Err != nil {
+		return results, fmt.Errorf("replication incomplete: %v", result.Err)
+	}
+	return results, nil
+}
+
+// copyTo uploads data to target and checks that it is stored under reference
+func copyTo(target Store, data []byte, reference string) error {
+	ref, err := target.Upload(data)
+	if err != nil {
+		return err
+	}
+	if ref != reference {
+		return fmt.Errorf("%w: target returned %s", ErrReplicaMismatch, ref)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package finalride
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Restore outcomes of a file
+const (
+	RestoreVerified = "verified" // Downloaded and matching the manifest's SHA-256
+	RestoreSkipped  = "skipped"  // Filtered out, or already present with the right content
+	RestoreFailed   = "failed"
+)
+
+// RestoreOptions controls RestoreDirectory
+type RestoreOptions struct {
+	Filter PathFilter
+	Store  Store
+
+	// OnFile is called as each file is restored, skipped or fails
+	OnFile func(RestoredFile)
+	// OnProgress is called with the size of each downloaded piece
+	OnProgress func(n int64)
+}
+
+// RestoredFile is the outcome of restoring one file of a directory
+type RestoredFile struct {
+	Entry  DirEntry
+	Status string
+	Reason string // Why the file was skipped
+	Err    error  // Why the file failed
+}
+
+// RestoreDirectory downloads the files of a manifest that pass the filter into
+// dest, checks each against its recorded SHA-256 and restores its mode and
+// modification time. The files of a Bee collection are checked against their
+// Swarm reference. Files already in dest with the right content are not
+// downloaded again. A file that fails is reported and the rest continue.
+func RestoreDirectory(d *Directory, dest string, opts RestoreOptions) []RestoredFile {
+	collection := d.Type == CollectionType
+	results := make([]RestoredFile, 0, len(d.Files))
+	for _, entry := range d.Files {
+		r := RestoredFile{Entry: entry, Status: RestoreVerified}
+		target := filepath.Join(dest, filepath.FromSlash(entry.Path))
+		switch {
+		case !opts.Filter.Match(entry.Path):
+			r.Status, r.Reason = RestoreSkipped, "filtered out"
+		case upToDate(target, entry, collection):
+			r.Status, r.Reason = RestoreSkipped, "up to date"
+			r.Err = restoreAttributes(target, entry)
+		default:
+			r.Err = restoreFile(target, entry, collection, opts)
+		}
+		if r.Err != nil {
+			r.Status = RestoreFailed
+		}
+		results = append(results, r)
+		if opts.OnFile != nil {
+			opts.OnFile(r)
+		}
+	}
+	return results
+}
+
+// restoreFile downloads one file next to its target and moves it into place
+// once its content has been verified
+func restoreFile(target string, entry DirEntry, collection bool, opts RestoreOptions) error {
+	var download func(w io.Writer) error
+	if collection {
+		raw, err := rawDownloader(opts.Store)
+		if err != nil {
+			return err
+		}
+		// DownloadBytes checks the content against the Swarm reference
+		download = func(w io.Writer) error {
+			data, err := raw.DownloadBytes(entry.Reference)
+			if err != nil {
+				return err
+			}
+			if opts.OnProgress != nil {
+				opts.OnProgress(int64(len(data)))
+			}
+			if _, err := w.Write(data); err != nil {
+				return fmt.Errorf("failed to save file: %v", err)
+			}
+			return nil
+		}
+	} else {
+		metadata, err := FetchMetadata(entry.Reference, opts.Store)
+		if err != nil {
+			return fmt.Errorf("failed to fetch metadata: %w", err)
+		}
+		download = func(w io.Writer) error {
+			_, err := DownloadStream(metadata, opts.Store, w, opts.OnProgress)
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.part")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	err = download(io.MultiWriter(tmp, hash))
+	if closeErr := tmp.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("failed to save file: %v", closeErr)
+	}
+	if err != nil {
+		return err
+	}
+	if sum := fmt.Sprintf("%x", hash.Sum(nil)); entry.Hash != "" && sum != entry.Hash {
+		return fmt.Errorf("%w: SHA-256 %s does not match the manifest", ErrIntegrity, sum)
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("failed to save file: %v", err)
+	}
+	return restoreAttributes(target, entry)
+}
+
+// restoreAttributes applies the recorded permission bits and modification time
+func restoreAttributes(target string, entry DirEntry) error {
+	if entry.Mode != 0 {
+		if err := os.Chmod(target, entry.Mode.Perm()); err != nil {
+			return fmt.Errorf("failed to set mode: %v", err)
+		}
+	}
+	if !entry.ModTime.IsZero() {
+		if err := os.Chtimes(target, entry.ModTime, entry.ModTime); err != nil {
+			return fmt.Errorf("failed to set modification time: %v", err)
+		}
+	}
+	return nil
+}
+
+// upToDate reports whether target is a regular file with the entry's content
+func upToDate(target string, entry DirEntry, collection bool) bool {
+	info, err := os.Lstat(target)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != entry.Size {
+		return false
+	}
+	if collection {
+		// Only unencrypted references can be recomputed from the content
+		data, err := os.ReadFile(target)
+		return err == nil && len(entry.Reference) == 64 && checkAddress(data, entry.Reference) == nil
+	}
+	if entry.Hash == "" {
+		return false
+	}
+	sum, err := hashFile(target)
+	return err == nil && sum == entry.Hash
+}
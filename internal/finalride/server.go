@@ -0,0 +1,99 @@
+package finalride
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/fs"
+	"net/http"
+	"time"
+)
+
+// WebConfig is the configuration the web UI loads from /config.json
+type WebConfig struct {
+	Name           string `json:"name"`            // Branding shown in the title and header
+	Theme          string `json:"theme"`           // "light" or "dark"
+	Gateway        string `json:"gateway"`         // Swarm API the page uploads to and downloads from
+	ChunkSize      int    `json:"chunk_size"`      // Chunk size in bytes
+	EncryptDefault bool   `json:"encrypt_default"` // Initial state of the encrypt checkbox
+	DownloadLink   string `json:"download_link"`   // Share link template with one %s for the CID
+}
+
+// NewWebConfig derives the web UI configuration from the app configuration
+func NewWebConfig(config *Config) WebConfig {
+	return WebConfig{
+		Name:           config.BrandName,
+		Theme:          config.Theme,
+		Gateway:        config.SwarmAPI,
+		ChunkSize:      config.ChunkSizeMB * 1024 * 1024,
+		EncryptDefault: config.EncryptDefault,
+		DownloadLink:   config.DownloadLink,
+	}
+}
+
+// ServerOptions limits what clients of a Server may do
+type ServerOptions struct {
+	MaxUpload int64 // Largest upload /api/bzz accepts in bytes, 0 for no limit
+	RateLimit int   // Requests per minute one client may send to /api, 0 for no limit
+}
+
+// Server hosts the web UI together with the configuration it runs on, and
+// proxies /api/bzz to the configured endpoints so the browser needs neither
+// CORS access to them nor their postage batch and credentials
+type Server struct {
+	config *Config
+	opts   ServerOptions
+	assets fs.FS
+	pool   *Pool
+	mux    *http.ServeMux
+
+	limiter *rateLimiter // Shared by every /api route; nil without a rate limit
+}
+
+// NewServer returns a handler serving the files in assets, which must contain
+// index.html, /config.json generated from config, the /api/bzz proxy and the
+// decrypted files of shares at /f/{cid}
+func NewServer(config *Config, assets fs.FS, opts ServerOptions) *Server {
+	s := &Server{config: config, opts: opts, assets: assets, pool: NewConfigPool(config), mux: http.NewServeMux()}
+	if opts.RateLimit > 0 {
+		s.limiter = &rateLimiter{limit: opts.RateLimit, window: time.Minute, clients: make(map[string]*rateWindow)}
+	}
+	s.mux.HandleFunc("POST /api/bzz", s.limit(s.handleProxyUpload))
+	s.mux.HandleFunc("GET /api/bzz/{path...}", s.limit(s.handleProxyDownload))
+	// A cache that cannot be opened only costs speed
+	cache, _ := NewConfigCache(config)
+	s.mux.Handle("GET /f/{cid}", ShareHandler{Store: NewCachedStore(s.pool, cache)})
+	s.mux.HandleFunc("GET /config.json", s.handleConfig)
+	s.mux.HandleFunc("GET /{$}", s.handleIndex)
+	s.mux.HandleFunc("GET /index.html", s.handleIndex)
+	s.mux.Handle("GET /", http.FileServerFS(assets))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	config := NewWebConfig(s.config)
+	config.Gateway = "api" // Relative, so the page keeps working behind a path prefix
+	w.Header().Set("Cache-Control", "no-cache")
+	writeJSON(w, http.StatusOK, config)
+}
+
+// handleIndex serves index.html directly; http.FileServer would redirect
+// /index.html to / and drop the ?download= query of share links
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	data, err := fs.ReadFile(s.assets, "index.html")
+	if err != nil {
+		http.Error(w, "web UI not available", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	http.ServeContent(w, r, "index.html", time.Time{}, bytes.NewReader(data))
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
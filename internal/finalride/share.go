@@ -0,0 +1,291 @@
+package finalride
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// KeyHeader carries the encryption key of a share requested from a ShareHandler
+const KeyHeader = "X-Final-Ride-Key"
+
+// errRangeNotSatisfiable is returned by parseRange for a range outside the file
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// ShareHandler serves the decrypted files of shares at /{cid}, or at a route
+// with a {cid} wildcard, answering Range requests with only the pieces that
+// cover them. A key in the ?key= query or the X-Final-Ride-Key header replaces
+// the one in the metadata, so links can keep the key out of the document.
+type ShareHandler struct {
+	Store Store
+}
+
+func (h ShareHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cid := r.PathValue("cid")
+	if cid == "" {
+		cid = strings.TrimPrefix(r.URL.Path, "/")
+	}
+	key := r.URL.Query().Get("key")
+	if value := r.Header.Get(KeyHeader); value != "" {
+		key = value
+	}
+	reference := ParseReference(cid)
+
+	// A feed manifest resolves to new content with each update, so only shares
+	// known to be content-addressed may be cached for good
+	etag, cacheControl := "", "no-cache"
+	resolved, feed, err := resolveFeed(h.Store, reference)
+	switch {
+	case err == nil && feed:
+		etag = strconv.Quote(resolved)
+	case err == nil:
+		etag, cacheControl = strconv.Quote(reference), "public, max-age=31536000, immutable"
+	}
+
+	metadata, err := fetchShare(reference, key, h.Store)
+	switch {
+	case errors.Is(err, errMissingKey):
+		apiError(w, http.StatusUnauthorized, "%v: pass it as ?key= or in the %s header", err, KeyHeader)
+		return
+	case errors.Is(err, ErrInvalidMetadata):
+		apiError(w, http.StatusUnprocessableEntity, "%v", err)
+		return
+	case err != nil:
+		apiError(w, http.StatusBadGateway, "%v", err)
+		return
+	}
+
+	header := w.Header()
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	header.Set("Cache-Control", cacheControl)
+	header.Set("Content-Type", contentType(metadata.Filename))
+	header.Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": metadata.Filename}))
+	if match := r.Header.Get("If-None-Match"); etag != "" && (match == etag || match == "*") {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Without the size, ranges cannot be resolved; send the whole file
+	if metadata.Size <= 0 {
+		if r.Method != http.MethodHead {
+			streamShare(w, metadata, h.Store, 0, -1, http.StatusOK)
+		}
+		return
+	}
+	header.Set("Accept-Ranges", "bytes")
+
+	offset, length := int64(0), metadata.Size
+	status := http.StatusOK
+	if spec := r.Header.Get("Range"); spec != "" && ifRange(r, etag) {
+		start, n, err := parseRange(spec, metadata.Size)
+		switch {
+		case errors.Is(err, errRangeNotSatisfiable):
+			header.Set("Content-Range", fmt.Sprintf("bytes */%d", metadata.Size))
+			apiError(w, http.StatusRequestedRangeNotSatisfiable, "%v", err)
+			return
+		case err == nil:
+			offset, length, status = start, n, http.StatusPartialContent
+			header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, metadata.Size))
+		}
+		// Malformed or multiple ranges are ignored and the whole file is sent
+	}
+	header.Set("Content-Length", strconv.FormatInt(length, 10))
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	streamShare(w, metadata, h.Store, offset, length, status)
+}
+
+// errMissingKey is returned by fetchShare for an encrypted share without a key
+var errMissingKey = errors.New("share is encrypted and has no key")
+
+// fetchShare downloads the metadata of a share, replacing its key with key
+// unless that is empty, and validates it
+func fetchShare(reference string, key string, store Store) (*Metadata, error) {
+	data, err := store.Download(reference)
+	if err != nil {
+		return nil, err
+	}
+	var metadata Metadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidMetadata, err)
+	}
+	if key != "" {
+		// Accept base64url as found in link fragments, and a "+" the query turned into a space
+		key = strings.NewReplacer("-", "+", "_", "/", " ", "+").Replace(key)
+		if padding := len(key) % 4; padding != 0 {
+			key += strings.Repeat("=", 4-padding)
+		}
+		metadata.Key = key
+	}
+	if metadata.Encrypted && metadata.Key == "" {
+		return nil, errMissingKey
+	}
+	if err := metadata.Validate(); err != nil {
+		return nil, err
+	}
+	return &metadata, nil
+}
+
+// streamShare writes length bytes of a file from offset, or all of it if
+// length is negative. Errors after the first byte cut the response short.
+func streamShare(w http.ResponseWriter, metadata *Metadata, store Store, offset, length int64, status int) {
+	sent := &countingWriter{w: &deferredHeader{w: w, status: status}}
+	if _, err := DownloadRange(metadata, store, sent, offset, length); err != nil {
+		if sent.n == 0 {
+			for _, key := range []string{"Content-Length", "Content-Range", "Content-Disposition", "ETag", "Cache-Control"} {
+				w.Header().Del(key)
+			}
+			apiError(w, downloadStatus(err), "%v", err)
+			return
+		}
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// deferredHeader sends the status with the first byte, so that an error before
+// it can still be reported with its own status
+type deferredHeader struct {
+	w      http.ResponseWriter
+	status int
+	sent   bool
+}
+
+func (d *deferredHeader) Write(p []byte) (int, error) {
+	if !d.sent {
+		d.w.WriteHeader(d.status)
+	}
+	d.sent = true
+	return d.w.Write(p)
+}
+
+// DownloadRange writes length bytes of the file described by metadata, starting
+// at offset, or everything from offset if length is negative. Unencrypted and
+// per-chunk encrypted files only download the pieces covering the range; files
+// encrypted as a whole are downloaded completely.
+func DownloadRange(metadata *Metadata, store Store, w io.Writer, offset, length int64) (int64, error) {
+	if !metadata.Addressable() {
+		window := &windowWriter{w: w, skip: offset, left: length}
+		if _, err := DownloadStream(metadata, store, window, nil); err != nil && !errors.Is(err, errWindowFull) {
+			return window.written, err
+		}
+		return window.written, nil
+	}
+
+	f, err := NewFile(metadata, store)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	f.SetCacheSize(1)
+	if length < 0 {
+		length = max(metadata.Size-offset, 0)
+	}
+	return io.Copy(w, io.NewSectionReader(f, offset, length))
+}
+
+// Addressable reports whether byte offsets of the file map to pieces that can
+// be read on their own, which needs the size, the chunk sizes of chunked files
+// and no encryption over the whole file
+func (m *Metadata) Addressable() bool {
+	return m.Size > 0 && m.ChunkOffsets() != nil && (!m.Chunked || !m.Encrypted || m.PerChunkEncryption())
+}
+
+// errWindowFull stops a download once a windowWriter has everything it needs
+var errWindowFull = errors.New("range complete")
+
+// windowWriter passes on the bytes of a stream between skip and skip+left
+type windowWriter struct {
+	w       io.Writer
+	skip    int64
+	left    int64 // Negative for no limit
+	written int64
+}
+
+func (ww *windowWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	if ww.skip >= int64(len(p)) {
+		ww.skip -= int64(len(p))
+		return total, nil
+	}
+	p = p[ww.skip:]
+	ww.skip = 0
+	if ww.left >= 0 && int64(len(p)) > ww.left {
+		p = p[:ww.left]
+	}
+	n, err := ww.w.Write(p)
+	ww.written += int64(n)
+	if ww.left >= 0 {
+		ww.left -= int64(n)
+	}
+	if err != nil {
+		return n, err
+	}
+	if ww.left == 0 {
+		return total, errWindowFull
+	}
+	return total, nil
+}
+
+// parseRange parses a single-range Range header against a file of size bytes
+// and returns the start and length it selects
+func parseRange(spec string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(spec, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, fmt.Errorf("unsupported range %q", spec)
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid range %q", spec)
+	}
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("invalid range %q", spec)
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		n = min(n, size)
+		return size - n, n, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, fmt.Errorf("invalid range %q", spec)
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, fmt.Errorf("invalid range %q", spec)
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, nil
+}
+
+// ifRange reports whether a Range header applies given the If-Range precondition
+func ifRange(r *http.Request, etag string) bool {
+	value := r.Header.Get("If-Range")
+	return value == "" || value == etag
+}
+
+// contentType guesses the media type of a file from its name
+func contentType(filename string) string {
+	if t := mime.TypeByExtension(filepath.Ext(filename)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
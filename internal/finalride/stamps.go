@@ -0,0 +1,367 @@
+package finalride
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// BatchAuto selects a usable postage batch for every upload
+const BatchAuto = "auto"
+
+// Swarm chunk layout used to estimate stamp usage
+const (
+	SwarmChunkSize = 4096 // Payload bytes per Swarm chunk
+	refsPerChunk   = 128  // 32-byte references per intermediate chunk
+	manifestChunks = 2    // Chunks of the manifest /bzz wraps around a file
+	gcmOverhead    = 28   // Nonce and tag added by EncryptData
+
+	// Metadata document size, per piece and fixed, as written by UploadMetadata
+	metadataPieceBytes = 170
+	metadataBaseBytes  = 400
+)
+
+// BlockTime is the block interval of the chain postage batches are paid on
+const BlockTime = 5 * time.Second
+
+// plurPerBZZ is the number of PLUR, the smallest unit, in one BZZ
+var plurPerBZZ = big.NewInt(1e16)
+
+var batchIDPattern = regexp.MustCompile(`^[0-9a-fA-F]{64}$`)
+
+// ErrNoUsableBatch is returned when a node has no postage batch with room for an upload
+var ErrNoUsableBatch = errors.New("no usable postage batch")
+
+// PostageBatch is a postage batch as listed by a Bee node
+type PostageBatch struct {
+	BatchID     string `json:"batchID"`
+	Utilization int64  `json:"utilization"` // Chunks in the fullest bucket
+	Usable      bool   `json:"usable"`
+	Exists      bool   `json:"exists"`
+	Label       string `json:"label"`
+	Depth       uint   `json:"depth"`
+	BucketDepth uint   `json:"bucketDepth"`
+	Amount      string `json:"amount"` // PLUR per chunk paid so far
+	Immutable   bool   `json:"immutableFlag"`
+	TTL         int64  `json:"batchTTL"` // Seconds until the batch expires
+}
+
+// Capacity returns the number of chunks the batch can stamp in total
+func (b PostageBatch) Capacity() int64 {
+	return int64(1) << b.Depth
+}
+
+// Remaining estimates how many more chunks the batch can stamp. Chunks fill
+// 2^BucketDepth buckets and the batch is full once any bucket is, so every
+// bucket is assumed to be as full as the fullest one.
+func (b PostageBatch) Remaining() int64 {
+	if b.Depth < b.BucketDepth {
+		return 0
+	}
+	free := int64(1)<<(b.Depth-b.BucketDepth) - b.Utilization
+	if free < 0 {
+		return 0
+	}
+	return free << b.BucketDepth
+}
+
+// AmountPLUR returns the per-chunk amount of the batch
+func (b PostageBatch) AmountPLUR() *big.Int {
+	amount, ok := new(big.Int).SetString(b.Amount, 10)
+	if !ok {
+		return new(big.Int)
+	}
+	return amount
+}
+
+// ValidBatchID reports whether s looks like a postage batch ID
+func ValidBatchID(s string) bool {
+	return batchIDPattern.MatchString(s)
+}
+
+// ListStamps returns the postage batches owned by a Bee node
+func ListStamps(apiEndpoint string) ([]PostageBatch, error) {
+	resp, err := http.Get(apiEndpoint + "/stamps")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to list stamps: %s - %s", resp.Status, string(body))
+	}
+
+	var response struct {
+		Stamps []PostageBatch `json:"stamps"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	return response.Stamps, nil
+}
+
+// BuyStamp buys a postage batch of 2^depth chunks paid with amount PLUR per
+// chunk and returns its ID. The batch is usable once the purchase is confirmed
+// on chain, which takes a few blocks.
+func BuyStamp(amount *big.Int, depth uint, label string, immutable bool, apiEndpoint string) (string, error) {
+	endpoint := fmt.Sprintf("%s/stamps/%s/%d", apiEndpoint, amount, depth)
+	if label != "" {
+		endpoint += "?label=" + url.QueryEscape(label)
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	if immutable {
+		req.Header.Set("Immutable", "true")
+	}
+	return stampRequest(req, http.StatusCreated)
+}
+
+// TopUpStamp adds amount PLUR per chunk to a batch, extending its lifetime
+func TopUpStamp(batchID string, amount *big.Int, apiEndpoint string) error {
+	req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/stamps/topup/%s/%s", apiEndpoint, batchID, amount), nil)
+	if err != nil {
+		return err
+	}
+	_, err = stampRequest(req, http.StatusAccepted)
+	return err
+}
+
+// DiluteStamp increases the depth of a batch, doubling its capacity for every
+// step and halving its remaining lifetime accordingly
+func DiluteStamp(batchID string, depth uint, apiEndpoint string) error {
+	req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("%s/stamps/dilute/%s/%d", apiEndpoint, batchID, depth), nil)
+	if err != nil {
+		return err
+	}
+	_, err = stampRequest(req, http.StatusAccepted)
+	return err
+}
+
+// stampRequest sends a stamp transaction and returns the affected batch ID
+func stampRequest(req *http.Request, want int) (string, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		body, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("stamp request failed: %s - %s", resp.Status, string(body))
+	}
+
+	var response struct {
+		BatchID string `json:"batchID"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", err
+	}
+	return response.BatchID, nil
+}
+
+// CurrentPrice returns the storage price in PLUR per chunk per block
+func CurrentPrice(apiEndpoint string) (*big.Int, error) {
+	resp, err := http.Get(apiEndpoint + "/chainstate")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to read chain state: %s - %s", resp.Status, string(body))
+	}
+
+	// Bee encodes the price as a string, older versions as a number
+	var response struct {
+		CurrentPrice json.RawMessage `json:"currentPrice"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	price, ok := new(big.Int).SetString(strings.Trim(string(response.CurrentPrice), `"`), 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid price %s", response.CurrentPrice)
+	}
+	return price, nil
+}
+
+// AmountForTTL returns the per-chunk amount that keeps a batch alive for ttl at
+// the given price per block
+func AmountForTTL(price *big.Int, ttl time.Duration) *big.Int {
+	blocks := int64((ttl + BlockTime - 1) / BlockTime)
+	return new(big.Int).Mul(price, big.NewInt(blocks))
+}
+
+// SelectBatch returns the usable batch with room for the given number of chunks
+// that lives longest
+func SelectBatch(batches []PostageBatch, chunks int64) (PostageBatch, error) {
+	var best PostageBatch
+	var found bool
+	var largest int64
+	for _, b := range batches {
+		if !b.Usable || !b.Exists {
+			continue
+		}
+		if b.Remaining() > largest {
+			largest = b.Remaining()
+		}
+		if b.Remaining() < chunks {
+			continue
+		}
+		if !found || b.TTL > best.TTL {
+			best, found = b, true
+		}
+	}
+	if !found {
+		return best, fmt.Errorf("%w: need %d slots, largest batch has %d left", ErrNoUsableBatch, chunks, largest)
+	}
+	return best, nil
+}
+
+// UploadEstimate is the expected footprint of an upload on Swarm
+type UploadEstimate struct {
+	Pieces int   // Pieces stored with /bzz, excluding the metadata document
+	Bytes  int64 // Bytes stored, including encryption overhead and metadata
+	Chunks int64 // Swarm chunks, each of which takes one stamp slot
+}
+
+// EstimateUpload predicts the stamp slots an upload of size bytes will use when
+// split into pieces of chunkSize bytes, optionally encrypted
+func EstimateUpload(size int64, chunkSize int, encrypt bool) UploadEstimate {
+	var overhead int64
+	if encrypt {
+		overhead = gcmOverhead
+	}
+
+	var e UploadEstimate
+	add := func(n int64, count int64) {
+		e.Pieces += int(count)
+		e.Bytes += (n + overhead) * count
+		e.Chunks += swarmChunks(n+overhead) * count
+	}
+	full := size / int64(chunkSize)
+	last := size % int64(chunkSize)
+	switch {
+	case full == 0 || (full == 1 && last == 0):
+		add(size, 1)
+	case last == 0:
+		add(int64(chunkSize), full)
+	default:
+		add(int64(chunkSize), full)
+		add(last, 1)
+	}
+
+	metadata := int64(metadataBaseBytes)
+	if e.Pieces > 1 {
+		metadata += int64(e.Pieces) * metadataPieceBytes
+	}
+	e.Bytes += metadata
+	e.Chunks += swarmChunks(metadata)
+	return e
+}
+
+// Cost returns the PLUR spent on the estimated slots of a batch paid with
+// amount PLUR per chunk
+func (e UploadEstimate) Cost(amount *big.Int) *big.Int {
+	return new(big.Int).Mul(amount, big.NewInt(e.Chunks))
+}
+
+// swarmChunks returns the number of chunks /bzz stores for n bytes: the data
+// chunks, the intermediate chunks of the tree over them and the manifest
+func swarmChunks(n int64) int64 {
+	chunks := (n + SwarmChunkSize - 1) / SwarmChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	total := chunks
+	for chunks > 1 {
+		chunks = (chunks + refsPerChunk - 1) / refsPerChunk
+		total += chunks
+	}
+	return total + manifestChunks
+}
+
+// FormatBZZ formats an amount of PLUR in BZZ
+func FormatBZZ(plur *big.Int) string {
+	s := new(big.Rat).SetFrac(plur, plurPerBZZ).FloatString(16)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	return s + " BZZ"
+}
+
+// PrepareStamps chooses the postage batch each endpoint stamps the following
+// uploads with and returns the choice by endpoint URL. With BatchAuto, every
+// endpoint that lists stamps gets its longest-lived batch with room for the
+// given number of chunks; endpoints that do not, such as public gateways, stamp
+// uploads themselves. Any other non-empty setting is a batch ID for swarm_api,
+// the first endpoint.
+func (p *Pool) PrepareStamps(setting string, chunks int64) (map[string]PostageBatch, error) {
+	p.mu.Lock()
+	p.batches = nil
+	p.mu.Unlock()
+
+	chosen := make(map[string]PostageBatch)
+	for i := range p.Status() {
+		url := p.url(i)
+		batch, ok, err := stampFor(setting, url, i == 0, chunks)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			chosen[url] = batch
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.batches = make(map[int]string)
+	for i, e := range p.endpoints {
+		if batch, ok := chosen[e.URL]; ok {
+			p.batches[i] = batch.BatchID
+		}
+	}
+	return chosen, nil
+}
+
+// stampFor picks the batch that stamps chunks slots uploaded to apiEndpoint,
+// the primary endpoint being swarm_api. It returns false when the endpoint
+// stamps uploads itself.
+func stampFor(setting string, apiEndpoint string, primary bool, chunks int64) (PostageBatch, bool, error) {
+	switch setting {
+	case "":
+		return PostageBatch{}, false, nil
+	case BatchAuto:
+		batches, err := ListStamps(apiEndpoint)
+		if err != nil {
+			return PostageBatch{}, false, nil
+		}
+		batch, err := SelectBatch(batches, chunks)
+		if err != nil {
+			return PostageBatch{}, false, fmt.Errorf("%s: %w", apiEndpoint, err)
+		}
+		return batch, true, nil
+	}
+	if !primary {
+		return PostageBatch{}, false, nil
+	}
+	// Details are only for reporting; the node rejects an unusable batch itself
+	batch := PostageBatch{BatchID: setting}
+	if batches, err := ListStamps(apiEndpoint); err == nil {
+		for _, b := range batches {
+			if strings.EqualFold(b.BatchID, setting) {
+				batch = b
+			}
+		}
+	}
+	return batch, true, nil
+}
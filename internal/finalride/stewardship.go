@@ -0,0 +1,197 @@
+package finalride
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrNotReproducible is returned when a share cannot be rebuilt from a local
+// copy because its stored pieces are encrypted with random nonces
+var ErrNotReproducible = errors.New("encrypted pieces cannot be rebuilt from a local copy")
+
+// StewardResult holds the stewardship outcome for one reference of a share
+type StewardResult struct {
+	Index       string // Chunk key, "file" or "metadata"
+	Reference   string
+	Retrievable bool // Whether the network can serve the reference, if Err is nil
+	Err         error
+}
+
+// IsRetrievable asks a Bee node whether every chunk of a reference can be
+// retrieved from the network
+func IsRetrievable(reference string, apiEndpoint string) (bool, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/stewardship/%s", apiEndpoint, reference))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("stewardship check failed: %s - %s", resp.Status, string(body))
+	}
+
+	var response struct {
+		IsRetrievable bool `json:"isRetrievable"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return false, err
+	}
+	return response.IsRetrievable, nil
+}
+
+// Reupload asks a Bee node to push a reference to the network again. The node
+// must hold the content locally, e.g. because it is pinned there.
+func Reupload(reference string, apiEndpoint string) error {
+	req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/stewardship/%s", apiEndpoint, reference), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("reupload failed: %s - %s", resp.Status, string(body))
+	}
+	return nil
+}
+
+// CheckShare checks the retrievability of every piece and the metadata
+// document of a share. The optional callback is invoked as each result becomes
+// available.
+func CheckShare(reference string, metadata *Metadata, apiEndpoint string, onResult func(StewardResult)) []StewardResult {
+	return walkShare(ShareReferences(reference, metadata), onResult, func(p Piece) (bool, error) {
+		return IsRetrievable(p.Reference, apiEndpoint)
+	})
+}
+
+// ReuploadShare re-pushes every piece and the metadata document of a share
+// through a node's stewardship endpoint
+func ReuploadShare(reference string, metadata *Metadata, apiEndpoint string, onResult func(StewardResult)) []StewardResult {
+	return walkShare(ShareReferences(reference, metadata), onResult, func(p Piece) (bool, error) {
+		if err := Reupload(p.Reference, apiEndpoint); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+}
+
+// ReuploadFromFile rebuilds the pieces of a share from a local copy of the file
+// and uploads them again, followed by the original metadata document. This works
+// for unencrypted shares and for content-defined chunks encrypted under derived
+// keys. Every piece is checked against its recorded hash before upload, so a
+// different file is rejected instead of producing new references.
+func ReuploadFromFile(reference string, document []byte, r io.Reader, store Store, onResult func(StewardResult)) ([]StewardResult, error) {
+	metadata, err := ParseMetadata(document)
+	if err != nil {
+		return nil, err
+	}
+	var key []byte
+	if metadata.Encrypted {
+		if metadata.EncryptionMode != EncryptionConvergent && metadata.EncryptionMode != EncryptionKeyed {
+			return nil, ErrNotReproducible
+		}
+		if key, err = base64.StdEncoding.DecodeString(metadata.Key); err != nil {
+			return nil, fmt.Errorf("%w: failed to decode encryption key: %v", ErrDecryption, err)
+		}
+	}
+
+	// A single piece is the whole file; chunks are cut at the recorded sizes
+	reader := bufio.NewReader(r)
+	if metadata.Chunked && metadata.ChunkSize <= 0 && metadata.Chunking != ChunkingContentDefined {
+		return nil, fmt.Errorf("%w: missing chunk size, cannot split the local copy", ErrInvalidMetadata)
+	}
+	next := func(p Piece) ([]byte, error) {
+		if !metadata.Chunked {
+			return io.ReadAll(reader)
+		}
+		size := metadata.ChunkSize
+		if metadata.Chunking == ChunkingContentDefined {
+			size = metadata.ChunkLengths[p.Index]
+		}
+		buf := make([]byte, size)
+		n, err := io.ReadFull(reader, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		return buf[:n], err
+	}
+
+	results, err := walkShareUntil(metadata.Pieces(), onResult, func(p Piece) (bool, error) {
+		data, err := next(p)
+		if err != nil {
+			return false, fmt.Errorf("failed to read local copy: %v", err)
+		}
+		if key != nil {
+			chunkKey, err := metadata.chunkKey(p, key)
+			if err != nil {
+				return false, fmt.Errorf("%w: %v", ErrDecryption, err)
+			}
+			if data, err = EncryptDeterministic(data, chunkKey); err != nil {
+				return false, fmt.Errorf("%w: %v", ErrEncryption, err)
+			}
+		}
+		if err := checkHash(data, p.Hash); err != nil {
+			return false, fmt.Errorf("local copy does not match: %w", err)
+		}
+		if err := copyTo(store, data, p.Reference); err != nil {
+			return false, err
+		}
+		return true, nil
+	})
+	if err != nil {
+		return results, err
+	}
+	if _, err := reader.Peek(1); err != io.EOF {
+		return results, fmt.Errorf("local copy does not match: longer than the share")
+	}
+
+	result := StewardResult{Index: "metadata", Reference: reference, Retrievable: true}
+	if result.Err = copyTo(store, document, reference); result.Err != nil {
+		result.Retrievable = false
+	}
+	results = append(results, result)
+	if onResult != nil {
+		onResult(result)
+	}
+	return results, result.Err
+}
+
+func walkShare(pieces []Piece, onResult func(StewardResult), apply func(Piece) (bool, error)) []StewardResult {
+	results := make([]StewardResult, 0, len(pieces))
+	for _, p := range pieces {
+		result := StewardResult{Index: p.Index, Reference: p.Reference}
+		result.Retrievable, result.Err = apply(p)
+		results = append(results, result)
+		if onResult != nil {
+			onResult(result)
+		}
+	}
+	return results
+}
+
+// walkShareUntil is walkShare that stops at the first error
+func walkShareUntil(pieces []Piece, onResult func(StewardResult), apply func(Piece) (bool, error)) ([]StewardResult, error) {
+	var results []StewardResult
+	for _, p := range pieces {
+		result := StewardResult{Index: p.Index, Reference: p.Reference}
+		result.Retrievable, result.Err = apply(p)
+		results = append(results, result)
+		if onResult != nil {
+			onResult(result)
+		}
+		if result.Err != nil {
+			return results, result.Err
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,129 @@
+package finalride
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// errFeedUnknown is returned by resolveFeed for stores that cannot tell feed
+// manifests apart
+var errFeedUnknown = errors.New("store cannot resolve feeds")
+
+// errRawUnknown is returned for stores that cannot read raw Swarm data
+var errRawUnknown = errors.New("store cannot read raw Swarm data")
+
+// Store stores and retrieves content by Swarm reference
+type Store interface {
+	Upload(data []byte) (string, error)
+	Download(reference string) ([]byte, error)
+	Check(reference string) error
+}
+
+// VerifiedDownloader is implemented by stores that check downloaded data against
+// its hash themselves, for example to fall back to another gateway on a mismatch
+type VerifiedDownloader interface {
+	DownloadVerified(reference, hash string) ([]byte, error)
+}
+
+// FeedResolver is implemented by stores that can tell whether a reference is a
+// feed manifest, whose content changes with each update, and what it currently
+// resolves to
+type FeedResolver interface {
+	ResolveFeed(reference string) (resolved string, feed bool, err error)
+}
+
+// RawDownloader is implemented by stores that can read raw Swarm data, such as
+// the nodes of Bee collection manifests, and check it against its reference
+type RawDownloader interface {
+	DownloadBytes(reference string) ([]byte, error) // Joined data, as uploaded through /bytes
+	DownloadChunk(reference string) ([]byte, error) // One chunk: its span, then its payload
+}
+
+// Gateway is a Store backed by a single Swarm API endpoint
+type Gateway string
+
+// Upload stores data on the gateway
+func (g Gateway) Upload(data []byte) (string, error) {
+	return UploadToSwarm(data, string(g))
+}
+
+// Download retrieves a reference from the gateway
+func (g Gateway) Download(reference string) ([]byte, error) {
+	return DownloadFromSwarm(reference, string(g))
+}
+
+// Check reports whether the gateway can retrieve a reference
+func (g Gateway) Check(reference string) error {
+	return CheckSwarm(reference, string(g))
+}
+
+// ResolveFeed reports whether a reference is a feed manifest on the gateway
+func (g Gateway) ResolveFeed(reference string) (string, bool, error) {
+	return ResolveFeedManifest(reference, string(g))
+}
+
+// DownloadBytes retrieves raw data from the gateway and checks it
+func (g Gateway) DownloadBytes(reference string) ([]byte, error) {
+	data, err := getFromSwarm(context.Background(), "bytes", reference, string(g))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAddress(data, reference); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// DownloadChunk retrieves a single chunk from the gateway and checks it
+func (g Gateway) DownloadChunk(reference string) ([]byte, error) {
+	data, err := getFromSwarm(context.Background(), "chunks", reference, string(g))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkChunk(data, reference); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// resolveFeed asks a store whether a reference is a feed manifest
+func resolveFeed(store Store, reference string) (string, bool, error) {
+	if r, ok := store.(FeedResolver); ok {
+		return r.ResolveFeed(reference)
+	}
+	return "", false, errFeedUnknown
+}
+
+// rawDownloader returns the store's raw data interface, if it has one
+func rawDownloader(store Store) (RawDownloader, error) {
+	if r, ok := store.(RawDownloader); ok {
+		return r, nil
+	}
+	return nil, errRawUnknown
+}
+
+// downloadPiece downloads a reference and checks it against its SHA-256 hash,
+// returning an ErrIntegrity error on a mismatch
+func downloadPiece(store Store, reference, hash string) ([]byte, error) {
+	if v, ok := store.(VerifiedDownloader); ok {
+		return v.DownloadVerified(reference, hash)
+	}
+
+	data, err := store.Download(reference)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkHash(data, hash); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func checkHash(data []byte, hash string) error {
+	if got := fmt.Sprintf("%x", sha256.Sum256(data)); got != hash {
+		return fmt.Errorf("%w: hash mismatch: got %s, want %s", ErrIntegrity, got, hash)
+	}
+	return nil
+}
@@ -0,0 +1,316 @@
+package finalride
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"strconv"
+)
+
+var (
+	// ErrIntegrity is returned when downloaded data does not match its recorded hash
+	ErrIntegrity = errors.New("integrity check failed")
+
+	// ErrEncryption is returned when a key cannot be generated or data cannot be encrypted
+	ErrEncryption = errors.New("encryption failed")
+
+	// ErrDecryption is returned when downloaded data cannot be decrypted
+	ErrDecryption = errors.New("decryption failed")
+)
+
+// UploadOptions controls a streaming upload
+type UploadOptions struct {
+	Filename  string
+	Encrypt   bool
+	ChunkSize int   // Chunk size in bytes, the average for content-defined chunking
+	Store     Store // Where pieces are stored, e.g. a Gateway or Pool
+
+	// Chunking is ChunkingFixed (the default) or ChunkingContentDefined.
+	// Encrypted content-defined chunks use keys derived from their content:
+	// keyed with DedupKey if it is set, and convergent otherwise.
+	Chunking string
+	DedupKey []byte
+
+	// Known maps piece hashes to references stored earlier, e.g. the result of
+	// KnownPieces for a previous version. Pieces listed there that the store
+	// can still serve are not uploaded again.
+	Known map[string]string
+
+	// OnProgress is called with the number of input bytes consumed each time a piece is stored
+	OnProgress func(n int64)
+
+	// OnReuse is called with the input bytes of each piece that was already stored
+	OnReuse func(n int64)
+}
+
+// UploadStream reads r to the end and stores it on Swarm one chunk at a time, so
+// memory use is bounded by the chunk size. Input that fits in a single chunk is
+// stored as one piece; larger encrypted input uses per-chunk encryption. The
+// returned metadata still has to be stored with UploadMetadata.
+func UploadStream(r io.Reader, opts UploadOptions) (*Metadata, error) {
+	if opts.ChunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size: %d", opts.ChunkSize)
+	}
+	known := make(map[string]string, len(opts.Known))
+	maps.Copy(known, opts.Known)
+	opts.Known = known
+
+	metadata := &Metadata{
+		Version:   1,
+		Filename:  opts.Filename,
+		Encrypted: opts.Encrypt,
+	}
+
+	var key []byte
+	if opts.Encrypt {
+		var err error
+		key, err = GenerateKey()
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to generate key: %v", ErrEncryption, err)
+		}
+		metadata.Key = base64.StdEncoding.EncodeToString(key)
+	}
+
+	reader := bufio.NewReader(r)
+	switch opts.Chunking {
+	case "", ChunkingFixed:
+	case ChunkingContentDefined:
+		// Empty input has no chunks to cut and is stored as a single piece
+		if _, err := reader.Peek(1); err != io.EOF {
+			return uploadContentDefined(reader, opts, metadata, key)
+		}
+	default:
+		return nil, fmt.Errorf("unknown chunking %q", opts.Chunking)
+	}
+
+	buf := make([]byte, opts.ChunkSize)
+	chunkIDs := make(map[string]string)
+	chunkHashes := make(map[string]string)
+
+	for index := 1; ; index++ {
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read input: %v", err)
+		}
+
+		// Peek ahead so a single-chunk input is stored in the compact layout
+		last := err != nil
+		if !last {
+			if _, err := reader.Peek(1); err == io.EOF {
+				last = true
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to read input: %v", err)
+			}
+		}
+
+		piece := buf[:n]
+		if opts.Encrypt {
+			piece, err = EncryptData(piece, key)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrEncryption, err)
+			}
+		}
+
+		ref, hash, err := storePiece(&opts, piece, int64(n))
+		if err != nil {
+			if index == 1 && last {
+				return nil, fmt.Errorf("failed to upload file: %v", err)
+			}
+			return nil, fmt.Errorf("failed to upload chunk %d: %v", index, err)
+		}
+		metadata.Size += int64(n)
+
+		if index == 1 && last {
+			metadata.FileID = ref
+			metadata.FileHash = hash
+			return metadata, nil
+		}
+
+		k := strconv.Itoa(index)
+		chunkIDs[k] = ref
+		chunkHashes[k] = hash
+		if last {
+			break
+		}
+	}
+
+	metadata.Chunked = true
+	metadata.ChunkSize = opts.ChunkSize
+	metadata.ChunkIDs = chunkIDs
+	metadata.ChunkHashes = chunkHashes
+	if opts.Encrypt {
+		metadata.Version = 2
+		metadata.EncryptionMode = EncryptionPerChunk
+	}
+	return metadata, nil
+}
+
+// uploadContentDefined is UploadStream for content-defined chunking. Every
+// chunk is stored separately, even if there is only one, so that later
+// versions can share it.
+func uploadContentDefined(r io.Reader, opts UploadOptions, metadata *Metadata, key []byte) (*Metadata, error) {
+	metadata.Version = 3
+	metadata.Chunked = true
+	metadata.Chunking = ChunkingContentDefined
+	metadata.ChunkSize = opts.ChunkSize
+	metadata.ChunkIDs = make(map[string]string)
+	metadata.ChunkHashes = make(map[string]string)
+	metadata.ChunkLengths = make(map[string]int)
+	if opts.Encrypt {
+		metadata.EncryptionMode = EncryptionConvergent
+		if opts.DedupKey != nil {
+			metadata.EncryptionMode = EncryptionKeyed
+		}
+		metadata.ChunkSecrets = make(map[string]string)
+	}
+
+	chunker := NewContentChunker(r, opts.ChunkSize)
+	for index := 1; ; index++ {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return metadata, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		k := strconv.Itoa(index)
+		piece := chunk
+		if opts.Encrypt {
+			chunkKey := DeriveChunkKey(chunk, opts.DedupKey)
+			if piece, err = EncryptDeterministic(chunk, chunkKey); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrEncryption, err)
+			}
+			wrapped, err := EncryptData(chunkKey, key)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrEncryption, err)
+			}
+			metadata.ChunkSecrets[k] = base64.StdEncoding.EncodeToString(wrapped)
+		}
+
+		ref, hash, err := storePiece(&opts, piece, int64(len(chunk)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload chunk %d: %v", index, err)
+		}
+		metadata.ChunkIDs[k] = ref
+		metadata.ChunkHashes[k] = hash
+		metadata.ChunkLengths[k] = len(chunk)
+		metadata.Size += int64(len(chunk))
+	}
+}
+
+// storePiece uploads a piece holding n input bytes and returns its reference and
+// hash. A piece listed in opts.Known that the store can still serve is reused
+// instead, and every stored piece is added to opts.Known for the rest of the upload.
+func storePiece(opts *UploadOptions, piece []byte, n int64) (string, string, error) {
+	hash := fmt.Sprintf("%x", sha256.Sum256(piece))
+	ref, reused := opts.Known[hash]
+	if reused && opts.Store.Check(ref) != nil {
+		reused = false
+	}
+	if !reused {
+		var err error
+		if ref, err = opts.Store.Upload(piece); err != nil {
+			return "", "", err
+		}
+		opts.Known[hash] = ref
+	}
+	if opts.OnProgress != nil {
+		opts.OnProgress(n)
+	}
+	if reused && opts.OnReuse != nil {
+		opts.OnReuse(n)
+	}
+	return ref, hash, nil
+}
+
+// KnownPieces maps the hash of every piece of the given shares to its
+// reference, for UploadOptions.Known
+func KnownPieces(metadata ...*Metadata) map[string]string {
+	known := make(map[string]string)
+	for _, m := range metadata {
+		for _, p := range m.Pieces() {
+			known[p.Hash] = p.Reference
+		}
+	}
+	return known
+}
+
+// UploadMetadata stores a metadata document and returns its reference
+func UploadMetadata(metadata *Metadata, store Store) (string, error) {
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to create metadata JSON: %v", err)
+	}
+	return store.Upload(data)
+}
+
+// DownloadStream downloads the file described by metadata into w, checking every
+// piece against its hash and returning the number of bytes written. Unencrypted
+// and per-chunk encrypted files are written chunk by chunk; files encrypted as a
+// whole are buffered until every chunk has arrived.
+//
+// onProgress, if set, is called with the stored size of each piece as it arrives.
+func DownloadStream(metadata *Metadata, store Store, w io.Writer, onProgress func(n int64)) (int64, error) {
+	var key []byte
+	if metadata.Encrypted {
+		var err error
+		key, err = base64.StdEncoding.DecodeString(metadata.Key)
+		if err != nil {
+			return 0, fmt.Errorf("%w: failed to decode encryption key: %v", ErrDecryption, err)
+		}
+	}
+
+	// Whole-file encryption can only be undone once all pieces are present
+	buffered := metadata.Encrypted && !metadata.PerChunkEncryption()
+	var ciphertext []byte
+	var written int64
+
+	for _, p := range metadata.Pieces() {
+		data, err := downloadPiece(store, p.Reference, p.Hash)
+		if err != nil {
+			return written, fmt.Errorf("failed to download %s: %w", p.Name(), err)
+		}
+		if onProgress != nil {
+			onProgress(int64(len(data)))
+		}
+
+		if buffered {
+			ciphertext = append(ciphertext, data...)
+			continue
+		}
+		if metadata.Encrypted {
+			data, err = metadata.decryptPiece(p, data, key)
+			if err != nil {
+				return written, fmt.Errorf("%w: %s: %v", ErrDecryption, p.Name(), err)
+			}
+		}
+		n, err := w.Write(data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	if buffered {
+		data, err := DecryptData(ciphertext, key)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %v", ErrDecryption, err)
+		}
+		n, err := w.Write(data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+
+	if metadata.Size > 0 && written != metadata.Size {
+		return written, fmt.Errorf("%w: got %d bytes, want %d", ErrIntegrity, written, metadata.Size)
+	}
+	return written, nil
+}
@@ -2,15 +2,64 @@ package finalride
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+// httpError is an unsuccessful HTTP response from a Swarm endpoint
+type httpError struct {
+	code    int
+	message string
+}
+
+func (e *httpError) Error() string { return e.message }
+
+// endpointFailure reports whether an error says the endpoint itself is failing:
+// a transport error, a server error or corrupt data, but not a client error
+// such as missing content
+func endpointFailure(err error) bool {
+	var status *httpError
+	if errors.As(err, &status) {
+		return status.code >= http.StatusInternalServerError
+	}
+	return true
+}
+
 // UploadToSwarm uploads data to Ethereum Swarm and returns its reference
 func UploadToSwarm(data []byte, apiEndpoint string) (string, error) {
-	resp, err := http.Post(apiEndpoint+"/bzz", "application/octet-stream", bytes.NewReader(data))
+	return uploadToSwarm(data, apiEndpoint, uploadHeaders{})
+}
+
+// uploadHeaders are optional Bee headers sent with an upload
+type uploadHeaders struct {
+	pin   bool   // Swarm-Pin: ask the node to pin the data
+	tag   uint32 // Swarm-Tag: count the chunks in this tag (0 for none)
+	batch string // Swarm-Postage-Batch-Id: stamp with this batch (empty lets the gateway stamp)
+}
+
+// uploadToSwarm uploads data with optional Bee headers
+func uploadToSwarm(data []byte, apiEndpoint string, headers uploadHeaders) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, apiEndpoint+"/bzz", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if headers.pin {
+		req.Header.Set("Swarm-Pin", "true")
+	}
+	if headers.batch != "" {
+		req.Header.Set("Swarm-Postage-Batch-Id", headers.batch)
+	}
+	if headers.tag != 0 {
+		req.Header.Set("Swarm-Tag", strconv.FormatUint(uint64(headers.tag), 10))
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return "", err
 	}
@@ -18,7 +67,7 @@ func UploadToSwarm(data []byte, apiEndpoint string) (string, error) {
 
 	if resp.StatusCode != http.StatusCreated {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("failed to upload to Swarm: %s - %s", resp.Status, string(body))
+		return "", &httpError{resp.StatusCode, fmt.Sprintf("failed to upload to Swarm: %s - %s", resp.Status, string(body))}
 	}
 
 	var response struct {
@@ -33,7 +82,23 @@ func UploadToSwarm(data []byte, apiEndpoint string) (string, error) {
 
 // DownloadFromSwarm downloads data from Ethereum Swarm using its reference
 func DownloadFromSwarm(reference string, apiEndpoint string) ([]byte, error) {
-	resp, err := http.Get(fmt.Sprintf("%s/bzz/%s", apiEndpoint, reference))
+	return downloadFromSwarm(context.Background(), reference, apiEndpoint)
+}
+
+// downloadFromSwarm is DownloadFromSwarm with a context, so that losing
+// requests of a race can be cancelled
+func downloadFromSwarm(ctx context.Context, reference string, apiEndpoint string) ([]byte, error) {
+	return getFromSwarm(ctx, "bzz", reference, apiEndpoint)
+}
+
+// getFromSwarm reads a reference through one of Bee's download APIs: "bzz"
+// for files and manifests, "bytes" for raw data or "chunks" for a single chunk
+func getFromSwarm(ctx context.Context, api, reference string, apiEndpoint string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/%s/%s", apiEndpoint, api, reference), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -41,7 +106,7 @@ func DownloadFromSwarm(reference string, apiEndpoint string) ([]byte, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to download from Swarm: %s - %s", resp.Status, string(body))
+		return nil, &httpError{resp.StatusCode, fmt.Sprintf("failed to download from Swarm: %s - %s", resp.Status, string(body))}
 	}
 
 	data, err := io.ReadAll(resp.Body)
@@ -51,3 +116,18 @@ func DownloadFromSwarm(reference string, apiEndpoint string) ([]byte, error) {
 
 	return data, nil
 }
+
+// PingSwarm checks that the Swarm API answers within the timeout
+func PingSwarm(apiEndpoint string, timeout time.Duration) error {
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(apiEndpoint)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("swarm API unavailable: %s", resp.Status)
+	}
+	return nil
+}
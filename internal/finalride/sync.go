@@ -0,0 +1,270 @@
+package finalride
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SyncStateName is the default sync state file, kept at the top of the synced
+// directory and never uploaded
+const SyncStateName = ".final-ride-sync.json"
+
+// Sync actions
+const (
+	SyncAdd       = "add"
+	SyncUpdate    = "update"
+	SyncDelete    = "delete"
+	SyncUnchanged = "unchanged"
+)
+
+// SyncState records the files of a directory as of its last sync
+type SyncState struct {
+	Manifest string              `json:"manifest,omitempty"` // Directory manifest of the last sync
+	Updated  time.Time           `json:"updated"`
+	Files    map[string]DirEntry `json:"files"` // By path
+}
+
+// SyncChange is one file of a sync plan
+type SyncChange struct {
+	Action string
+	Entry  DirEntry // The file as found on disk, or as last synced if deleted
+	Err    error    // Set by Sync if the upload failed
+}
+
+// SyncOptions controls a sync
+type SyncOptions struct {
+	Name   string        // Directory name recorded in the manifest
+	Upload UploadOptions // Settings for every file; Filename and Known are set per file
+
+	// OnChange is called after each new or changed file is uploaded or fails
+	OnChange func(SyncChange)
+}
+
+// SyncResult reports a finished sync
+type SyncResult struct {
+	Manifest  string // Reference of the new directory manifest
+	Directory *Directory
+	Uploaded  int64 // Bytes of the files uploaded
+	Failed    int   // Files that could not be uploaded; their last synced version is kept
+}
+
+// LoadSyncState reads a sync state file, returning an empty state if it does
+// not exist yet
+func LoadSyncState(path string) (*SyncState, error) {
+	state := &SyncState{Files: make(map[string]DirEntry)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sync state: %v", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse sync state %s: %v", path, err)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]DirEntry)
+	}
+	return state, nil
+}
+
+// SaveSyncState writes a sync state file, replacing the old one only once the
+// new one is complete
+func SaveSyncState(path string, state *SyncState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode sync state: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write sync state: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write sync state: %v", err)
+	}
+	return nil
+}
+
+// PlanSync compares the regular files under dir that pass the filter with the
+// state. Files whose size or modification time changed are hashed, so files
+// that were only touched are reported as unchanged. The file at skip, usually
+// the state file, is ignored. Changes are sorted by path.
+func PlanSync(dir string, state *SyncState, filter PathFilter, skip string) ([]SyncChange, error) {
+	found, err := scanDir(dir, filter, skip)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []SyncChange
+	seen := make(map[string]bool, len(found))
+	for _, entry := range found {
+		seen[entry.Path] = true
+		prev, ok := state.Files[entry.Path]
+		if ok && prev.Size == entry.Size && prev.ModTime.Equal(entry.ModTime) {
+			entry.Hash, entry.Reference = prev.Hash, prev.Reference
+			changes = append(changes, SyncChange{Action: SyncUnchanged, Entry: entry})
+			continue
+		}
+		if entry.Hash, err = hashFile(filepath.Join(dir, filepath.FromSlash(entry.Path))); err != nil {
+			return nil, err
+		}
+		switch {
+		case !ok:
+			changes = append(changes, SyncChange{Action: SyncAdd, Entry: entry})
+		case prev.Hash == entry.Hash:
+			entry.Reference = prev.Reference
+			changes = append(changes, SyncChange{Action: SyncUnchanged, Entry: entry})
+		default:
+			changes = append(changes, SyncChange{Action: SyncUpdate, Entry: entry})
+		}
+	}
+	for p, prev := range state.Files {
+		if !seen[p] {
+			changes = append(changes, SyncChange{Action: SyncDelete, Entry: prev})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Entry.Path < changes[j].Entry.Path })
+	return changes, nil
+}
+
+// Sync uploads the new and changed files of a plan, stores a directory
+// manifest of every file that remains, and updates the state to match. A
+// file that fails to upload keeps its last synced version in the manifest.
+func Sync(dir string, state *SyncState, changes []SyncChange, opts SyncOptions) (*SyncResult, error) {
+	result := &SyncResult{}
+	files := make(map[string]DirEntry, len(changes))
+	for i := range changes {
+		c := &changes[i]
+		switch c.Action {
+		case SyncUnchanged:
+			files[c.Entry.Path] = c.Entry
+			continue
+		case SyncDelete:
+			continue
+		}
+
+		c.Entry, c.Err = syncFile(dir, c.Entry, state.Files[c.Entry.Path], opts)
+		if c.Err == nil {
+			files[c.Entry.Path] = c.Entry
+			result.Uploaded += c.Entry.Size
+		} else {
+			result.Failed++
+			if prev, ok := state.Files[c.Entry.Path]; ok {
+				files[c.Entry.Path] = prev
+			}
+		}
+		if opts.OnChange != nil {
+			opts.OnChange(*c)
+		}
+	}
+
+	d := &Directory{Type: DirectoryType, Name: opts.Name, Created: time.Now().UTC(), Previous: state.Manifest, Files: []DirEntry{}}
+	for _, entry := range files {
+		d.Files = append(d.Files, entry)
+	}
+	sortEntries(d.Files)
+	manifest, err := UploadDirectory(d, opts.Upload.Store)
+	if err != nil {
+		return result, fmt.Errorf("failed to upload directory manifest: %v", err)
+	}
+	result.Manifest, result.Directory = manifest, d
+
+	state.Manifest, state.Updated, state.Files = manifest, d.Created, files
+	return result, nil
+}
+
+// syncFile uploads one file and returns its entry with the hash of the content
+// that was actually read, in case the file changed since it was planned. The
+// pieces of the previous version are offered for reuse.
+func syncFile(dir string, entry DirEntry, prev DirEntry, opts SyncOptions) (DirEntry, error) {
+	f, err := os.Open(filepath.Join(dir, filepath.FromSlash(entry.Path)))
+	if err != nil {
+		return entry, err
+	}
+	defer f.Close()
+
+	upload := opts.Upload
+	upload.Filename = filepath.Base(entry.Path)
+	if prev.Reference != "" && upload.Chunking == ChunkingContentDefined {
+		if metadata, err := FetchMetadata(prev.Reference, upload.Store); err == nil {
+			upload.Known = KnownPieces(metadata)
+		}
+	}
+
+	hash := sha256.New()
+	metadata, err := UploadStream(io.TeeReader(f, hash), upload)
+	if err != nil {
+		return entry, err
+	}
+	if entry.Reference, err = UploadMetadata(metadata, upload.Store); err != nil {
+		return entry, fmt.Errorf("failed to upload metadata: %v", err)
+	}
+	entry.Size, entry.Hash = metadata.Size, fmt.Sprintf("%x", hash.Sum(nil))
+	return entry, nil
+}
+
+// scanDir lists the regular files under dir that pass the filter, skipping
+// excluded directories, symbolic links and the file at skip
+func scanDir(dir string, filter PathFilter, skip string) ([]DirEntry, error) {
+	if skip != "" {
+		skip, _ = filepath.Abs(skip)
+	}
+	var entries []DirEntry
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == "." {
+			return nil
+		}
+		if d.IsDir() {
+			if filter.Excluded(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !filter.Match(rel) {
+			return nil
+		}
+		if abs, _ := filepath.Abs(p); abs == skip || abs == skip+".tmp" {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, DirEntry{Path: rel, Size: info.Size(), Mode: info.Mode().Perm(), ModTime: info.ModTime().UTC()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %v", dir, err)
+	}
+	return entries, nil
+}
+
+// hashFile returns the hex SHA-256 of a file
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,166 @@
+package finalride
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrSyncUnsupported is returned when no endpoint of an upload supports tags,
+// so sync progress cannot be tracked
+var ErrSyncUnsupported = errors.New("sync tracking not supported by the endpoint")
+
+// Tag holds the chunk counters of a Bee upload tag
+type Tag struct {
+	UID    uint32 `json:"uid"`
+	Split  int64  `json:"split"`  // Chunks the uploads were split into
+	Seen   int64  `json:"seen"`   // Chunks the node already had
+	Stored int64  `json:"stored"` // Chunks stored locally
+	Sent   int64  `json:"sent"`   // Chunks sent to the network
+	Synced int64  `json:"synced"` // Chunks confirmed by the network
+}
+
+// CreateTag creates an upload tag on a Bee node
+func CreateTag(apiEndpoint string) (*Tag, error) {
+	resp, err := http.Post(apiEndpoint+"/tags", "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTag(resp, http.StatusCreated)
+}
+
+// GetTag returns the current counters of an upload tag
+func GetTag(uid uint32, apiEndpoint string) (*Tag, error) {
+	resp, err := http.Get(fmt.Sprintf("%s/tags/%d", apiEndpoint, uid))
+	if err != nil {
+		return nil, err
+	}
+	return decodeTag(resp, http.StatusOK)
+}
+
+func decodeTag(resp *http.Response, want int) (*Tag, error) {
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("tag request failed: %s - %s", resp.Status, string(body))
+	}
+
+	var tag Tag
+	if err := json.NewDecoder(resp.Body).Decode(&tag); err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// SyncStatus sums the tags of an upload across the endpoints that received it
+type SyncStatus struct {
+	Split, Seen, Stored, Sent, Synced int64
+
+	Tags []EndpointTag // Tag used on each endpoint
+}
+
+// EndpointTag is the tag tracking an upload on one endpoint
+type EndpointTag struct {
+	URL string
+	Tag Tag
+}
+
+// Total returns the number of chunks that have to reach the network
+func (s SyncStatus) Total() int64 {
+	return s.Split - s.Seen
+}
+
+// Done reports whether every chunk has synced
+func (s SyncStatus) Done() bool {
+	return len(s.Tags) > 0 && s.Split > 0 && s.Synced >= s.Total()
+}
+
+// TrackSync starts tracking sync progress of the following uploads. Each
+// endpoint gets a new tag when it first receives data; endpoints that cannot
+// create tags are used without one.
+func (p *Pool) TrackSync() {
+	p.tagMu.Lock()
+	defer p.tagMu.Unlock()
+	p.tags = make(map[int]uint32)
+}
+
+// tagFor returns the tag for uploads to an endpoint, or 0 if uploads are not
+// tracked or the endpoint does not support tags
+func (p *Pool) tagFor(i int) uint32 {
+	p.tagMu.Lock()
+	defer p.tagMu.Unlock()
+	if p.tags == nil {
+		return 0
+	}
+	if uid, ok := p.tags[i]; ok {
+		return uid
+	}
+
+	// Creating the tag under tagMu keeps concurrent uploads to a single tag
+	var uid uint32
+	if tag, err := CreateTag(p.url(i)); err == nil {
+		uid = tag.UID
+	}
+	p.tags[i] = uid
+	return uid
+}
+
+// SyncStatus polls the tags of the tracked uploads
+func (p *Pool) SyncStatus() (SyncStatus, error) {
+	type tracked struct {
+		url string
+		uid uint32
+	}
+	var tags []tracked
+	p.tagMu.Lock()
+	for i := range p.Status() {
+		if uid := p.tags[i]; uid != 0 {
+			tags = append(tags, tracked{p.url(i), uid})
+		}
+	}
+	p.tagMu.Unlock()
+
+	var status SyncStatus
+	if len(tags) == 0 {
+		return status, ErrSyncUnsupported
+	}
+	for _, t := range tags {
+		tag, err := GetTag(t.uid, t.url)
+		if err != nil {
+			return status, fmt.Errorf("failed to read tag %d on %s: %v", t.uid, t.url, err)
+		}
+		status.Split += tag.Split
+		status.Seen += tag.Seen
+		status.Stored += tag.Stored
+		status.Sent += tag.Sent
+		status.Synced += tag.Synced
+		status.Tags = append(status.Tags, EndpointTag{t.url, *tag})
+	}
+	return status, nil
+}
+
+// WaitSynced polls the tracked uploads every interval until every chunk has
+// synced or the timeout expires. onProgress, if set, is called after each poll.
+func (p *Pool) WaitSynced(timeout, interval time.Duration, onProgress func(SyncStatus)) (SyncStatus, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := p.SyncStatus()
+		if err != nil {
+			return status, err
+		}
+		if onProgress != nil {
+			onProgress(status)
+		}
+		if status.Done() {
+			return status, nil
+		}
+		if time.Now().After(deadline) {
+			return status, fmt.Errorf("timed out after %s: %d of %d chunks synced", timeout, status.Synced, status.Total())
+		}
+		time.Sleep(interval)
+	}
+}
@@ -3,31 +3,54 @@ package finalride
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the structure of the config.yaml file
 type Config struct {
-	SwarmAPI       string `yaml:"swarm_api"`       // Swarm API endpoint
-	WebURL         string `yaml:"web_url"`         // Web frontend URL
-	DownloadLink   string `yaml:"download_link"`   // Download link template
-	ChunkSizeMB    int    `yaml:"chunk_size_mb"`   // Chunk size in MB
-	Theme          string `yaml:"theme"`           // UI Theme: "light" or "dark"
-	DownloadDir    string `yaml:"download_dir"`    // Default download directory
-	EncryptDefault bool   `yaml:"encrypt_default"` // Encrypt by default?
+	SwarmAPI             string   `yaml:"swarm_api"`              // Swarm API endpoint
+	Endpoints            []string `yaml:"endpoints,omitempty"`    // Fallback endpoints, tried in order after swarm_api
+	DownloadStrategy     string   `yaml:"download_strategy"`      // "failover", "hedge" or "race"
+	HedgeDelayMS         int      `yaml:"hedge_delay_ms"`         // Wait before asking the next endpoint when hedging
+	WebURL               string   `yaml:"web_url"`                // Web frontend URL
+	DownloadLink         string   `yaml:"download_link"`          // Download link template
+	ChunkSizeMB          int      `yaml:"chunk_size_mb"`          // Chunk size in MB
+	Chunking             string   `yaml:"chunking"`               // "fixed" or "content-defined"
+	ConvergentEncryption bool     `yaml:"convergent_encryption"`  // Derive content-defined chunk keys from the content alone
+	Theme                string   `yaml:"theme"`                  // UI Theme: "light" or "dark"
+	BrandName            string   `yaml:"brand_name"`             // Name shown by the web UI
+	DownloadDir          string   `yaml:"download_dir"`           // Default download directory
+	CacheDir             string   `yaml:"cache_dir"`              // Chunk cache location (empty for the user cache dir)
+	CacheSizeMB          int      `yaml:"cache_size_mb"`          // Chunk cache quota in MB, 0 to disable the cache
+	EncryptDefault       bool     `yaml:"encrypt_default"`        // Encrypt by default?
+	PinUploads           bool     `yaml:"pin_uploads"`            // Ask the receiving node to pin uploaded data, except on public gateways
+	PostageBatch         string   `yaml:"postage_batch"`          // "auto", a batch ID for swarm_api, or empty to let gateways stamp
+	APIToken             string   `yaml:"api_token,omitempty"`    // Bearer token the serve proxy sends to the endpoints
+	DaemonToken          string   `yaml:"daemon_token,omitempty"` // Bearer token clients of the daemon API must send
+
+	Profiles map[string]yaml.Node `yaml:"profiles,omitempty"` // Named overrides selected with --profile
+	Profile  string               `yaml:"-"`                  // Active profile, if any
 }
 
 // Metadata represents the file metadata stored in Swarm
 type Metadata struct {
-	Filename    string            `json:"filename"`
-	Encrypted   bool              `json:"encrypted"`
-	Key         string            `json:"key,omitempty"`          // Encryption key (only if encrypted)
-	Chunked     bool              `json:"chunked"`
-	FileID      string            `json:"file_id,omitempty"`      // Single file reference (if not chunked)
-	ChunkIDs    map[string]string `json:"chunk_ids,omitempty"`    // Chunk references (if chunked)
-	ChunkHashes map[string]string `json:"chunk_hashes,omitempty"` // Chunk hashes for integrity
-	FileHash    string            `json:"file_hash,omitempty"`    // File hash (if not chunked)
+	Version        int               `json:"version,omitempty"` // Schema version (missing means 1)
+	Filename       string            `json:"filename"`
+	Size           int64             `json:"size,omitempty"`       // Original file size in bytes
+	ChunkSize      int               `json:"chunk_size,omitempty"` // Chunk size in bytes before encryption (if chunked), the average for content-defined chunks
+	Chunking       string            `json:"chunking,omitempty"`   // "content-defined" if chunks are cut by content, empty for fixed-size
+	Encrypted      bool              `json:"encrypted"`
+	Key            string            `json:"key,omitempty"`             // Encryption key (only if encrypted)
+	EncryptionMode string            `json:"encryption_mode,omitempty"` // "per-chunk", "convergent" or "keyed" if each chunk is encrypted on its own
+	Chunked        bool              `json:"chunked"`
+	FileID         string            `json:"file_id,omitempty"`       // Single file reference (if not chunked)
+	ChunkIDs       map[string]string `json:"chunk_ids,omitempty"`     // Chunk references (if chunked)
+	ChunkHashes    map[string]string `json:"chunk_hashes,omitempty"`  // Chunk hashes for integrity
+	ChunkLengths   map[string]int    `json:"chunk_lengths,omitempty"` // Chunk sizes before encryption (if content-defined)
+	ChunkSecrets   map[string]string `json:"chunk_secrets,omitempty"` // Chunk keys encrypted with Key (if convergent or keyed)
+	FileHash       string            `json:"file_hash,omitempty"`     // File hash (if not chunked)
 }
 
 // LoadConfig reads and parses the config.yaml file
@@ -52,6 +75,11 @@ func SaveConfig(configPath string, config *Config) error {
 		return fmt.Errorf("failed to marshal config: %v", err)
 	}
 
+	if dir := filepath.Dir(configPath); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create config directory: %v", err)
+		}
+	}
 	if err := os.WriteFile(configPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write config file: %v", err)
 	}
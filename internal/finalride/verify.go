@@ -0,0 +1,68 @@
+package finalride
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Verification statuses
+const (
+	VerifyOK      = "ok"
+	VerifyMissing = "missing"
+	VerifyCorrupt = "corrupt"
+)
+
+// VerifyResult holds the outcome of checking a single stored piece
+type VerifyResult struct {
+	Index     string // Chunk key, or "file" for unchunked uploads
+	Reference string
+	Status    string
+	Err       error
+}
+
+// CheckSwarm checks that a reference is retrievable without downloading its content
+func CheckSwarm(reference string, apiEndpoint string) error {
+	resp, err := http.Head(fmt.Sprintf("%s/bzz/%s", apiEndpoint, reference))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &httpError{resp.StatusCode, fmt.Sprintf("reference not available: %s", resp.Status)}
+	}
+	return nil
+}
+
+// VerifyMetadata checks every piece referenced by the metadata. Unless existenceOnly
+// is set, each piece is downloaded and compared against its recorded hash. The
+// optional callback is invoked as each result becomes available.
+func VerifyMetadata(metadata *Metadata, store Store, existenceOnly bool, onResult func(VerifyResult)) []VerifyResult {
+	pieces := metadata.Pieces()
+	results := make([]VerifyResult, 0, len(pieces))
+	for _, p := range pieces {
+		result := VerifyResult{Index: p.Index, Reference: p.Reference, Status: VerifyOK}
+
+		if existenceOnly {
+			if err := store.Check(p.Reference); err != nil {
+				result.Status = VerifyMissing
+				result.Err = err
+			}
+		} else {
+			if _, err := downloadPiece(store, p.Reference, p.Hash); errors.Is(err, ErrIntegrity) {
+				result.Status = VerifyCorrupt
+				result.Err = err
+			} else if err != nil {
+				result.Status = VerifyMissing
+				result.Err = err
+			}
+		}
+
+		results = append(results, result)
+		if onResult != nil {
+			onResult(result)
+		}
+	}
+	return results
+}
@@ -0,0 +1,130 @@
+package finalride
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultWatchQuiet is how long a file must stay unchanged before WatchFolder
+// treats it as complete
+const DefaultWatchQuiet = 2 * time.Second
+
+// WatchOptions controls WatchFolder
+type WatchOptions struct {
+	Filter   PathFilter
+	Quiet    time.Duration          // Default DefaultWatchQuiet
+	Existing bool                   // Also report the files already in the folder
+	Skip     func(path string) bool // Files never reported, e.g. a log written by OnReady
+
+	// Sidecar is the suffix of files written next to uploaded ones, such as
+	// ".link". A file named after another file in the folder plus the suffix
+	// is never reported, including ones left by an earlier run.
+	Sidecar string
+
+	// OnReady is called with the path of each complete file, one at a time.
+	// A file written to again afterwards is reported again.
+	OnReady func(path string)
+}
+
+// pendingFile is a file that changed and has not been reported yet
+type pendingFile struct {
+	size    int64
+	modTime time.Time
+	changed time.Time // Last event or observed change
+}
+
+// WatchFolder reports files created or written in the top level of dir once
+// they have stopped changing, until ctx is cancelled. Changes are picked up
+// with inotify on Linux and by polling elsewhere.
+func WatchFolder(ctx context.Context, dir string, opts WatchOptions) error {
+	if opts.Quiet <= 0 {
+		opts.Quiet = DefaultWatchQuiet
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return fmt.Errorf("failed to watch %s: %v", dir, err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("failed to watch %s: not a directory", dir)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	events := make(chan string, 64)
+	errs := make(chan error, 1)
+	go func() { errs <- notify(ctx, dir, events) }()
+
+	pending := make(map[string]*pendingFile)
+	track := func(name string, now time.Time) {
+		if opts.Skip != nil && opts.Skip(filepath.Join(dir, name)) || isSidecar(dir, name, opts.Sidecar) || !opts.Filter.Match(name) {
+			return
+		}
+		if p, ok := pending[name]; ok {
+			p.changed = now
+		} else {
+			pending[name] = &pendingFile{size: -1, changed: now}
+		}
+	}
+	if opts.Existing {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %v", dir, err)
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				track(e.Name(), time.Now())
+			}
+		}
+	}
+
+	ticker := time.NewTicker(max(opts.Quiet/4, 50*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errs:
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err == nil {
+				err = errors.New("notifications stopped")
+			}
+			return fmt.Errorf("failed to watch %s: %v", dir, err)
+		case name := <-events:
+			track(name, time.Now())
+		case now := <-ticker.C:
+			for name, p := range pending {
+				info, err := os.Stat(filepath.Join(dir, name))
+				if err != nil || !info.Mode().IsRegular() {
+					delete(pending, name)
+					continue
+				}
+				if info.Size() != p.size || !info.ModTime().Equal(p.modTime) {
+					p.size, p.modTime, p.changed = info.Size(), info.ModTime(), now
+					continue
+				}
+				if now.Sub(p.changed) >= opts.Quiet {
+					delete(pending, name)
+					if ctx.Err() == nil {
+						opts.OnReady(filepath.Join(dir, name))
+					}
+				}
+			}
+		}
+	}
+}
+
+// isSidecar reports whether a file is named after another file in dir plus
+// the sidecar suffix
+func isSidecar(dir, name, suffix string) bool {
+	base, ok := strings.CutSuffix(name, suffix)
+	if suffix == "" || !ok || base == "" {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(dir, base))
+	return err == nil && info.Mode().IsRegular()
+}
@@ -0,0 +1,58 @@
+package finalride
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// notify sends the names of files created, written or moved into dir until
+// ctx is cancelled, using inotify
+func notify(ctx context.Context, dir string, events chan<- string) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return fmt.Errorf("inotify: %v", err)
+	}
+	// A non-blocking descriptor lets Close interrupt a pending Read
+	f := os.NewFile(uintptr(fd), "inotify")
+	defer f.Close()
+	if _, err := unix.InotifyAddWatch(fd, dir, unix.IN_CREATE|unix.IN_MODIFY|unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO); err != nil {
+		return fmt.Errorf("inotify: %v", err)
+	}
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + unix.SizeofInotifyEvent
+			offset = start + int(event.Len)
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				return fmt.Errorf("inotify: event queue overflowed")
+			}
+			if event.Mask&unix.IN_ISDIR != 0 || event.Len == 0 {
+				continue
+			}
+			name := string(bytes.TrimRight(buf[start:offset], "\x00"))
+			select {
+			case events <- name:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+//go:build !linux
+
+package finalride
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// watchPollInterval is how often notify lists the folder
+const watchPollInterval = time.Second
+
+// notify sends the names of files whose size or modification time changed in
+// dir until ctx is cancelled, by listing it every watchPollInterval
+func notify(ctx context.Context, dir string, events chan<- string) error {
+	type stamp struct {
+		size    int64
+		modTime time.Time
+	}
+	list := func() (map[string]stamp, error) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %v", dir, err)
+		}
+		files := make(map[string]stamp, len(entries))
+		for _, e := range entries {
+			if !e.Type().IsRegular() {
+				continue
+			}
+			if info, err := e.Info(); err == nil {
+				files[e.Name()] = stamp{info.Size(), info.ModTime()}
+			}
+		}
+		return files, nil
+	}
+
+	seen, err := list()
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		files, err := list()
+		if err != nil {
+			return err
+		}
+		for name, s := range files {
+			if old, ok := seen[name]; ok && old.size == s.size && old.modTime.Equal(s.modTime) {
+				continue
+			}
+			select {
+			case events <- name:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		seen = files
+	}
+}